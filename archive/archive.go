@@ -0,0 +1,245 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/companieshouse/chs.go/log"
+)
+
+// ErrVerificationFailed is returned when an archive file does not hold exactly the records written to it, in which
+// case the records are not deleted
+var ErrVerificationFailed = errors.New("archive file verification failed")
+
+const dateLayout = "2006-01-02"
+
+// Record is a single document to be archived
+type Record struct {
+	// ID is the value used to delete the document once it has been archived
+	ID interface{}
+	// Document is the document as a single line of JSON
+	Document []byte
+}
+
+// Source holds the records to be archived
+type Source interface {
+	// Collections returns the collections to archive
+	Collections() []string
+	// Oldest returns the date of the oldest record in collection dated before before, and false if there is none
+	Oldest(ctx context.Context, collection string, before time.Time) (time.Time, bool, error)
+	// ForEach calls fn with every record in collection dated from from (inclusive) to to (exclusive)
+	ForEach(ctx context.Context, collection string, from, to time.Time, fn func(Record) error) error
+	// Delete deletes the records in collection with the given IDs, returning the number deleted
+	Delete(ctx context.Context, collection string, ids []interface{}) (int64, error)
+}
+
+// Partition is a single day of records from a collection, which is archived to its own file
+type Partition struct {
+	Collection string `json:"collection"`
+	Date       string `json:"date"`
+	Records    int    `json:"records"`
+	File       string `json:"file,omitempty"`
+	Deleted    int64  `json:"deleted"`
+}
+
+// Archiver moves records older than a cutoff out of a Source and into gzipped JSON Lines files, one per collection
+// and day, beneath Path:
+//
+//	<Path>/<collection>/<yyyy>/<mm>/<collection>-<yyyy-mm-dd>-<run>.jsonl.gz
+//
+// where run is the time the archiver started, so that a second run never overwrites the files of the first. Records
+// are only deleted once their file has been written, synced and read back. A DryRun reports the partitions that would
+// be archived without writing or deleting anything.
+type Archiver struct {
+	Source Source
+	Path   string
+	DryRun bool
+}
+
+// Run archives every record dated before before, a day at a time, and reports each partition archived. Run stops at
+// the first partition that fails, leaving its records and those of later partitions in place.
+func (a *Archiver) Run(ctx context.Context, before time.Time) ([]Partition, error) {
+	run := time.Now().UTC().Format("20060102T150405Z")
+
+	var partitions []Partition
+	for _, collection := range a.Source.Collections() {
+		oldest, ok, err := a.Source.Oldest(ctx, collection, before)
+		if err != nil {
+			return partitions, fmt.Errorf("error finding oldest record in %s: %w", collection, err)
+		}
+		if !ok {
+			continue
+		}
+
+		for day := truncateToDay(oldest); day.Before(before); day = day.AddDate(0, 0, 1) {
+			if err := ctx.Err(); err != nil {
+				return partitions, err
+			}
+
+			to := day.AddDate(0, 0, 1)
+			if to.After(before) {
+				to = before
+			}
+
+			partition, err := a.archivePartition(ctx, collection, day, to, run)
+			if err != nil {
+				return partitions, fmt.Errorf("error archiving %s for %s: %w", collection, day.Format(dateLayout), err)
+			}
+			if partition.Records > 0 {
+				log.Info("archived partition", log.Data{"partition": partition, "dry_run": a.DryRun})
+				partitions = append(partitions, partition)
+			}
+		}
+	}
+	return partitions, nil
+}
+
+func (a *Archiver) archivePartition(ctx context.Context, collection string, from, to time.Time, run string) (Partition, error) {
+	partition := Partition{Collection: collection, Date: from.Format(dateLayout)}
+
+	if a.DryRun {
+		err := a.Source.ForEach(ctx, collection, from, to, func(Record) error {
+			partition.Records++
+			return nil
+		})
+		return partition, err
+	}
+
+	file := filepath.Join(a.Path, collection, from.Format("2006"), from.Format("01"),
+		fmt.Sprintf("%s-%s-%s.jsonl.gz", collection, partition.Date, run))
+
+	written, err := writePartition(ctx, a.Source, collection, from, to, file)
+	if err != nil {
+		return partition, err
+	}
+	partition.Records = len(written.ids)
+	if partition.Records == 0 {
+		return partition, nil
+	}
+	partition.File = file
+
+	if err := verify(file, written.hashes); err != nil {
+		return partition, err
+	}
+
+	partition.Deleted, err = a.Source.Delete(ctx, collection, written.ids)
+	if err != nil {
+		return partition, err
+	}
+	if partition.Deleted != int64(partition.Records) {
+		// The records are safely archived, so this is only reported - most likely something else deleted them first
+		log.Info("deleted fewer records than were archived", log.Data{"partition": partition})
+	}
+	return partition, nil
+}
+
+// written holds the IDs of the records written to a file, and the hash of each line in the order it was written
+type written struct {
+	ids    []interface{}
+	hashes [][sha256.Size]byte
+}
+
+// writePartition writes the records to file, which is only created once there is a record to write
+func writePartition(ctx context.Context, source Source, collection string, from, to time.Time, file string) (*written, error) {
+	var f *os.File
+	var gz *gzip.Writer
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+
+	w := &written{}
+	err := source.ForEach(ctx, collection, from, to, func(record Record) error {
+		if bytes.ContainsAny(record.Document, "\r\n") {
+			return fmt.Errorf("record [%v] is not a single line", record.ID)
+		}
+		if f == nil {
+			if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+				return err
+			}
+			// O_EXCL guards against ever overwriting an archive
+			var err error
+			if f, err = os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644); err != nil {
+				return err
+			}
+			gz = gzip.NewWriter(f)
+		}
+
+		line := make([]byte, 0, len(record.Document)+1)
+		line = append(append(line, record.Document...), '\n')
+		if _, err := gz.Write(line); err != nil {
+			return err
+		}
+		w.ids = append(w.ids, record.ID)
+		w.hashes = append(w.hashes, sha256.Sum256(line))
+		return nil
+	})
+	if f == nil {
+		return w, err
+	}
+	if err != nil {
+		// The records have not been deleted, so an incomplete file is of no use
+		f.Close()
+		f = nil
+		os.Remove(file)
+		return nil, err
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+	err = f.Close()
+	f = nil
+	return w, err
+}
+
+// verify reads file back and checks that it holds exactly the lines with the given hashes, in order
+func verify(file string, hashes [][sha256.Size]byte) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrVerificationFailed, err)
+	}
+	reader := bufio.NewReader(gz)
+
+	for i, hash := range hashes {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return fmt.Errorf("%w: line %d: %s", ErrVerificationFailed, i+1, err)
+		}
+		if sha256.Sum256(line) != hash {
+			return fmt.Errorf("%w: line %d does not match the record written", ErrVerificationFailed, i+1)
+		}
+	}
+	// Reading to the end also checks the gzip checksum
+	if _, err := reader.ReadByte(); err != io.EOF {
+		if err == nil {
+			return fmt.Errorf("%w: file holds more records than were written", ErrVerificationFailed)
+		}
+		return fmt.Errorf("%w: %s", ErrVerificationFailed, err)
+	}
+	return nil
+}
+
+func truncateToDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,207 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// memorySource is a Source holding records in memory
+type memorySource struct {
+	records   map[string][]memoryRecord
+	deleteErr error
+}
+
+type memoryRecord struct {
+	id       string
+	date     time.Time
+	document string
+}
+
+func (s *memorySource) Collections() []string {
+	var collections []string
+	for collection := range s.records {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+	return collections
+}
+
+func (s *memorySource) Oldest(_ context.Context, collection string, before time.Time) (time.Time, bool, error) {
+	var oldest time.Time
+	for _, r := range s.records[collection] {
+		if r.date.Before(before) && (oldest.IsZero() || r.date.Before(oldest)) {
+			oldest = r.date
+		}
+	}
+	return oldest, !oldest.IsZero(), nil
+}
+
+func (s *memorySource) ForEach(_ context.Context, collection string, from, to time.Time, fn func(Record) error) error {
+	for _, r := range s.records[collection] {
+		if !r.date.Before(from) && r.date.Before(to) {
+			if err := fn(Record{ID: r.id, Document: []byte(r.document)}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *memorySource) Delete(_ context.Context, collection string, ids []interface{}) (int64, error) {
+	if s.deleteErr != nil {
+		return 0, s.deleteErr
+	}
+	deleted := map[interface{}]bool{}
+	for _, id := range ids {
+		deleted[id] = true
+	}
+	var kept []memoryRecord
+	for _, r := range s.records[collection] {
+		if !deleted[r.id] {
+			kept = append(kept, r)
+		}
+	}
+	count := int64(len(s.records[collection]) - len(kept))
+	s.records[collection] = kept
+	return count, nil
+}
+
+func TestUnitArchiver(t *testing.T) {
+
+	day := func(d int) time.Time { return time.Date(2019, 3, d, 12, 0, 0, 0, time.UTC) }
+	before := time.Date(2019, 3, 3, 0, 0, 0, 0, time.UTC)
+
+	newSource := func() *memorySource {
+		return &memorySource{records: map[string][]memoryRecord{
+			"products": {
+				{id: "p1", date: day(1), document: `{"_id":"p1"}`},
+				{id: "p2", date: day(1), document: `{"_id":"p2"}`},
+				{id: "p3", date: day(2), document: `{"_id":"p3"}`},
+				{id: "p4", date: day(3), document: `{"_id":"p4"}`},
+			},
+			"refunds": {
+				{id: "r1", date: day(5), document: `{"_id":"r1"}`},
+			},
+		}}
+	}
+
+	Convey("Given records either side of the cutoff", t, func() {
+		source := newSource()
+		path := t.TempDir()
+
+		Convey("When archived then each day before the cutoff is moved to its own file", func() {
+			partitions, err := (&Archiver{Source: source, Path: path}).Run(context.Background(), before)
+
+			So(err, ShouldBeNil)
+			So(partitions, ShouldHaveLength, 2)
+			So(partitions[0].Date, ShouldEqual, "2019-03-01")
+			So(partitions[0].Records, ShouldEqual, 2)
+			So(partitions[0].Deleted, ShouldEqual, 2)
+			So(partitions[1].Date, ShouldEqual, "2019-03-02")
+			So(filepath.Dir(partitions[0].File), ShouldEqual, filepath.Join(path, "products", "2019", "03"))
+			So(readLines(partitions[0].File), ShouldResemble, []string{`{"_id":"p1"}`, `{"_id":"p2"}`})
+
+			So(source.records["products"], ShouldHaveLength, 1)
+			So(source.records["refunds"], ShouldHaveLength, 1)
+		})
+
+		Convey("When a dry run then the partitions are reported and nothing is moved", func() {
+			partitions, err := (&Archiver{Source: source, Path: path, DryRun: true}).Run(context.Background(), before)
+
+			So(err, ShouldBeNil)
+			So(partitions, ShouldHaveLength, 2)
+			So(partitions[0].Records, ShouldEqual, 2)
+			So(partitions[0].File, ShouldEqual, "")
+			So(source.records["products"], ShouldHaveLength, 4)
+
+			entries, _ := os.ReadDir(path)
+			So(entries, ShouldBeEmpty)
+		})
+
+		Convey("When deleting fails then the archiver stops and the file is kept", func() {
+			source.deleteErr = errors.New("delete failed")
+
+			partitions, err := (&Archiver{Source: source, Path: path}).Run(context.Background(), before)
+
+			So(err, ShouldNotBeNil)
+			So(partitions, ShouldBeEmpty)
+			So(source.records["products"], ShouldHaveLength, 4)
+		})
+
+		Convey("When a record spans more than one line then nothing is archived", func() {
+			source.records["products"][1].document = "{\n}"
+
+			_, err := (&Archiver{Source: source, Path: path}).Run(context.Background(), before)
+
+			So(err, ShouldNotBeNil)
+			So(source.records["products"], ShouldHaveLength, 4)
+			entries, _ := os.ReadDir(filepath.Join(path, "products", "2019", "03"))
+			So(entries, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestUnitVerify(t *testing.T) {
+
+	Convey("Given an archive file", t, func() {
+		file := filepath.Join(t.TempDir(), "archive.jsonl.gz")
+		source := &memorySource{records: map[string][]memoryRecord{
+			"products": {{id: "p1", document: `{"_id":"p1"}`}, {id: "p2", document: `{"_id":"p2"}`}},
+		}}
+		w, err := writePartition(context.Background(), source, "products", time.Time{}, time.Now(), file)
+		So(err, ShouldBeNil)
+
+		Convey("Then it holds the records written", func() {
+			So(verify(file, w.hashes), ShouldBeNil)
+		})
+
+		Convey("Then a record that was not written fails verification", func() {
+			So(errors.Is(verify(file, w.hashes[:1]), ErrVerificationFailed), ShouldBeTrue)
+		})
+
+		Convey("Then a missing record fails verification", func() {
+			So(errors.Is(verify(file, append(w.hashes, w.hashes[0])), ErrVerificationFailed), ShouldBeTrue)
+		})
+
+		Convey("Then a corrupt file fails verification", func() {
+			b, _ := os.ReadFile(file)
+			b[len(b)-5] ^= 0xff
+			So(os.WriteFile(file, b, 0o644), ShouldBeNil)
+
+			So(errors.Is(verify(file, w.hashes), ErrVerificationFailed), ShouldBeTrue)
+		})
+
+		Convey("Then it is never overwritten", func() {
+			_, err := writePartition(context.Background(), source, "products", time.Time{}, time.Now(), file)
+
+			So(errors.Is(err, os.ErrExist), ShouldBeTrue)
+		})
+	})
+}
+
+func readLines(file string) []string {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil
+	}
+	var lines []string
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
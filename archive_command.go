@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/payment-reconciliation-consumer/archive"
+	"github.com/companieshouse/payment-reconciliation-consumer/config"
+	"github.com/companieshouse/payment-reconciliation-consumer/dao"
+)
+
+// runArchive moves reconciliation records older than the retention period out of the database and into archive
+// files, and writes a report of each partition archived to standard output. It is intended to be run on a schedule.
+func runArchive(args []string) error {
+	cfg, err := config.Get()
+	if err != nil {
+		return fmt.Errorf("error configuring service: %s", err)
+	}
+
+	flags := flag.NewFlagSet("archive", flag.ContinueOnError)
+	path := flags.String("path", cfg.ArchivePath, "Directory to write the archive files beneath")
+	retentionDays := flags.Int("retention-days", cfg.ArchiveRetentionDays, "Archive records with a transaction date more than this many days ago")
+	dryRun := flags.Bool("dry-run", false, "Report the records that would be archived without writing or deleting anything")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" && !*dryRun {
+		return errors.New("an archive -path or ARCHIVE_PATH is required")
+	}
+	if *retentionDays < 1 {
+		return errors.New("the retention period must be at least one day")
+	}
+
+	source, err := dao.NewArchiveSource(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	// Whole days are archived, so the cutoff is the start of the day
+	now := time.Now().UTC()
+	before := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -*retentionDays)
+
+	log.Info("starting archive", log.Data{"path": *path, "before": before, "dry_run": *dryRun})
+	archiver := &archive.Archiver{Source: source, Path: *path, DryRun: *dryRun}
+	partitions, err := archiver.Run(ctx, before)
+
+	// The partitions archived before any failure are still reported, as their records have been moved
+	encoder := json.NewEncoder(os.Stdout)
+	for _, partition := range partitions {
+		if encodeErr := encoder.Encode(partition); encodeErr != nil {
+			return encodeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Info("archive complete", log.Data{"partitions": len(partitions), "dry_run": *dryRun})
+	return nil
+}
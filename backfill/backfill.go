@@ -0,0 +1,110 @@
+package backfill
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/companieshouse/payment-reconciliation-consumer/data"
+	"github.com/companieshouse/payment-reconciliation-consumer/service"
+	"golang.org/x/time/rate"
+)
+
+var reportHeader = []string{"payment_id", "refund_id", "outcome", "reason", "error"}
+
+// ReadRequests reads the payments to reconcile from r. Each line holds a payment ID, optionally followed by a comma
+// and a refund ID. Blank lines, further columns and a header line starting with payment_id are ignored, so a report
+// written by WriteReport can be filtered and read back in to re-run its failures.
+func ReadRequests(r io.Reader) ([]data.PaymentProcessed, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var requests []data.PaymentProcessed
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return requests, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		paymentID := strings.TrimSpace(record[0])
+		if paymentID == "" || paymentID == reportHeader[0] {
+			continue
+		}
+
+		pp := data.PaymentProcessed{ResourceURI: paymentID}
+		if len(record) > 1 {
+			pp.RefundId = strings.TrimSpace(record[1])
+		}
+		requests = append(requests, pp)
+	}
+}
+
+// Run reconciles each of the requests using at most concurrency workers, starting no more reconciliations than the
+// limiter allows. Results are returned in the same order as the requests. Requests not started before ctx is
+// cancelled are reported as failed.
+func Run(ctx context.Context, reconciler service.Reconciler, requests []data.PaymentProcessed, concurrency int, limiter *rate.Limiter) []service.Reconciliation {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]service.Reconciliation, len(requests))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = reconcile(ctx, reconciler, requests[i], limiter)
+			}
+		}()
+	}
+
+	for i := range requests {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
+
+func reconcile(ctx context.Context, reconciler service.Reconciler, pp data.PaymentProcessed, limiter *rate.Limiter) service.Reconciliation {
+	if err := limiter.Wait(ctx); err != nil {
+		return service.Reconciliation{PaymentID: pp.ResourceURI, RefundID: pp.RefundId,
+			Outcome: service.Outcome{Kind: service.RetryableFailure, Err: err}}
+	}
+	return reconciler.Reconcile(ctx, pp.ResourceURI, pp.RefundId)
+}
+
+// WriteReport writes the results to w as CSV, one line per payment or refund
+func WriteReport(w io.Writer, results []service.Reconciliation) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(reportHeader); err != nil {
+		return err
+	}
+	for _, result := range results {
+		if err := writer.Write([]string{result.PaymentID, result.RefundID, result.Status(), result.Reason, result.ErrorMessage()}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Summarise counts the results by outcome
+func Summarise(results []service.Reconciliation) string {
+	counts := map[string]int{}
+	for _, result := range results {
+		counts[result.Status()]++
+	}
+	return fmt.Sprintf("%d reconciled, %d skipped, %d failed", counts["reconciled"], counts["skipped"], counts["failed"])
+}
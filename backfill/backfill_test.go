@@ -0,0 +1,94 @@
+package backfill
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/companieshouse/payment-reconciliation-consumer/data"
+	"github.com/companieshouse/payment-reconciliation-consumer/service"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/time/rate"
+)
+
+type mockReconciler struct {
+	mu       sync.Mutex
+	outcomes map[string]service.Outcome
+	received []data.PaymentProcessed
+}
+
+func (m *mockReconciler) Reconcile(ctx context.Context, paymentID, refundID string) service.Reconciliation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.received = append(m.received, data.PaymentProcessed{ResourceURI: paymentID, RefundId: refundID})
+	return service.Reconciliation{PaymentID: paymentID, RefundID: refundID, Outcome: m.outcomes[paymentID]}
+}
+
+func TestUnitReadRequests(t *testing.T) {
+	Convey("Payment IDs and optional refund IDs are read from each line", t, func() {
+		requests, err := ReadRequests(strings.NewReader("payment1\n\npayment2, refund2\n"))
+
+		So(err, ShouldBeNil)
+		So(requests, ShouldResemble, []data.PaymentProcessed{
+			{ResourceURI: "payment1"},
+			{ResourceURI: "payment2", RefundId: "refund2"},
+		})
+	})
+
+	Convey("A report can be read back in", t, func() {
+		var report bytes.Buffer
+		WriteReport(&report, []service.Reconciliation{{PaymentID: "payment1", RefundID: "refund1",
+			Outcome: service.Outcome{Kind: service.RetryableFailure, Err: errors.New("timeout")}}})
+
+		requests, err := ReadRequests(&report)
+
+		So(err, ShouldBeNil)
+		So(requests, ShouldResemble, []data.PaymentProcessed{{ResourceURI: "payment1", RefundId: "refund1"}})
+	})
+}
+
+func TestUnitRun(t *testing.T) {
+	Convey("Given a list of payments to reconcile", t, func() {
+		reconciler := &mockReconciler{outcomes: map[string]service.Outcome{
+			"reconciled": {Kind: service.Reconciled},
+			"skipped":    {Kind: service.Skipped, Reason: "payment is not reconcilable"},
+			"failed":     {Kind: service.RetryableFailure, Err: errors.New("timeout")},
+		}}
+		requests := []data.PaymentProcessed{{ResourceURI: "reconciled"}, {ResourceURI: "skipped"}, {ResourceURI: "failed", RefundId: "refund"}}
+
+		Convey("When they are run then every payment is reconciled and reported in order", func() {
+			results := Run(context.Background(), reconciler, requests, 2, rate.NewLimiter(rate.Inf, 1))
+
+			So(reconciler.received, ShouldHaveLength, 3)
+			So(results, ShouldHaveLength, 3)
+			So(results[0].PaymentID, ShouldEqual, "reconciled")
+			So(results[0].Status(), ShouldEqual, "reconciled")
+			So(results[1].Status(), ShouldEqual, "skipped")
+			So(results[2].RefundID, ShouldEqual, "refund")
+			So(results[2].Status(), ShouldEqual, "failed")
+			So(Summarise(results), ShouldEqual, "1 reconciled, 1 skipped, 1 failed")
+
+			var report bytes.Buffer
+			So(WriteReport(&report, results), ShouldBeNil)
+			So(report.String(), ShouldEqual, "payment_id,refund_id,outcome,reason,error\n"+
+				"reconciled,,reconciled,,\n"+
+				"skipped,,skipped,payment is not reconcilable,\n"+
+				"failed,refund,failed,,timeout\n")
+		})
+
+		Convey("When the run is cancelled then payments not yet started are reported as failed", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			results := Run(ctx, reconciler, requests, 1, rate.NewLimiter(1, 1))
+
+			So(reconciler.received, ShouldBeEmpty)
+			for _, result := range results {
+				So(result.Status(), ShouldEqual, "failed")
+			}
+		})
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/payment-reconciliation-consumer/backfill"
+	"github.com/companieshouse/payment-reconciliation-consumer/config"
+	"github.com/companieshouse/payment-reconciliation-consumer/service"
+	"golang.org/x/time/rate"
+)
+
+// runBackfill reconciles every payment listed in an input file and writes a report of the results. The service is
+// configured from the environment as usual; args holds the backfill flags only.
+func runBackfill(args []string) error {
+	flags := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	input := flags.String("input", "", "CSV or newline separated file of payment IDs, each optionally followed by a refund ID")
+	report := flags.String("report", "backfill-report.csv", "File to write the result of each reconciliation to")
+	concurrency := flags.Int("concurrency", 4, "Number of payments to reconcile at once")
+	ratePerSecond := flags.Float64("rate", 5, "Maximum number of payments to start reconciling per second")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *input == "" {
+		return errors.New("an -input file is required")
+	}
+	if *ratePerSecond <= 0 {
+		return errors.New("-rate must be greater than zero")
+	}
+
+	cfg, err := config.Get()
+	if err != nil {
+		return fmt.Errorf("error configuring service: %s", err)
+	}
+
+	f, err := os.Open(*input)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	requests, err := backfill.ReadRequests(f)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %s", *input, err)
+	}
+
+	handler, err := service.NewHandler(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	log.Info("starting backfill", log.Data{"input": *input, "payments": len(requests), "concurrency": *concurrency, "rate": *ratePerSecond})
+	results := backfill.Run(ctx, handler, requests, *concurrency, rate.NewLimiter(rate.Limit(*ratePerSecond), 1))
+
+	out, err := os.Create(*report)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if err := backfill.WriteReport(out, results); err != nil {
+		return fmt.Errorf("error writing report %s: %s", *report, err)
+	}
+
+	log.Info("backfill complete: "+backfill.Summarise(results), log.Data{"report": *report})
+	return nil
+}
@@ -2,9 +2,6 @@ package config
 
 import (
 	"github.com/ian-kent/gofigure"
-	"gopkg.in/yaml.v2"
-	"io/ioutil"
-	"path/filepath"
 )
 
 // Config is the payment reconciliation consumer config
@@ -15,24 +12,37 @@ type Config struct {
 	PaymentProcessedTopic          string      `env:"PAYMENT_PROCESSED_TOPIC"                       flag:"payment-processed-topic"                      flagDesc:"Payment processed topic"`
 	ZookeeperChroot                string      `env:"KAFKA_ZOOKEEPER_CHROOT"                        flag:"zookeeper-chroot"                             flagDesc:"Main CH Zookeeper chroot"`
 	ZookeeperURL                   string      `env:"KAFKA_ZOOKEEPER_ADDR"                          flag:"zookeeper-addr"                               flagDesc:"Main CH Zookeeper address"`
+	ConsumerGroupCoordinator       string      `env:"KAFKA_CONSUMER_GROUP_COORDINATOR"              flag:"consumer-group-coordinator"                   flagDesc:"How the consumer group is coordinated - zookeeper for the legacy cluster consumer, or broker for a Kafka consumer group, which is required for KRaft clusters"`
+	KafkaVersion                   string      `env:"KAFKA_VERSION"                                 flag:"kafka-version"                                flagDesc:"Version of the Kafka brokers, used by the broker coordinated consumer group"`
 	RetryThrottleRate              int         `env:"RETRY_THROTTLE_RATE_SECONDS"                   flag:"retry-throttle-rate-seconds"                  flagDesc:"Retry throttle rate seconds"`
 	MaxRetryAttempts               int         `env:"MAXIMUM_RETRY_ATTEMPTS"                        flag:"max-retry-attemps"                            flagDesc:"Maximum retry attempts"`
 	IsErrorConsumer                bool        `env:"IS_ERROR_QUEUE_CONSUMER"                       flag:"is-error-queue-consumer"                      flagDesc:"Set this flag if it is an error queue consumer"`
 	ChsAPIKey                      string      `env:"CHS_API_KEY"                                   flag:"chs-api-key"                                  flagDesc:"API access key"`
 	SchemaRegistryURL              string      `env:"SCHEMA_REGISTRY_URL"                           flag:"schema-registry-url"                          flagDesc:"Schema registry url"`
 	PaymentsAPIURL                 string      `env:"PAYMENTS_API_URL"                              flag:"payments-api-url"                             flagDesc:"Base URL for the Payment Service API"`
+	PaymentsAPITimeout             int         `env:"PAYMENTS_API_TIMEOUT_SECONDS"                  flag:"payments-api-timeout-seconds"                 flagDesc:"Timeout in seconds for requests to the Payment Service API"`
 	MongoDBURL                     string      `env:"MONGODB_URL"                                   flag:"mongodb-url"                                  flagDesc:"MongoDB server URL"`
 	Database                       string      `env:"RECONCILIATION_MONGODB_DATABASE"               flag:"mongodb-database"                             flagDesc:"MongoDB database for data"`
 	TransactionsCollection         string      `env:"MONGODB_PAYMENT_REC_TRANSACTIONS_COLLECTION"   flag:"mongodb-payment-rec-transactions-collection"  flagDesc:"MongoDB collection for payment transactions data"`
 	ProductsCollection             string      `env:"MONGODB_PAYMENT_REC_PRODUCTS_COLLECTION"       flag:"mongodb-payment-rec-products-collection"      flagDesc:"MongoDB collection for payment products data"`
 	RefundsCollection              string      `env:"MONGODB_PAYMENT_REC_REFUNDS_COLLECTION"        flag:"mongodb-payment-rec-refunds-collection"       flagDesc:"MongoDB collection for refunds data"`
+	SkippedCostsCollection         string      `env:"MONGODB_PAYMENT_REC_SKIPPED_COSTS_COLLECTION"  flag:"mongodb-payment-rec-skipped-costs-collection" flagDesc:"MongoDB collection for the costs of partially reconciled payments that were skipped"`
+	ErasuresCollection             string      `env:"MONGODB_PAYMENT_REC_ERASURES_COLLECTION"       flag:"mongodb-payment-rec-erasures-collection"      flagDesc:"MongoDB collection for the audit of data subject erasures"`
+	MigrationsCollection           string      `env:"MONGODB_PAYMENT_REC_MIGRATIONS_COLLECTION"     flag:"mongodb-payment-rec-migrations-collection"    flagDesc:"MongoDB collection recording the migrations applied to the reconciliation collections"`
+	MigrateOnStartup               bool        `env:"MIGRATE_ON_STARTUP"                            flag:"migrate-on-startup"                           flagDesc:"Set this to false to stop pending migrations to the reconciliation collections being applied when the service starts"`
 	SkipGoneResource               bool        `env:"SKIP_GONE_RESOURCE"                            flag:"skip-gone-resource"                           flagDesc:"Boolean which indicates whether messages with resources that return 410 should be skipped"`
 	SkipGoneResourceId             string      `env:"SKIP_GONE_RESOURCE_ID"                         flag:"skip-gone-resource-id"                        flagDesc:"Set this if you only want to skip a specific message with a resource returning a 410 - requires SKIP_GONE_RESOURCE=true"`
-}
-
-// ProductMap contains a map of product codes
-type ProductMap struct {
-	Codes map[string]int `yaml:"product_code"`
+	AdminEndpointsEnabled          bool        `env:"ADMIN_ENDPOINTS_ENABLED"                       flag:"admin-endpoints-enabled"                      flagDesc:"Set this to expose the admin endpoints, such as reconciling a single payment on demand"`
+	AdminAPIKey                    string      `env:"ADMIN_API_KEY"                                 flag:"admin-api-key"                                flagDesc:"Key that callers of the admin endpoints must send as a bearer token"`
+	DryRun                         bool        `env:"DRY_RUN"                                       flag:"dry-run"                                      flagDesc:"Set this to reconcile payments without writing to the reconciliation collections or producing to the retry and error topics"`
+	ShadowCollectionSuffix         string      `env:"SHADOW_COLLECTION_SUFFIX"                      flag:"shadow-collection-suffix"                     flagDesc:"Suffix of the collections that dry runs write records to - records are only logged if unset"`
+	ProductMapPollInterval         int         `env:"PRODUCT_MAP_POLL_INTERVAL_SECONDS"             flag:"product-map-poll-interval-seconds"            flagDesc:"How often in seconds to check the product map file for changes - set to 0 to only reload on SIGHUP"`
+	FieldEncryptionKeyID           string      `env:"FIELD_ENCRYPTION_KEY_ID"                       flag:"field-encryption-key-id"                      flagDesc:"Identifier recorded against every value encrypted with the field encryption key"`
+	FieldEncryptionKey             string      `env:"FIELD_ENCRYPTION_KEY"                          flag:"field-encryption-key"                         flagDesc:"Base64 encoded 32 byte key used to encrypt and hash email addresses, which is required unless FIELD_ENCRYPTION_KEY_FILE is set"`
+	FieldEncryptionKeyFile         string      `env:"FIELD_ENCRYPTION_KEY_FILE"                     flag:"field-encryption-key-file"                    flagDesc:"File holding the base64 encoded field encryption key, used when FIELD_ENCRYPTION_KEY is unset"`
+	ArchivePath                    string      `env:"ARCHIVE_PATH"                                  flag:"archive-path"                                 flagDesc:"Local or mounted directory that old reconciliation records are archived to"`
+	ArchiveRetentionDays           int         `env:"ARCHIVE_RETENTION_DAYS"                        flag:"archive-retention-days"                       flagDesc:"Number of days reconciliation records are kept in the database before they are archived"`
+	VerbosePaymentLogging          bool        `env:"VERBOSE_PAYMENT_LOGGING"                       flag:"verbose-payment-logging"                      flagDesc:"Set this to log every field of payments api responses that is safe to log, with personal fields hashed, at debug level"`
 }
 
 // Namespace implements service.Config.Namespace
@@ -40,33 +50,6 @@ func (c *Config) Namespace() string {
 	return "payment-reconciliation-consumer"
 }
 
-var productMap *ProductMap
-
-// GetProductMap fetches a map of product codes
-func GetProductMap() (*ProductMap, error) {
-
-	if productMap != nil {
-		return productMap, nil
-	}
-
-	filename, err := filepath.Abs("assets/product_code.yml")
-	if err != nil {
-		return nil, err
-	}
-
-	yamlFile, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return nil, err
-	}
-
-	err = yaml.Unmarshal(yamlFile, &productMap)
-	if err != nil {
-		return nil, err
-	}
-
-	return productMap, nil
-}
-
 var cfg *Config
 
 // Get configures the application and returns the configuration
@@ -80,8 +63,18 @@ func Get() (*Config, error) {
 		PaymentProcessedTopic:          "",
 		PaymentReconciliationGroupName: "payment-reconciliation-consumer-group",
 		ZookeeperChroot:                "",
+		ConsumerGroupCoordinator:       "zookeeper",
+		KafkaVersion:                   "2.1.0",
 		RetryThrottleRate:              10,
 		MaxRetryAttempts:               6,
+		PaymentsAPITimeout:             30,
+		SkippedCostsCollection:         "payment_skipped_costs",
+		ErasuresCollection:             "payment_erasures",
+		MigrationsCollection:           "payment_migrations",
+		MigrateOnStartup:               true,
+		ProductMapPollInterval:         30,
+		FieldEncryptionKeyID:           "1",
+		ArchiveRetentionDays:           2192,
 	}
 
 	err := gofigure.Gofigure(cfg)
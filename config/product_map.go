@@ -0,0 +1,359 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/companieshouse/chs.go/log"
+	"gopkg.in/yaml.v2"
+)
+
+// ErrInvalidProductMap is returned when the product map file cannot be used
+var ErrInvalidProductMap = errors.New("invalid product map")
+
+// Product codes are the five digit codes used by the finance systems
+const (
+	minProductCode = 10000
+	maxProductCode = 99999
+)
+
+const productMapFile = "assets/product_code.yml"
+
+// dateLayout is the layout of the dates a product code is effective between
+const dateLayout = "2006-01-02"
+
+// ProductMap contains a map of product codes, by product type
+type ProductMap struct {
+	Codes map[string]ProductCodes `yaml:"product_code"`
+}
+
+// ProductCode is a product code and the dates it is effective between. EffectiveFrom is inclusive and EffectiveTo is
+// exclusive, and a zero date leaves the period open at that end.
+type ProductCode struct {
+	Code          int
+	EffectiveFrom time.Time
+	EffectiveTo   time.Time
+}
+
+// ProductCodes are the product codes used for a product type over time
+type ProductCodes []ProductCode
+
+// UnmarshalYAML reads either a single product code, which is always effective, or a list of product codes with the
+// dates they are effective between:
+//
+//	certificate: 27007
+//	certified-copy:
+//	  - code: 27002
+//	    effective_to: 2025-04-01
+//	  - code: 27022
+//	    effective_from: 2025-04-01
+func (codes *ProductCodes) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var code int
+	if err := unmarshal(&code); err == nil {
+		*codes = ProductCodes{{Code: code}}
+		return nil
+	}
+
+	var dated []struct {
+		Code          int    `yaml:"code"`
+		EffectiveFrom string `yaml:"effective_from"`
+		EffectiveTo   string `yaml:"effective_to"`
+	}
+	if err := unmarshal(&dated); err != nil {
+		return errors.New("product code is not a number or a list of dated product codes")
+	}
+
+	*codes = make(ProductCodes, 0, len(dated))
+	for _, d := range dated {
+		from, err := parseDate(d.EffectiveFrom)
+		if err != nil {
+			return err
+		}
+		to, err := parseDate(d.EffectiveTo)
+		if err != nil {
+			return err
+		}
+		*codes = append(*codes, ProductCode{Code: d.Code, EffectiveFrom: from, EffectiveTo: to})
+	}
+	return nil
+}
+
+func parseDate(date string) (time.Time, error) {
+	if date == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(dateLayout, date)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("effective date [%s] is not a date in the form %s", date, dateLayout)
+	}
+	return t, nil
+}
+
+// effectiveAt reports whether the product code is effective at the given time
+func (code ProductCode) effectiveAt(at time.Time) bool {
+	return (code.EffectiveFrom.IsZero() || !at.Before(code.EffectiveFrom)) &&
+		(code.EffectiveTo.IsZero() || at.Before(code.EffectiveTo))
+}
+
+// Code returns the product code for the product type effective at the given time, or zero if there is none
+func (productMap *ProductMap) Code(productType string, at time.Time) int {
+	for _, code := range productMap.Codes[productType] {
+		if code.effectiveAt(at) {
+			return code.Code
+		}
+	}
+	return 0
+}
+
+// IsMapped reports whether the product type has a product code at any time
+func (productMap *ProductMap) IsMapped(productType string) bool {
+	return len(productMap.Codes[productType]) > 0
+}
+
+// ParseProductMap reads a product map from yaml, rejecting duplicate or empty product types, codes which are not
+// five digit numbers and overlapping effective dates. Every problem found is reported, so that a bad file can be fixed
+// in one go.
+func ParseProductMap(b []byte) (*ProductMap, error) {
+	var raw struct {
+		Codes yaml.MapSlice `yaml:"product_code"`
+	}
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidProductMap, err)
+	}
+
+	productMap := &ProductMap{Codes: make(map[string]ProductCodes, len(raw.Codes))}
+
+	// The entries are read one at a time, rather than into a map, so that duplicate product types can be detected
+	var problems []error
+	for _, item := range raw.Codes {
+		productType := strings.TrimSpace(fmt.Sprint(item.Key))
+		if item.Key == nil || productType == "" {
+			problems = append(problems, errors.New("empty product type"))
+			continue
+		}
+		if _, ok := productMap.Codes[productType]; ok {
+			problems = append(problems, fmt.Errorf("duplicate product type [%s]", productType))
+			continue
+		}
+		codes, err := parseProductCodes(item.Value)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("product type [%s]: %s", productType, err))
+			continue
+		}
+		productMap.Codes[productType] = codes
+	}
+
+	if err := productMap.Validate(); err != nil {
+		problems = append(problems, err)
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidProductMap, errors.Join(problems...))
+	}
+
+	return productMap, nil
+}
+
+func parseProductCodes(value interface{}) (ProductCodes, error) {
+	b, err := yaml.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var codes ProductCodes
+	if err := yaml.Unmarshal(b, &codes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Validate checks that the product map has at least one product type, that each has a five digit product code and
+// that the periods its codes are effective for do not overlap
+func (productMap *ProductMap) Validate() error {
+	if len(productMap.Codes) == 0 {
+		return errors.New("no product codes")
+	}
+
+	var problems []error
+	for productType, codes := range productMap.Codes {
+		if strings.TrimSpace(productType) == "" {
+			problems = append(problems, errors.New("empty product type"))
+		}
+		if len(codes) == 0 {
+			problems = append(problems, fmt.Errorf("no product codes for product type [%s]", productType))
+		}
+		for _, code := range codes {
+			if code.Code < minProductCode || code.Code > maxProductCode {
+				problems = append(problems, fmt.Errorf("product code [%d] for product type [%s] is not between %d and %d",
+					code.Code, productType, minProductCode, maxProductCode))
+			}
+			if !code.EffectiveFrom.IsZero() && !code.EffectiveTo.IsZero() && !code.EffectiveFrom.Before(code.EffectiveTo) {
+				problems = append(problems, fmt.Errorf("product code [%d] for product type [%s] is effective from a date which is not before it is effective to",
+					code.Code, productType))
+			}
+		}
+		if codes.overlap() {
+			problems = append(problems, fmt.Errorf("product codes for product type [%s] have overlapping effective dates", productType))
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// overlap reports whether more than one of the codes is effective at the same time
+func (codes ProductCodes) overlap() bool {
+	sorted := make(ProductCodes, len(codes))
+	copy(sorted, codes)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].EffectiveFrom.Before(sorted[j].EffectiveFrom)
+	})
+
+	for i := 1; i < len(sorted); i++ {
+		previous, next := sorted[i-1], sorted[i]
+		if previous.EffectiveTo.IsZero() || next.EffectiveFrom.IsZero() || previous.EffectiveTo.After(next.EffectiveFrom) {
+			return true
+		}
+	}
+	return false
+}
+
+// ProductMapStore holds the current product map, loaded from a file. The map can be reloaded while it is being read -
+// readers keep the map they were given and later calls to Get return the new one. A map which fails validation is
+// never swapped in, so the previous map remains in use.
+type ProductMapStore struct {
+	path    string
+	current atomic.Pointer[ProductMap]
+
+	// mu serialises loads of the file
+	mu      sync.Mutex
+	modTime time.Time
+}
+
+// NewProductMapStore creates a store for the product map in the file at path. The file is not read until the map is
+// first needed.
+func NewProductMapStore(path string) *ProductMapStore {
+	return &ProductMapStore{path: path}
+}
+
+// Get returns the current product map, loading it if it has not yet been loaded
+func (s *ProductMapStore) Get() (*ProductMap, error) {
+	if productMap := s.current.Load(); productMap != nil {
+		return productMap, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Another caller may have loaded the map while we waited for the lock
+	if productMap := s.current.Load(); productMap != nil {
+		return productMap, nil
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s.current.Load(), nil
+}
+
+// Set validates productMap and makes it the current product map
+func (s *ProductMapStore) Set(productMap *ProductMap) error {
+	if err := productMap.Validate(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidProductMap, err)
+	}
+	s.current.Store(productMap)
+	return nil
+}
+
+// Reload reads the product map file again, replacing the current map if the file is valid
+func (s *ProductMapStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// Watch reloads the product map whenever a signal is received on reload, and whenever the file has been modified when
+// checked every interval. Polling is disabled when interval is not positive. Watch returns when ctx is cancelled.
+func (s *ProductMapStore) Watch(ctx context.Context, interval time.Duration, reload <-chan os.Signal) {
+	var poll <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reload:
+			log.Info("reload signal received, reloading product map", log.Data{"path": s.path})
+			s.reloadLogged()
+		case <-poll:
+			if s.modified() {
+				log.Info("product map file modified, reloading product map", log.Data{"path": s.path})
+				s.reloadLogged()
+			}
+		}
+	}
+}
+
+func (s *ProductMapStore) reloadLogged() {
+	if err := s.Reload(); err != nil {
+		log.Error(fmt.Errorf("error reloading product map, keeping the current map: %s", err), log.Data{"path": s.path})
+	}
+}
+
+// modified reports whether the file has changed since it was last loaded
+func (s *ProductMapStore) modified() bool {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		log.Error(fmt.Errorf("error checking product map file: %s", err), log.Data{"path": s.path})
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !info.ModTime().Equal(s.modTime)
+}
+
+// load must be called with mu held
+func (s *ProductMapStore) load() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	// Record the modification time even if the file is invalid, so that a bad file is only reported once
+	s.modTime = info.ModTime()
+
+	productMap, err := ParseProductMap(b)
+	if err != nil {
+		return err
+	}
+
+	s.current.Store(productMap)
+	log.Info("product map loaded", log.Data{"path": s.path, "products": len(productMap.Codes)})
+
+	return nil
+}
+
+var productMaps = NewProductMapStore(productMapFile)
+
+// ProductMaps returns the store holding the application's product map
+func ProductMaps() *ProductMapStore {
+	return productMaps
+}
+
+// GetProductMap fetches the current map of product codes
+func GetProductMap() (*ProductMap, error) {
+	return productMaps.Get()
+}
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitParseProductMap(t *testing.T) {
+
+	Convey("The product map shipped with the service is valid", t, func() {
+		b, err := os.ReadFile(filepath.Join("..", productMapFile))
+		So(err, ShouldBeNil)
+
+		productMap, err := ParseProductMap(b)
+
+		So(err, ShouldBeNil)
+		So(productMap.Code("certified-copy-same-day", time.Now()), ShouldEqual, 27000)
+	})
+
+	Convey("A valid product map is parsed", t, func() {
+		productMap, err := ParseProductMap([]byte("product_code:\n  ds01: 16032\n  sr01: 16033\n"))
+
+		So(err, ShouldBeNil)
+		So(productMap.Codes, ShouldResemble, map[string]ProductCodes{"ds01": {{Code: 16032}}, "sr01": {{Code: 16033}}})
+	})
+
+	Convey("Duplicate product types are rejected", t, func() {
+		_, err := ParseProductMap([]byte("product_code:\n  ds01: 16032\n  ds01: 16033\n"))
+
+		So(errors.Is(err, ErrInvalidProductMap), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, "duplicate product type [ds01]")
+	})
+
+	Convey("Product codes which are not numbers are rejected", t, func() {
+		_, err := ParseProductMap([]byte("product_code:\n  ds01: 1603two\n"))
+
+		So(errors.Is(err, ErrInvalidProductMap), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, "product type [ds01]: product code is not a number")
+	})
+
+	Convey("Product codes which are not five digits are rejected", t, func() {
+		_, err := ParseProductMap([]byte("product_code:\n  ds01: 1603\n"))
+
+		So(errors.Is(err, ErrInvalidProductMap), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, "is not between 10000 and 99999")
+	})
+
+	Convey("Empty product types are rejected", t, func() {
+		_, err := ParseProductMap([]byte("product_code:\n  \"\": 16032\n  ds01: 16032\n"))
+
+		So(errors.Is(err, ErrInvalidProductMap), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, "empty product type")
+	})
+
+	Convey("A product map without product codes is rejected", t, func() {
+		_, err := ParseProductMap([]byte("product_codes:\n  ds01: 16032\n"))
+
+		So(errors.Is(err, ErrInvalidProductMap), ShouldBeTrue)
+	})
+
+	Convey("Dated product codes are parsed alongside flat product codes", t, func() {
+		productMap, err := ParseProductMap([]byte(datedProductMap))
+
+		So(err, ShouldBeNil)
+		So(productMap.Code("ds01", time.Now()), ShouldEqual, 16032)
+		So(productMap.Code("certified-copy", date("2025-03-31")), ShouldEqual, 27002)
+		So(productMap.Code("certified-copy", date("2025-04-01")), ShouldEqual, 27022)
+		So(productMap.Code("certified-copy", date("2026-01-01")), ShouldEqual, 27022)
+	})
+
+	Convey("A product type is mapped only for the dates it has a code", t, func() {
+		productMap, err := ParseProductMap([]byte("product_code:\n  ds01:\n    - code: 16032\n      effective_from: 2025-04-01\n"))
+
+		So(err, ShouldBeNil)
+		So(productMap.IsMapped("ds01"), ShouldBeTrue)
+		So(productMap.Code("ds01", date("2025-03-31")), ShouldEqual, 0)
+	})
+
+	Convey("Overlapping effective dates are rejected", t, func() {
+		_, err := ParseProductMap([]byte("product_code:\n  ds01:\n    - code: 16032\n      effective_to: 2025-04-02\n    - code: 16034\n      effective_from: 2025-04-01\n"))
+
+		So(errors.Is(err, ErrInvalidProductMap), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, "product codes for product type [ds01] have overlapping effective dates")
+	})
+
+	Convey("Codes effective to a date before they are effective from are rejected", t, func() {
+		_, err := ParseProductMap([]byte("product_code:\n  ds01:\n    - code: 16032\n      effective_from: 2025-04-01\n      effective_to: 2025-04-01\n"))
+
+		So(errors.Is(err, ErrInvalidProductMap), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, "is effective from a date which is not before it is effective to")
+	})
+
+	Convey("Effective dates which are not dates are rejected", t, func() {
+		_, err := ParseProductMap([]byte("product_code:\n  ds01:\n    - code: 16032\n      effective_from: April\n"))
+
+		So(errors.Is(err, ErrInvalidProductMap), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, "effective date [April] is not a date in the form 2006-01-02")
+	})
+
+	Convey("Every problem is reported", t, func() {
+		_, err := ParseProductMap([]byte("product_code:\n  ds01: 16032\n  ds01: 16033\n  sr01: 1\n"))
+
+		So(err.Error(), ShouldContainSubstring, "duplicate product type [ds01]")
+		So(err.Error(), ShouldContainSubstring, "product code [1] for product type [sr01]")
+	})
+}
+
+func TestUnitProductMapStore(t *testing.T) {
+
+	Convey("Given a product map file", t, func() {
+		path := filepath.Join(t.TempDir(), "product_code.yml")
+		So(os.WriteFile(path, []byte("product_code:\n  ds01: 16032\n"), 0o600), ShouldBeNil)
+		store := NewProductMapStore(path)
+
+		Convey("Then the map is loaded when it is first needed", func() {
+			productMap, err := store.Get()
+
+			So(err, ShouldBeNil)
+			So(productMap.Code("ds01", time.Now()), ShouldEqual, 16032)
+		})
+
+		Convey("When the file is changed and reloaded then the new map is returned", func() {
+			before, _ := store.Get()
+			So(os.WriteFile(path, []byte("product_code:\n  ds01: 16032\n  sr01: 16033\n"), 0o600), ShouldBeNil)
+
+			So(store.Reload(), ShouldBeNil)
+
+			after, _ := store.Get()
+			So(after.Code("sr01", time.Now()), ShouldEqual, 16033)
+			So(before.IsMapped("sr01"), ShouldBeFalse)
+		})
+
+		Convey("When the file is made invalid and reloaded then the previous map is kept", func() {
+			store.Get()
+			So(os.WriteFile(path, []byte("product_code:\n  ds01: 16032\n  ds01: 16033\n"), 0o600), ShouldBeNil)
+
+			So(errors.Is(store.Reload(), ErrInvalidProductMap), ShouldBeTrue)
+
+			productMap, err := store.Get()
+			So(err, ShouldBeNil)
+			So(productMap.Code("ds01", time.Now()), ShouldEqual, 16032)
+		})
+
+		Convey("When watching the file then a reload signal reloads the map", func() {
+			store.Get()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			reload := make(chan os.Signal, 1)
+			go store.Watch(ctx, 0, reload)
+
+			So(os.WriteFile(path, []byte("product_code:\n  sr01: 16033\n"), 0o600), ShouldBeNil)
+			reload <- os.Interrupt
+
+			So(eventually(func() bool {
+				productMap, _ := store.Get()
+				return productMap.Code("sr01", time.Now()) == 16033
+			}), ShouldBeTrue)
+		})
+
+		Convey("When watching the file then a modified file is reloaded", func() {
+			store.Get()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go store.Watch(ctx, 10*time.Millisecond, nil)
+
+			So(os.WriteFile(path, []byte("product_code:\n  sr01: 16033\n"), 0o600), ShouldBeNil)
+			later := time.Now().Add(time.Minute)
+			So(os.Chtimes(path, later, later), ShouldBeNil)
+
+			So(eventually(func() bool {
+				productMap, _ := store.Get()
+				return productMap.Code("sr01", time.Now()) == 16033
+			}), ShouldBeTrue)
+		})
+	})
+
+	Convey("A map which fails validation cannot be set", t, func() {
+		store := NewProductMapStore("")
+
+		err := store.Set(&ProductMap{Codes: map[string]ProductCodes{"ds01": {{Code: 0}}}})
+
+		So(errors.Is(err, ErrInvalidProductMap), ShouldBeTrue)
+	})
+}
+
+const datedProductMap = `product_code:
+  ds01: 16032
+  certified-copy:
+    - code: 27002
+      effective_to: 2025-04-01
+    - code: 27022
+      effective_from: 2025-04-01
+`
+
+func date(value string) time.Time {
+	t, _ := time.Parse(dateLayout, value)
+	return t
+}
+
+// eventually reports whether condition becomes true within a second
+func eventually(condition func() bool) bool {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package dao
+
+import (
+	"context"
+	"time"
+
+	"github.com/companieshouse/payment-reconciliation-consumer/archive"
+	"github.com/companieshouse/payment-reconciliation-consumer/config"
+	"github.com/companieshouse/payment-reconciliation-consumer/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// archiveDateField is the field records are archived by
+const archiveDateField = "transaction_date"
+
+// ArchiveSource is the archive.Source for the products, transactions, refunds and skipped costs collections. Records are archived by
+// their transaction date, as canonical extended JSON so that every value keeps its type, and email addresses stay
+// encrypted.
+type ArchiveSource struct {
+	m *MongoService
+}
+
+// NewArchiveSource returns an ArchiveSource for the reconciliation collections configured in cfg
+func NewArchiveSource(cfg *config.Config) (*ArchiveSource, error) {
+	m, err := newMongoService(cfg, "")
+	if err != nil {
+		return nil, err
+	}
+	return &ArchiveSource{m: m}, nil
+}
+
+// Collections returns the products, transactions, refunds and skipped costs collections
+func (s *ArchiveSource) Collections() []string {
+	return []string{s.m.ProductsCollection, s.m.TransactionsCollection, s.m.RefundsCollection, s.m.SkippedCostsCollection}
+}
+
+// Oldest returns the transaction date of the oldest record in collection dated before before
+func (s *ArchiveSource) Oldest(ctx context.Context, collection string, before time.Time) (time.Time, bool, error) {
+	var oldest struct {
+		Date time.Time `bson:"transaction_date"`
+	}
+	err := s.m.db.Collection(collection).FindOne(ctx,
+		bson.M{archiveDateField: bson.M{"$lt": before}},
+		options.FindOne().SetSort(bson.D{{Key: archiveDateField, Value: 1}}).SetProjection(bson.M{archiveDateField: 1}),
+	).Decode(&oldest)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return oldest.Date, true, nil
+}
+
+// ForEach calls fn with every record in collection with a transaction date from from (inclusive) to to (exclusive)
+func (s *ArchiveSource) ForEach(ctx context.Context, collection string, from, to time.Time, fn func(archive.Record) error) error {
+	cursor, err := s.m.db.Collection(collection).Find(ctx, bson.M{archiveDateField: bson.M{"$gte": from, "$lt": to}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		document, err := bson.MarshalExtJSON(cursor.Current, true, false)
+		if err != nil {
+			return err
+		}
+		// The ID is decoded into its own value, as cursor.Current is only valid until the next call to Next and the
+		// IDs are kept until the records are deleted
+		var id struct {
+			ID interface{} `bson:"_id"`
+		}
+		if err := cursor.Decode(&id); err != nil {
+			return err
+		}
+		if err := fn(archive.Record{ID: id.ID, Document: document}); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// Delete deletes the records in collection with the given IDs
+func (s *ArchiveSource) Delete(ctx context.Context, collection string, ids []interface{}) (int64, error) {
+	defer metrics.ObserveDuration(metrics.MongoDuration, "delete_archived", time.Now())
+
+	res, err := s.m.db.Collection(collection).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
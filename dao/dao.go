@@ -1,23 +1,102 @@
 package dao
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/companieshouse/payment-reconciliation-consumer/config"
+	"github.com/companieshouse/payment-reconciliation-consumer/encryption"
 	"github.com/companieshouse/payment-reconciliation-consumer/models"
 )
 
-// DAO provides access to the database
+// DAO provides access to the database. Create methods are idempotent and return ErrAlreadyExists when the record
+// has previously been stored.
 type DAO interface {
 	CreateEshuResource(dao *models.EshuResourceDao) error
 	CreatePaymentTransactionsResource(dao *models.PaymentTransactionsResourceDao) error
 	CreateRefundResource(dao *models.RefundResourceDao) error
+	CreatePaymentResources(eshus []models.EshuResourceDao, txns []models.PaymentTransactionsResourceDao, skippedCosts []models.SkippedCostResourceDao) error
+	Ping(ctx context.Context) error
+}
+
+// NewPaymentReconciliationDAOService returns a DAO which writes to the reconciliation collections, encrypting email
+// addresses with the field encryption key configured in cfg
+func NewPaymentReconciliationDAOService(cfg *config.Config) (DAO, error) {
+	m, err := newMongoService(cfg, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateOnStartup(cfg, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// NewDryRunDAOService returns a DAO which never writes to the reconciliation collections. When cfg has a shadow
+// collection suffix the records are written to collections with the suffix appended, otherwise they are only logged.
+func NewDryRunDAOService(cfg *config.Config) (DAO, error) {
+	if cfg.ShadowCollectionSuffix == "" {
+		return &Recorder{}, nil
+	}
+	shadow, err := newMongoService(cfg, cfg.ShadowCollectionSuffix)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateOnStartup(cfg, shadow); err != nil {
+		return nil, err
+	}
+	return &Recorder{Shadow: shadow}, nil
+}
+
+// NewExporter returns an Exporter for the reconciliation collections, which decrypts records with the field
+// encryption key configured in cfg
+func NewExporter(cfg *config.Config) (Exporter, error) {
+	return newMongoService(cfg, "")
 }
 
-func NewPaymentReconciliationDAOService(cfg *config.Config) DAO {
+// NewEraser returns an Eraser for the reconciliation collections, which records erasures in the erasures collection
+// configured in cfg
+func NewEraser(cfg *config.Config) (Eraser, error) {
+	return newMongoService(cfg, "")
+}
+
+// NewMigrator returns a Migrator for the reconciliation collections configured in cfg
+func NewMigrator(cfg *config.Config) (Migrator, error) {
+	return newMongoService(cfg, "")
+}
+
+func newMongoService(cfg *config.Config, collectionSuffix string) (*MongoService, error) {
+	// Email addresses are never stored in plain text, so the service cannot run without a key
+	keyring, err := encryption.Load(cfg.FieldEncryptionKeyID, cfg.FieldEncryptionKey, cfg.FieldEncryptionKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading field encryption key: %w", err)
+	}
+
 	database := getMongoDatabase(cfg.MongoDBURL, cfg.Database)
-	return &MongoService{
+	m := &MongoService{
 		db:                     database,
-		TransactionsCollection: cfg.TransactionsCollection,
-		ProductsCollection:     cfg.ProductsCollection,
-		RefundsCollection:      cfg.RefundsCollection,
+		TransactionsCollection: cfg.TransactionsCollection + collectionSuffix,
+		ProductsCollection:     cfg.ProductsCollection + collectionSuffix,
+		RefundsCollection:      cfg.RefundsCollection + collectionSuffix,
+		SkippedCostsCollection: cfg.SkippedCostsCollection + collectionSuffix,
+		ErasuresCollection:     cfg.ErasuresCollection + collectionSuffix,
+		MigrationsCollection:   cfg.MigrationsCollection + collectionSuffix,
+		Keyring:                keyring,
+	}
+	m.checkTransactionSupport(context.Background())
+
+	return m, nil
+}
+
+// migrateOnStartup applies any pending migrations when cfg asks for them to be run at startup. Redelivered messages
+// are only detected as already reconciled by the natural key indexes the migrations create, so a failed migration
+// stops the service from starting rather than letting it write duplicate records.
+func migrateOnStartup(cfg *config.Config, m *MongoService) error {
+	if !cfg.MigrateOnStartup {
+		return nil
+	}
+	if _, err := m.Migrate(context.Background()); err != nil {
+		return fmt.Errorf("error migrating reconciliation collections: %w", err)
 	}
+	return nil
 }
@@ -0,0 +1,52 @@
+package dao
+
+import (
+	"github.com/companieshouse/payment-reconciliation-consumer/encryption"
+	"github.com/companieshouse/payment-reconciliation-consumer/models"
+)
+
+// encryptTransaction returns a copy of the transaction to be stored, with its email replaced by the encrypted email
+// and its keyed hash. The transaction is returned unchanged when the MongoService has no Keyring.
+func (m *MongoService) encryptTransaction(t models.PaymentTransactionsResourceDao) (models.PaymentTransactionsResourceDao, error) {
+	var err error
+	t.EncryptedEmail, t.EmailHash, err = m.encryptEmail(&t.Email)
+	return t, err
+}
+
+// encryptRefund returns a copy of the refund to be stored, with its email replaced by the encrypted email and its
+// keyed hash. The refund is returned unchanged when the MongoService has no Keyring.
+func (m *MongoService) encryptRefund(r models.RefundResourceDao) (models.RefundResourceDao, error) {
+	var err error
+	r.EncryptedEmail, r.EmailHash, err = m.encryptEmail(&r.Email)
+	return r, err
+}
+
+// encryptEmail encrypts and hashes the email, clearing it so that it is not stored in plain text
+func (m *MongoService) encryptEmail(email *string) (*encryption.EncryptedValue, string, error) {
+	if m.Keyring == nil || *email == "" {
+		return nil, "", nil
+	}
+	encrypted, err := m.Keyring.Encrypt(*email)
+	if err != nil {
+		return nil, "", err
+	}
+	hash := m.Keyring.Hash(*email)
+	*email = ""
+	return encrypted, hash, nil
+}
+
+// decryptEmail sets email to the decrypted value of encrypted, leaving records stored in plain text alone
+func (m *MongoService) decryptEmail(email *string, encrypted *encryption.EncryptedValue) error {
+	if encrypted == nil {
+		return nil
+	}
+	if m.Keyring == nil {
+		return encryption.ErrUnknownKey
+	}
+	value, err := m.Keyring.Decrypt(encrypted)
+	if err != nil {
+		return err
+	}
+	*email = value
+	return nil
+}
@@ -0,0 +1,61 @@
+package dao
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/companieshouse/payment-reconciliation-consumer/encryption"
+	"github.com/companieshouse/payment-reconciliation-consumer/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitEncryptEmail(t *testing.T) {
+
+	keyring, err := encryption.NewKeyring("test", bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Given a MongoService with a keyring", t, func() {
+		m := &MongoService{Keyring: keyring}
+
+		Convey("Then the email of a stored transaction is encrypted and hashed, leaving the original alone", func() {
+			txn := models.PaymentTransactionsResourceDao{TransactionID: "XpaymentId", Email: "test@ch.gov.uk"}
+
+			document, err := m.encryptTransaction(txn)
+
+			So(err, ShouldBeNil)
+			So(document.Email, ShouldEqual, "")
+			So(document.EmailHash, ShouldEqual, keyring.Hash("test@ch.gov.uk"))
+			So(txn.Email, ShouldEqual, "test@ch.gov.uk")
+
+			email := ""
+			So(m.decryptEmail(&email, document.EncryptedEmail), ShouldBeNil)
+			So(email, ShouldEqual, "test@ch.gov.uk")
+		})
+
+		Convey("Then the email of a stored refund is encrypted and hashed", func() {
+			document, err := m.encryptRefund(models.RefundResourceDao{RefundID: "refundId", Email: "test@ch.gov.uk"})
+
+			So(err, ShouldBeNil)
+			So(document.Email, ShouldEqual, "")
+			So(document.EncryptedEmail, ShouldNotBeNil)
+			So(document.EmailHash, ShouldNotBeEmpty)
+		})
+
+		Convey("Then an empty email is left empty", func() {
+			document, err := m.encryptTransaction(models.PaymentTransactionsResourceDao{})
+
+			So(err, ShouldBeNil)
+			So(document.EncryptedEmail, ShouldBeNil)
+			So(document.EmailHash, ShouldEqual, "")
+		})
+
+		Convey("Then records stored in plain text are left alone when decrypted", func() {
+			email := "test@ch.gov.uk"
+
+			So(m.decryptEmail(&email, nil), ShouldBeNil)
+			So(email, ShouldEqual, "test@ch.gov.uk")
+		})
+	})
+}
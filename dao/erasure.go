@@ -0,0 +1,111 @@
+package dao
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/companieshouse/payment-reconciliation-consumer/metrics"
+	"github.com/companieshouse/payment-reconciliation-consumer/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// ErrNoRecords is returned when no reconciliation records are held for a data subject
+var ErrNoRecords = errors.New("no reconciliation records held for data subject")
+
+// Eraser finds a data subject's reconciliation records and pseudonymises their personal fields
+type Eraser interface {
+	Exporter
+	EraseSubject(ctx context.Context, subject Subject, requestedBy, reference string) (*models.ErasureAuditDao, error)
+}
+
+// EraseSubject replaces the email address held in the subject's transaction and refund records, whether encrypted or
+// in plain text, with a pseudonym, leaving the financial fields intact, and records an audit of the erasure. The records are changed and the
+// audit written in one multi-document transaction. ErrNoRecords is returned, and nothing is audited, when no records
+// are held for the subject.
+func (m *MongoService) EraseSubject(ctx context.Context, subject Subject, requestedBy, reference string) (*models.ErasureAuditDao, error) {
+	defer metrics.ObserveDuration(metrics.MongoDuration, "erase_subject", time.Now())
+
+	if requestedBy == "" || reference == "" {
+		return nil, errors.New("the person requesting the erasure and a reference for the request are required")
+	}
+
+	records, err := m.FindSubject(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+	if records.Empty() {
+		return nil, ErrNoRecords
+	}
+
+	erasureID, err := newErasureID()
+	if err != nil {
+		return nil, err
+	}
+	audit := &models.ErasureAuditDao{
+		ErasureID:    erasureID,
+		Pseudonym:    "erased-" + erasureID,
+		RequestedBy:  requestedBy,
+		Reference:    reference,
+		ErasedAt:     time.Now().UTC(),
+		Transactions: len(records.Transactions),
+		Refunds:      len(records.Refunds),
+		Products:     len(records.Products),
+	}
+	if m.Keyring != nil {
+		audit.EmailHash = m.Keyring.Hash(subject.Email)
+	}
+
+	session, err := m.db.Client().StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	collections := []*mongo.Collection{m.db.Collection(m.TransactionsCollection), m.db.Collection(m.RefundsCollection)}
+	erasures := m.db.Collection(m.ErasuresCollection)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		for _, collection := range collections {
+			if err := m.pseudonymise(sessCtx, collection, subject, audit.Pseudonym); err != nil {
+				return nil, err
+			}
+		}
+		return erasures.InsertOne(sessCtx, audit)
+	}, options.Transaction().SetWriteConcern(writeconcern.Majority()))
+
+	if isTransactionsUnsupported(err) {
+		return nil, ErrTransactionsUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return audit, nil
+}
+
+// pseudonymise replaces the subject's email address in the records of collection with pseudonym
+func (m *MongoService) pseudonymise(ctx context.Context, collection *mongo.Collection, subject Subject, pseudonym string) error {
+	update := bson.M{
+		"$set":   bson.M{"email": pseudonym},
+		"$unset": bson.M{"encrypted_email": "", "email_hash": ""},
+	}
+	if _, err := collection.UpdateMany(ctx, m.emailFilter(subject.Email), update); err != nil {
+		return fmt.Errorf("error erasing email addresses from %s: %w", collection.Name(), err)
+	}
+	return nil
+}
+
+func newErasureID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
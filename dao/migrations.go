@@ -0,0 +1,412 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/payment-reconciliation-consumer/models"
+	"github.com/companieshouse/payment-reconciliation-consumer/money"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration is a single versioned change to the reconciliation collections. Migrations are run in version order and
+// each is recorded in the migrations collection once it has succeeded, so that it is never run again. Instances of
+// the consumer can start at the same time and run the same migration together, so Up must be safe to run more than
+// once and concurrently.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, m *MongoService) error
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Version     int        `json:"version"`
+	Description string     `json:"description"`
+	AppliedAt   *time.Time `json:"applied_at,omitempty"`
+}
+
+// Migrator applies the migrations to the reconciliation collections
+type Migrator interface {
+	Migrate(ctx context.Context) ([]MigrationStatus, error)
+	MigrationStatus(ctx context.Context) ([]MigrationStatus, error)
+}
+
+// migrationBatchSize is the number of documents updated at once by data migrations
+const migrationBatchSize = 500
+
+// productsNaturalKey is the name of the unique index on the payment reference and cost line of product records. The
+// index used to be called natural_key and include the product code, and is named differently so that it can be built
+// before the old index is dropped.
+const productsNaturalKey = "payment_reference_cost_line"
+
+// Server error codes returned when an index is dropped or created by another instance running the same migration
+const (
+	indexNotFoundCode      = 27
+	indexAlreadyExistsCode = 68
+)
+
+// migrations must only ever be appended to. A migration that has been released must not be changed, as it will not be
+// run again where it has already been applied.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "unique indexes on the natural keys of reconciliation records",
+		Up:          createNaturalKeyIndexes,
+	},
+	{
+		Version:     2,
+		Description: "indexes on the keyed hash of email addresses, for exports and erasures",
+		Up: func(ctx context.Context, m *MongoService) error {
+			index := mongo.IndexModel{
+				Keys:    bson.D{{Key: "email_hash", Value: 1}},
+				Options: options.Index().SetName("email_hash").SetSparse(true),
+			}
+			return createIndex(ctx, m, index, m.TransactionsCollection, m.RefundsCollection)
+		},
+	},
+	{
+		Version:     3,
+		Description: "indexes on transaction date, for finance queries and archiving",
+		Up: func(ctx context.Context, m *MongoService) error {
+			index := mongo.IndexModel{
+				Keys:    bson.D{{Key: "transaction_date", Value: 1}},
+				Options: options.Index().SetName("transaction_date"),
+			}
+			return createIndex(ctx, m, index, m.ProductsCollection, m.TransactionsCollection, m.RefundsCollection)
+		},
+	},
+	{
+		Version:     4,
+		Description: "indexes on company number, and on the fields finance query that are not the first in a natural key",
+		Up: func(ctx context.Context, m *MongoService) error {
+			companyNumber := mongo.IndexModel{
+				Keys:    bson.D{{Key: "company_number", Value: 1}},
+				Options: options.Index().SetName("company_number"),
+			}
+			if err := createIndex(ctx, m, companyNumber, m.ProductsCollection, m.TransactionsCollection, m.RefundsCollection); err != nil {
+				return err
+			}
+			// The natural keys of the products and transactions collections already lead with these fields
+			transactionID := mongo.IndexModel{
+				Keys:    bson.D{{Key: "transaction_id", Value: 1}},
+				Options: options.Index().SetName("transaction_id"),
+			}
+			if err := createIndex(ctx, m, transactionID, m.RefundsCollection); err != nil {
+				return err
+			}
+			paymentReference := mongo.IndexModel{
+				Keys:    bson.D{{Key: "original_reference", Value: 1}},
+				Options: options.Index().SetName("original_reference"),
+			}
+			return createIndex(ctx, m, paymentReference, m.RefundsCollection)
+		},
+	},
+	{
+		Version:     5,
+		Description: "amount in pence for product and transaction records written before it was stored",
+		Up: func(ctx context.Context, m *MongoService) error {
+			// Refunds are left alone, as their amount used to be stored in whole pounds and the pence cannot be recovered
+			for _, collection := range []string{m.ProductsCollection, m.TransactionsCollection} {
+				if err := backfillAmountPence(ctx, m.db.Collection(collection)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     6,
+		Description: "unique index on the payment reference and cost line of product records, without the product code",
+		Up: func(ctx context.Context, m *MongoService) error {
+			// The product code a cost maps to can change between deliveries of the same payment, so it must not be
+			// part of the key. The new index is built before the old one is dropped, so that products are never
+			// written without a unique index. Building it fails if products have already been duplicated this way.
+			if err := createIndex(ctx, m, productsNaturalKeyIndex(), m.ProductsCollection); err != nil {
+				return err
+			}
+			return dropIndexIfKeyedOn(ctx, m.db.Collection(m.ProductsCollection), "natural_key", "product_code")
+		},
+	},
+	{
+		Version:     7,
+		Description: "encrypted and hashed email addresses for transaction and refund records written in plain text",
+		Up: func(ctx context.Context, m *MongoService) error {
+			for _, collection := range []string{m.TransactionsCollection, m.RefundsCollection} {
+				if err := m.encryptPlaintextEmails(ctx, m.db.Collection(collection)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     8,
+		Description: "index on the transaction date of skipped cost records, for archiving",
+		Up: func(ctx context.Context, m *MongoService) error {
+			index := mongo.IndexModel{
+				Keys:    bson.D{{Key: "transaction_date", Value: 1}},
+				Options: options.Index().SetName("transaction_date"),
+			}
+			return createIndex(ctx, m, index, m.SkippedCostsCollection)
+		},
+	},
+}
+
+// Migrate runs every migration which has not yet been applied, in version order, and returns those it applied. It
+// stops at the first migration that fails.
+func (m *MongoService) Migrate(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []MigrationStatus
+	for _, migration := range sortedMigrations() {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		log.Info("running migration", log.Data{"version": migration.Version, "description": migration.Description})
+		if err := migration.Up(ctx, m); err != nil {
+			return ran, fmt.Errorf("error running migration %d: %w", migration.Version, err)
+		}
+
+		record := models.MigrationDao{Version: migration.Version, Description: migration.Description, AppliedAt: time.Now().UTC()}
+		_, err := m.db.Collection(m.MigrationsCollection).InsertOne(ctx, record)
+		// Another instance may have run the same migration at the same time
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return ran, fmt.Errorf("error recording migration %d: %w", migration.Version, err)
+		}
+		ran = append(ran, MigrationStatus{Version: record.Version, Description: record.Description, AppliedAt: &record.AppliedAt})
+	}
+	return ran, nil
+}
+
+// MigrationStatus returns every migration, with the time it was applied if it has been
+func (m *MongoService) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, migration := range sortedMigrations() {
+		status := MigrationStatus{Version: migration.Version, Description: migration.Description}
+		if record, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &record.AppliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (m *MongoService) appliedMigrations(ctx context.Context) (map[int]models.MigrationDao, error) {
+	var records []models.MigrationDao
+	if err := find(ctx, m.db.Collection(m.MigrationsCollection), bson.M{}, &records); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]models.MigrationDao, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+func sortedMigrations() []Migration {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	return sorted
+}
+
+// createNaturalKeyIndexes creates the unique indexes backing the natural keys used to write reconciliation records.
+// Records written before cost lines were introduced are excluded so that existing data cannot block index creation.
+func createNaturalKeyIndexes(ctx context.Context, m *MongoService) error {
+	indexes := map[string]mongo.IndexModel{
+		m.ProductsCollection: productsNaturalKeyIndex(),
+		m.TransactionsCollection: {
+			Keys: bson.D{{Key: "transaction_id", Value: 1}, {Key: "cost_line", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("natural_key").
+				SetPartialFilterExpression(bson.M{"cost_line": bson.M{"$exists": true}}),
+		},
+		m.RefundsCollection: {
+			Keys: bson.D{{Key: "refund_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("natural_key").
+				SetPartialFilterExpression(bson.M{"refund_id": bson.M{"$type": "string"}}),
+		},
+		m.SkippedCostsCollection: {
+			Keys:    bson.D{{Key: "payment_reference", Value: 1}, {Key: "cost_line", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("natural_key"),
+		},
+	}
+
+	for collection, index := range indexes {
+		if err := createIndex(ctx, m, index, collection); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// productsNaturalKeyIndex is the unique index on the payment reference and cost line of product records
+func productsNaturalKeyIndex() mongo.IndexModel {
+	return mongo.IndexModel{
+		Keys: bson.D{{Key: "payment_reference", Value: 1}, {Key: "cost_line", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName(productsNaturalKey).
+			SetPartialFilterExpression(bson.M{"cost_line": bson.M{"$exists": true}}),
+	}
+}
+
+// createIndex creates the index on each of the collections. Creating an index which already exists does nothing.
+func createIndex(ctx context.Context, m *MongoService, index mongo.IndexModel, collections ...string) error {
+	for _, collection := range collections {
+		_, err := m.db.Collection(collection).Indexes().CreateOne(ctx, index)
+		if err != nil && !hasErrorCode(err, indexAlreadyExistsCode) {
+			return fmt.Errorf("error creating index on %s: %w", collection, err)
+		}
+		log.Info("ensured index", log.Data{"collection": collection, "keys": index.Keys})
+	}
+	return nil
+}
+
+// dropIndexIfKeyedOn drops the index called name from collection if its keys include field. An index dropped by
+// another instance in the meantime is treated as dropped.
+func dropIndexIfKeyedOn(ctx context.Context, collection *mongo.Collection, name, field string) error {
+	specs, err := collection.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing indexes on %s: %w", collection.Name(), err)
+	}
+	for _, spec := range specs {
+		if spec.Name != name {
+			continue
+		}
+		if _, err := spec.KeysDocument.LookupErr(field); err != nil {
+			return nil
+		}
+		if _, err := collection.Indexes().DropOne(ctx, name); err != nil && !hasErrorCode(err, indexNotFoundCode) {
+			return fmt.Errorf("error dropping index %s on %s: %w", name, collection.Name(), err)
+		}
+		log.Info("dropped index", log.Data{"collection": collection.Name(), "index": name})
+	}
+	return nil
+}
+
+// hasErrorCode reports whether err is a server error with code
+func hasErrorCode(err error, code int) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorCode(code)
+}
+
+// backfillAmountPence sets amount_pence from amount for every record in collection which does not have one. Records
+// whose amount cannot be parsed are logged and left alone.
+func backfillAmountPence(ctx context.Context, collection *mongo.Collection) error {
+	cursor, err := collection.Find(ctx,
+		bson.M{"amount_pence": bson.M{"$exists": false}, "amount": bson.M{"$type": "string"}},
+		options.Find().SetProjection(bson.M{"amount": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var updates []mongo.WriteModel
+	flush := func() error {
+		if len(updates) == 0 {
+			return nil
+		}
+		_, err := collection.BulkWrite(ctx, updates, options.BulkWrite().SetOrdered(false))
+		updates = updates[:0]
+		return err
+	}
+
+	for cursor.Next(ctx) {
+		var record struct {
+			ID     interface{} `bson:"_id"`
+			Amount string      `bson:"amount"`
+		}
+		if err := cursor.Decode(&record); err != nil {
+			return err
+		}
+		amount, err := money.Parse(record.Amount)
+		if err != nil {
+			log.Info("amount cannot be converted to pence, leaving record alone", log.Data{"collection": collection.Name(), "id": record.ID})
+			continue
+		}
+		// Matching on the missing field keeps a concurrent run from overwriting the value
+		updates = append(updates, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": record.ID, "amount_pence": bson.M{"$exists": false}}).
+			SetUpdate(bson.M{"$set": bson.M{"amount_pence": amount}}))
+		if len(updates) == migrationBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// encryptPlaintextEmails replaces the plain text email address of every record in collection written before email
+// addresses were encrypted with the encrypted address and its keyed hash
+func (m *MongoService) encryptPlaintextEmails(ctx context.Context, collection *mongo.Collection) error {
+	if m.Keyring == nil {
+		return errors.New("email addresses cannot be encrypted without a field encryption key")
+	}
+
+	cursor, err := collection.Find(ctx,
+		bson.M{"email": bson.M{"$type": "string", "$ne": ""}, "encrypted_email": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"email": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var updates []mongo.WriteModel
+	flush := func() error {
+		if len(updates) == 0 {
+			return nil
+		}
+		_, err := collection.BulkWrite(ctx, updates, options.BulkWrite().SetOrdered(false))
+		updates = updates[:0]
+		return err
+	}
+
+	for cursor.Next(ctx) {
+		var record struct {
+			ID    interface{} `bson:"_id"`
+			Email string      `bson:"email"`
+		}
+		if err := cursor.Decode(&record); err != nil {
+			return err
+		}
+		email := record.Email
+		encrypted, hash, err := m.encryptEmail(&email)
+		if err != nil {
+			return err
+		}
+		// Matching on the plain text address keeps a concurrent run from encrypting the record twice
+		updates = append(updates, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": record.ID, "email": record.Email, "encrypted_email": bson.M{"$exists": false}}).
+			SetUpdate(bson.M{
+				"$set":   bson.M{"encrypted_email": encrypted, "email_hash": hash},
+				"$unset": bson.M{"email": ""},
+			}))
+		if len(updates) == migrationBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
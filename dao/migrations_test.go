@@ -0,0 +1,30 @@
+package dao
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitMigrations(t *testing.T) {
+
+	Convey("Given the migrations", t, func() {
+
+		Convey("Then they are numbered from one without gaps, in the order they were added", func() {
+			for i, migration := range migrations {
+				So(migration.Version, ShouldEqual, i+1)
+				So(migration.Description, ShouldNotBeEmpty)
+				So(migration.Up, ShouldNotBeNil)
+			}
+		})
+
+		Convey("Then they are run in version order", func() {
+			sorted := sortedMigrations()
+
+			So(sorted, ShouldHaveLength, len(migrations))
+			for i := 1; i < len(sorted); i++ {
+				So(sorted[i].Version, ShouldBeGreaterThan, sorted[i-1].Version)
+			}
+		})
+	})
+}
@@ -5,6 +5,7 @@
 package dao
 
 import (
+	context "context"
 	models "github.com/companieshouse/payment-reconciliation-consumer/models"
 	gomock "github.com/golang/mock/gomock"
 	reflect "reflect"
@@ -74,3 +75,31 @@ func (mr *MockDAOMockRecorder) CreateRefundResource(dao interface{}) *gomock.Cal
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateRefundResource", reflect.TypeOf((*MockDAO)(nil).CreateRefundResource), dao)
 }
+
+// CreatePaymentResources mocks base method
+func (m *MockDAO) CreatePaymentResources(eshus []models.EshuResourceDao, txns []models.PaymentTransactionsResourceDao, skippedCosts []models.SkippedCostResourceDao) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreatePaymentResources", eshus, txns, skippedCosts)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreatePaymentResources indicates an expected call of CreatePaymentResources
+func (mr *MockDAOMockRecorder) CreatePaymentResources(eshus, txns, skippedCosts interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreatePaymentResources", reflect.TypeOf((*MockDAO)(nil).CreatePaymentResources), eshus, txns, skippedCosts)
+}
+
+// Ping mocks base method
+func (m *MockDAO) Ping(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ping", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ping indicates an expected call of Ping
+func (mr *MockDAOMockRecorder) Ping(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockDAO)(nil).Ping), ctx)
+}
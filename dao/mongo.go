@@ -3,18 +3,43 @@ package dao
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/payment-reconciliation-consumer/encryption"
+	"github.com/companieshouse/payment-reconciliation-consumer/keys"
+	"github.com/companieshouse/payment-reconciliation-consumer/metrics"
 	"github.com/companieshouse/payment-reconciliation-consumer/models"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 	"os"
+	"sync"
 	"time"
 )
 
-var client *mongo.Client
+// ErrAlreadyExists is returned when a reconciliation record with the same natural key has already been stored
+var ErrAlreadyExists = errors.New("reconciliation record already exists")
+
+// ErrTransactionsUnsupported is returned when the MongoDB deployment is not a replica set (or sharded cluster) and so
+// cannot run multi-document transactions
+var ErrTransactionsUnsupported = errors.New("mongodb deployment does not support multi-document transactions")
+
+// illegalOperationCode is the server error code returned when a transaction is attempted against a standalone server
+const illegalOperationCode = 20
+
+// clients holds a client for each mongodb url connected to, so that every DAO sharing a url shares its connection pool
+var (
+	clientsMu sync.Mutex
+	clients   = map[string]*mongo.Client{}
+)
 
 func getMongoClient(mongoDBURL string) *mongo.Client {
-	if client != nil {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	if client, ok := clients[mongoDBURL]; ok {
 		return client
 	}
 
@@ -41,46 +66,226 @@ func getMongoClient(mongoDBURL string) *mongo.Client {
 
 	log.Info("connected to mongodb successfully")
 
+	clients[mongoDBURL] = client
 	return client
 }
 
 // MongoDatabaseInterface is an interface that describes the mongodb driver
 type MongoDatabaseInterface interface {
 	Collection(name string, opts ...*options.CollectionOptions) *mongo.Collection
+	Client() *mongo.Client
 }
 
 func getMongoDatabase(mongoDBURL, databaseName string) MongoDatabaseInterface {
 	return getMongoClient(mongoDBURL).Database(databaseName)
 }
 
-// MongoService is an implementation of the Service interface using MongoDB as the backend driver.
+// MongoService is an implementation of the Service interface using MongoDB as the backend driver. Email addresses
+// are encrypted with Keyring before they are stored.
 type MongoService struct {
 	db                     MongoDatabaseInterface
 	TransactionsCollection string
 	ProductsCollection     string
 	RefundsCollection      string
+	SkippedCostsCollection string
+	ErasuresCollection     string
+	MigrationsCollection   string
+	Keyring                *encryption.Keyring
+
+	transactionsUnsupported bool
 }
 
-// CreateEshuResource will store the eshu file details into the database
+// CreateEshuResource will store the eshu file details into the database, unless a record for the same
+// payment reference and cost line already exists
 func (m *MongoService) CreateEshuResource(eshuResource *models.EshuResourceDao) error {
+	defer metrics.ObserveDuration(metrics.MongoDuration, "create_eshu_resource", time.Now())
 	collection := m.db.Collection(m.ProductsCollection)
-	_, err := collection.InsertOne(context.Background(), eshuResource)
-
-	return err
+	return insertIfAbsent(context.Background(), collection, eshuFilter(eshuResource), eshuResource)
 }
 
-// CreatePaymentTransactionsResource will store the payment_transaction file details into the database
+// CreatePaymentTransactionsResource will store the payment_transaction file details into the database, unless a
+// record for the same transaction id and cost line already exists
 func (m *MongoService) CreatePaymentTransactionsResource(paymentTransactionsResource *models.PaymentTransactionsResourceDao) error {
+	defer metrics.ObserveDuration(metrics.MongoDuration, "create_payment_transactions_resource", time.Now())
+	document, err := m.encryptTransaction(*paymentTransactionsResource)
+	if err != nil {
+		return err
+	}
 	collection := m.db.Collection(m.TransactionsCollection)
-	_, err := collection.InsertOne(context.Background(), paymentTransactionsResource)
-
-	return err
+	return insertIfAbsent(context.Background(), collection, transactionFilter(paymentTransactionsResource), &document)
 }
 
-// CreateRefundResource will store the refund file details into the database
+// CreateRefundResource will store the refund file details into the database, unless a record for the same refund id
+// already exists
 func (m *MongoService) CreateRefundResource(refundResource *models.RefundResourceDao) error {
+	defer metrics.ObserveDuration(metrics.MongoDuration, "create_refund_resource", time.Now())
+	document, err := m.encryptRefund(*refundResource)
+	if err != nil {
+		return err
+	}
 	collection := m.db.Collection(m.RefundsCollection)
-	_, err := collection.InsertOne(context.Background(), refundResource)
+	return insertIfAbsent(context.Background(), collection, refundFilter(refundResource), &document)
+}
+
+// CreatePaymentResources will store all of the eshu and payment_transaction file details for a single payment, along
+// with any of its costs which were skipped, in one multi-document transaction, so that either every record is written
+// or none are. ErrAlreadyExists is returned if every record had previously been stored, and ErrTransactionsUnsupported
+// if the deployment is not a replica set.
+func (m *MongoService) CreatePaymentResources(eshuResources []models.EshuResourceDao, paymentTransactionsResources []models.PaymentTransactionsResourceDao, skippedCostResources []models.SkippedCostResourceDao) error {
+	defer metrics.ObserveDuration(metrics.MongoDuration, "create_payment_resources", time.Now())
+
+	if m.transactionsUnsupported {
+		return ErrTransactionsUnsupported
+	}
+
+	ctx := context.Background()
+
+	// Encrypt up front, so that a transaction retried by WithTransaction writes the same ciphertext each time
+	documents := make([]models.PaymentTransactionsResourceDao, len(paymentTransactionsResources))
+	for i := range paymentTransactionsResources {
+		document, err := m.encryptTransaction(paymentTransactionsResources[i])
+		if err != nil {
+			return err
+		}
+		documents[i] = document
+	}
+
+	session, err := m.db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	products := m.db.Collection(m.ProductsCollection)
+	transactions := m.db.Collection(m.TransactionsCollection)
+	skippedCosts := m.db.Collection(m.SkippedCostsCollection)
+
+	// WithTransaction retries both the callback on transient errors and the commit when its outcome is unknown, so the
+	// callback must be safe to run more than once - which the upserts are.
+	inserted, err := session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		inserted := 0
+		for i := range eshuResources {
+			res, err := upsert(sessCtx, products, eshuFilter(&eshuResources[i]), &eshuResources[i])
+			if err != nil {
+				return nil, err
+			}
+			inserted += int(res.UpsertedCount)
+		}
+		for i := range paymentTransactionsResources {
+			res, err := upsert(sessCtx, transactions, transactionFilter(&paymentTransactionsResources[i]), &documents[i])
+			if err != nil {
+				return nil, err
+			}
+			inserted += int(res.UpsertedCount)
+		}
+		for i := range skippedCostResources {
+			res, err := upsert(sessCtx, skippedCosts, skippedCostFilter(&skippedCostResources[i]), &skippedCostResources[i])
+			if err != nil {
+				return nil, err
+			}
+			inserted += int(res.UpsertedCount)
+		}
+		return inserted, nil
+	}, options.Transaction().SetWriteConcern(writeconcern.Majority()))
+
+	if isTransactionsUnsupported(err) {
+		return ErrTransactionsUnsupported
+	}
+	if err != nil {
+		return err
+	}
+
+	if inserted.(int) == 0 {
+		return ErrAlreadyExists
+	}
+
+	return nil
+}
+
+// Ping checks that the primary of the MongoDB deployment can be reached, and returns ErrTransactionsUnsupported if
+// the deployment was found not to support transactions
+func (m *MongoService) Ping(ctx context.Context) error {
+	if m.transactionsUnsupported {
+		return ErrTransactionsUnsupported
+	}
+	return m.db.Client().Ping(ctx, readpref.Primary())
+}
+
+// checkTransactionSupport records whether the MongoDB deployment can run multi-document transactions, which only
+// replica sets and sharded clusters can. Once a deployment is found not to, payment resources are never written and
+// Ping fails. The deployment is assumed to support transactions if it cannot be asked.
+func (m *MongoService) checkTransactionSupport(ctx context.Context) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := m.db.Client().Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		log.Error(fmt.Errorf("error checking whether mongodb supports transactions: %s", err), nil)
+		return
+	}
+
+	m.transactionsUnsupported = hello.SetName == "" && hello.Msg != "isdbgrid"
+	if m.transactionsUnsupported {
+		log.Error(ErrTransactionsUnsupported, log.Data{keys.Message: "payment resources cannot be written"})
+	}
+}
+
+// IsPermanent reports whether err is a database error that retrying cannot resolve
+func IsPermanent(err error) bool {
+	return errors.Is(err, ErrTransactionsUnsupported)
+}
+
+func eshuFilter(eshuResource *models.EshuResourceDao) bson.M {
+	return bson.M{
+		"payment_reference": eshuResource.PaymentRef,
+		"cost_line":         eshuResource.CostLine,
+	}
+}
+
+func transactionFilter(paymentTransactionsResource *models.PaymentTransactionsResourceDao) bson.M {
+	return bson.M{
+		"transaction_id": paymentTransactionsResource.TransactionID,
+		"cost_line":      paymentTransactionsResource.CostLine,
+	}
+}
+
+func refundFilter(refundResource *models.RefundResourceDao) bson.M {
+	return bson.M{"refund_id": refundResource.RefundID}
+}
+
+func skippedCostFilter(skippedCostResource *models.SkippedCostResourceDao) bson.M {
+	return bson.M{
+		"payment_reference": skippedCostResource.PaymentRef,
+		"cost_line":         skippedCostResource.CostLine,
+	}
+}
+
+// insertIfAbsent upserts the document using the natural key held in filter so that redelivered messages never
+// create a second copy of a record. ErrAlreadyExists is returned when nothing new was written.
+func insertIfAbsent(ctx context.Context, collection *mongo.Collection, filter bson.M, document interface{}) error {
+	res, err := upsert(ctx, collection, filter, document)
+
+	// A concurrent writer can win the race between the match and the insert, in which case the unique index rejects us
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAlreadyExists
+	}
+	if err != nil {
+		return err
+	}
+
+	if res.UpsertedCount == 0 {
+		return ErrAlreadyExists
+	}
+
+	return nil
+}
+
+// upsert writes the document only if no document matches filter
+func upsert(ctx context.Context, collection *mongo.Collection, filter bson.M, document interface{}) (*mongo.UpdateResult, error) {
+	return collection.UpdateOne(ctx, filter, bson.M{"$setOnInsert": document}, options.Update().SetUpsert(true))
+}
 
-	return err
+// isTransactionsUnsupported reports whether err was caused by starting a transaction against a standalone server
+func isTransactionsUnsupported(err error) bool {
+	return hasErrorCode(err, illegalOperationCode)
 }
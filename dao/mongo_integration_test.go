@@ -1,12 +1,19 @@
 package dao
 
 import (
+	"bytes"
 	"context"
 	"testing"
+	"time"
 
+	"github.com/companieshouse/payment-reconciliation-consumer/archive"
+	"github.com/companieshouse/payment-reconciliation-consumer/encryption"
 	"github.com/companieshouse/payment-reconciliation-consumer/models"
 	testutils "github.com/companieshouse/payment-reconciliation-consumer/testutil"
 	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func TestIntegrationGetMongoClient(t *testing.T) {
@@ -26,6 +33,11 @@ func TestIntegrationGetMongoClient(t *testing.T) {
 			getMongoDatabase(uri, "test")
 		})
 
+		Convey("Ping succeeds while mongo is reachable", func() {
+			mongoService := &MongoService{db: getMongoDatabase(uri, "test")}
+			So(mongoService.Ping(context.Background()), ShouldBeNil)
+		})
+
 		Convey("Create Eshu resource will store into the new database", func() {
 			eshuResource := &models.EshuResourceDao{
 				PaymentRef:    "test-payment-ref",
@@ -87,6 +99,343 @@ func TestIntegrationGetMongoClient(t *testing.T) {
 			So(db.Collection("refunds").FindOne(context.Background(), map[string]interface{}{"transaction_id": "test-transactrion-id"}).Err(), ShouldBeNil)
 		})
 
+		Convey("Creating the same resources twice reports that they already exist and stores a single copy", func() {
+			m := &MongoService{
+				db:                     getMongoDatabase(uri, "idempotency"),
+				TransactionsCollection: "transactions",
+				ProductsCollection:     "products",
+				RefundsCollection:      "refunds",
+				SkippedCostsCollection: "skipped_costs",
+				MigrationsCollection:   "migrations",
+			}
+			So(migrate(m), ShouldBeNil)
+
+			eshuResource := &models.EshuResourceDao{PaymentRef: "XpaymentId", ProductCode: 27000, CostLine: 1}
+			So(m.CreateEshuResource(eshuResource), ShouldBeNil)
+			So(m.CreateEshuResource(eshuResource), ShouldEqual, ErrAlreadyExists)
+
+			// The product map can change between deliveries of the same payment
+			remapped := &models.EshuResourceDao{PaymentRef: "XpaymentId", ProductCode: 27001, CostLine: 1}
+			So(m.CreateEshuResource(remapped), ShouldEqual, ErrAlreadyExists)
+
+			transactionResource := &models.PaymentTransactionsResourceDao{TransactionID: "XpaymentId", CostLine: 1}
+			So(m.CreatePaymentTransactionsResource(transactionResource), ShouldBeNil)
+			So(m.CreatePaymentTransactionsResource(transactionResource), ShouldEqual, ErrAlreadyExists)
+
+			refundResource := &models.RefundResourceDao{TransactionID: "XrefundId", RefundID: "refundId"}
+			So(m.CreateRefundResource(refundResource), ShouldBeNil)
+			So(m.CreateRefundResource(refundResource), ShouldEqual, ErrAlreadyExists)
+
+			db := getMongoDatabase(uri, "idempotency")
+			products, _ := db.Collection("products").CountDocuments(context.Background(), map[string]interface{}{"payment_reference": "XpaymentId"})
+			So(products, ShouldEqual, 1)
+			transactions, _ := db.Collection("transactions").CountDocuments(context.Background(), map[string]interface{}{"transaction_id": "XpaymentId"})
+			So(transactions, ShouldEqual, 1)
+			refunds, _ := db.Collection("refunds").CountDocuments(context.Background(), map[string]interface{}{"refund_id": "refundId"})
+			So(refunds, ShouldEqual, 1)
+		})
+
+		Convey("Creating payment resources in a transaction fails cleanly against a standalone server", func() {
+			m := &MongoService{
+				db:                     getMongoDatabase(uri, "standalone"),
+				TransactionsCollection: "transactions",
+				ProductsCollection:     "products",
+				RefundsCollection:      "refunds",
+			}
+
+			err := m.CreatePaymentResources(
+				[]models.EshuResourceDao{{PaymentRef: "XpaymentId"}},
+				[]models.PaymentTransactionsResourceDao{{TransactionID: "XpaymentId"}},
+				nil)
+			So(err, ShouldEqual, ErrTransactionsUnsupported)
+
+			db := getMongoDatabase(uri, "standalone")
+			products, _ := db.Collection("products").CountDocuments(context.Background(), map[string]interface{}{})
+			So(products, ShouldEqual, 0)
+
+			m.checkTransactionSupport(context.Background())
+			So(m.Ping(context.Background()), ShouldEqual, ErrTransactionsUnsupported)
+		})
+
 	})
 
 }
+
+func TestIntegrationCreatePaymentResources(t *testing.T) {
+
+	Convey("Given that testcontainers has started a mongo replica set", t, func() {
+		container, uri, err := testutils.SetupMongoReplicaSetContainer()
+		So(err, ShouldBeNil)
+		defer container.Terminate(context.Background())
+
+		db := getMongoDatabase(uri, "test")
+		m := &MongoService{
+			db:                     db,
+			TransactionsCollection: "transactions",
+			ProductsCollection:     "products",
+			RefundsCollection:      "refunds",
+			SkippedCostsCollection: "skipped_costs",
+			MigrationsCollection:   "migrations",
+		}
+		So(migrate(m), ShouldBeNil)
+
+		eshus := []models.EshuResourceDao{
+			{PaymentRef: "XpaymentId", ProductCode: 27000, CostLine: 0},
+			{PaymentRef: "XpaymentId", ProductCode: 27000, CostLine: 1},
+		}
+		txns := []models.PaymentTransactionsResourceDao{
+			{TransactionID: "XpaymentId", CostLine: 0},
+			{TransactionID: "XpaymentId", CostLine: 1},
+		}
+		skippedCosts := []models.SkippedCostResourceDao{
+			{PaymentRef: "XpaymentId", CostLine: 2, Reason: "class of payment is reconciled elsewhere"},
+		}
+
+		Convey("Then all of the payment resources are written together", func() {
+			So(m.CreatePaymentResources(eshus, txns, skippedCosts), ShouldBeNil)
+
+			products, _ := db.Collection("products").CountDocuments(context.Background(), map[string]interface{}{"payment_reference": "XpaymentId"})
+			So(products, ShouldEqual, 2)
+			transactions, _ := db.Collection("transactions").CountDocuments(context.Background(), map[string]interface{}{"transaction_id": "XpaymentId"})
+			So(transactions, ShouldEqual, 2)
+			skipped, _ := db.Collection("skipped_costs").CountDocuments(context.Background(), map[string]interface{}{"payment_reference": "XpaymentId"})
+			So(skipped, ShouldEqual, 1)
+
+			Convey("And writing them again reports that they already exist", func() {
+				So(m.CreatePaymentResources(eshus, txns, skippedCosts), ShouldEqual, ErrAlreadyExists)
+
+				products, _ := db.Collection("products").CountDocuments(context.Background(), map[string]interface{}{"payment_reference": "XpaymentId"})
+				So(products, ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+func TestIntegrationFindSubject(t *testing.T) {
+
+	Convey("Given that testcontainers has started a mongo container", t, func() {
+		container, uri, _ := testutils.SetupMongoContainer()
+		defer container.Terminate(context.Background())
+
+		keyring, err := encryption.NewKeyring("test", bytes.Repeat([]byte{1}, 32))
+		So(err, ShouldBeNil)
+
+		db := getMongoDatabase(uri, "encryption")
+		m := &MongoService{
+			db:                     db,
+			TransactionsCollection: "transactions",
+			ProductsCollection:     "products",
+			RefundsCollection:      "refunds",
+			SkippedCostsCollection: "skipped_costs",
+			MigrationsCollection:   "migrations",
+			Keyring:                keyring,
+		}
+		So(migrate(m), ShouldBeNil)
+
+		So(m.CreatePaymentTransactionsResource(&models.PaymentTransactionsResourceDao{TransactionID: "XpaymentId", Email: "test@ch.gov.uk"}), ShouldBeNil)
+		So(m.CreateRefundResource(&models.RefundResourceDao{RefundID: "refundId", Email: "test@ch.gov.uk"}), ShouldBeNil)
+
+		Convey("Then email addresses are not stored in plain text", func() {
+			count, _ := db.Collection("transactions").CountDocuments(context.Background(), map[string]interface{}{"email": "test@ch.gov.uk"})
+			So(count, ShouldEqual, 0)
+		})
+
+		Convey("Then records are found by email address and decrypted", func() {
+			records, err := m.FindSubject(context.Background(), Subject{Email: "Test@ch.gov.uk"})
+
+			So(err, ShouldBeNil)
+			So(records.Transactions, ShouldHaveLength, 1)
+			So(records.Transactions[0].Email, ShouldEqual, "test@ch.gov.uk")
+			So(records.Refunds, ShouldHaveLength, 1)
+			So(records.Refunds[0].Email, ShouldEqual, "test@ch.gov.uk")
+		})
+	})
+}
+
+func TestIntegrationEraseSubject(t *testing.T) {
+
+	Convey("Given that testcontainers has started a mongo replica set", t, func() {
+		container, uri, err := testutils.SetupMongoReplicaSetContainer()
+		So(err, ShouldBeNil)
+		defer container.Terminate(context.Background())
+
+		keyring, err := encryption.NewKeyring("test", bytes.Repeat([]byte{1}, 32))
+		So(err, ShouldBeNil)
+
+		db := getMongoDatabase(uri, "erasure")
+		m := &MongoService{
+			db:                     db,
+			TransactionsCollection: "transactions",
+			ProductsCollection:     "products",
+			RefundsCollection:      "refunds",
+			ErasuresCollection:     "erasures",
+			SkippedCostsCollection: "skipped_costs",
+			MigrationsCollection:   "migrations",
+			Keyring:                keyring,
+		}
+		So(migrate(m), ShouldBeNil)
+
+		So(m.CreateEshuResource(&models.EshuResourceDao{PaymentRef: "XpaymentId", ProductCode: 27000}), ShouldBeNil)
+		So(m.CreatePaymentTransactionsResource(&models.PaymentTransactionsResourceDao{TransactionID: "XpaymentId", Email: "test@ch.gov.uk", Amount: "10.00"}), ShouldBeNil)
+		So(m.CreateRefundResource(&models.RefundResourceDao{RefundID: "refundId", OriginalReference: "XpaymentId", Email: "test@ch.gov.uk"}), ShouldBeNil)
+
+		Convey("Then the subject's records and the products of their payments are found", func() {
+			records, err := m.FindSubject(context.Background(), Subject{Email: "test@ch.gov.uk"})
+
+			So(err, ShouldBeNil)
+			So(records.Transactions, ShouldHaveLength, 1)
+			So(records.Refunds, ShouldHaveLength, 1)
+			So(records.Products, ShouldHaveLength, 1)
+		})
+
+		Convey("Then erasing the subject pseudonymises their records and audits the erasure", func() {
+			audit, err := m.EraseSubject(context.Background(), Subject{Email: "test@ch.gov.uk"}, "tester", "REQ-1")
+
+			So(err, ShouldBeNil)
+			So(audit.Transactions, ShouldEqual, 1)
+			So(audit.EmailHash, ShouldEqual, keyring.Hash("test@ch.gov.uk"))
+
+			var txn models.PaymentTransactionsResourceDao
+			So(db.Collection("transactions").FindOne(context.Background(), map[string]interface{}{"transaction_id": "XpaymentId"}).Decode(&txn), ShouldBeNil)
+			So(txn.Email, ShouldEqual, audit.Pseudonym)
+			So(txn.EncryptedEmail, ShouldBeNil)
+			So(txn.Amount, ShouldEqual, "10.00")
+
+			audits, _ := db.Collection("erasures").CountDocuments(context.Background(), map[string]interface{}{"reference": "REQ-1"})
+			So(audits, ShouldEqual, 1)
+
+			Convey("And the subject can no longer be found", func() {
+				_, err := m.EraseSubject(context.Background(), Subject{Email: "test@ch.gov.uk"}, "tester", "REQ-2")
+
+				So(err, ShouldEqual, ErrNoRecords)
+			})
+		})
+	})
+}
+
+func TestIntegrationArchiveSource(t *testing.T) {
+
+	Convey("Given that testcontainers has started a mongo container", t, func() {
+		container, uri, _ := testutils.SetupMongoContainer()
+		defer container.Terminate(context.Background())
+
+		db := getMongoDatabase(uri, "archive")
+		source := &ArchiveSource{m: &MongoService{
+			db:                     db,
+			TransactionsCollection: "transactions",
+			ProductsCollection:     "products",
+			RefundsCollection:      "refunds",
+			SkippedCostsCollection: "skipped_costs",
+		}}
+		old := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+		So(source.m.CreateEshuResource(&models.EshuResourceDao{PaymentRef: "Xold", TransactionDate: old}), ShouldBeNil)
+		So(source.m.CreateEshuResource(&models.EshuResourceDao{PaymentRef: "Xolder", TransactionDate: old.Add(-time.Hour)}), ShouldBeNil)
+		So(source.m.CreateEshuResource(&models.EshuResourceDao{PaymentRef: "Xnew", TransactionDate: time.Now()}), ShouldBeNil)
+		_, err := db.Collection("skipped_costs").InsertOne(context.Background(), models.SkippedCostResourceDao{PaymentRef: "Xold", CostLine: 1, TransactionDate: old})
+		So(err, ShouldBeNil)
+
+		Convey("Then records older than the cutoff are archived and deleted", func() {
+			archiver := &archive.Archiver{Source: source, Path: t.TempDir()}
+
+			partitions, err := archiver.Run(context.Background(), old.AddDate(0, 0, 1))
+
+			So(err, ShouldBeNil)
+			So(partitions, ShouldHaveLength, 2)
+			So(partitions[0].Deleted, ShouldEqual, 2)
+			So(partitions[1].Deleted, ShouldEqual, 1)
+			products, _ := db.Collection("products").CountDocuments(context.Background(), map[string]interface{}{})
+			So(products, ShouldEqual, 1)
+			skipped, _ := db.Collection("skipped_costs").CountDocuments(context.Background(), map[string]interface{}{})
+			So(skipped, ShouldEqual, 0)
+		})
+	})
+}
+
+func TestIntegrationMigrate(t *testing.T) {
+
+	Convey("Given that testcontainers has started a mongo container", t, func() {
+		container, uri, _ := testutils.SetupMongoContainer()
+		defer container.Terminate(context.Background())
+
+		db := getMongoDatabase(uri, "migrations")
+		keyring, err := encryption.NewKeyring("1", bytes.Repeat([]byte{7}, 32))
+		So(err, ShouldBeNil)
+		m := &MongoService{
+			db:                     db,
+			TransactionsCollection: "transactions",
+			ProductsCollection:     "products",
+			RefundsCollection:      "refunds",
+			SkippedCostsCollection: "skipped_costs",
+			MigrationsCollection:   "migrations",
+			Keyring:                keyring,
+		}
+		_, err = db.Collection("products").InsertOne(context.Background(), map[string]interface{}{"payment_reference": "Xold", "amount": "12.50"})
+		So(err, ShouldBeNil)
+		_, err = db.Collection("transactions").InsertOne(context.Background(), map[string]interface{}{"transaction_id": "Xold", "email": "Old@Example.com"})
+		So(err, ShouldBeNil)
+
+		Convey("Then every migration is applied once", func() {
+			ran, err := m.Migrate(context.Background())
+			So(err, ShouldBeNil)
+			So(ran, ShouldHaveLength, len(migrations))
+
+			ran, err = m.Migrate(context.Background())
+			So(err, ShouldBeNil)
+			So(ran, ShouldBeEmpty)
+
+			statuses, err := m.MigrationStatus(context.Background())
+			So(err, ShouldBeNil)
+			So(statuses, ShouldHaveLength, len(migrations))
+			for _, status := range statuses {
+				So(status.AppliedAt, ShouldNotBeNil)
+			}
+		})
+
+		Convey("Then the product code is taken out of the key of products indexed by an older release", func() {
+			products := db.Collection("products")
+			_, err := products.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+				Keys:    bson.D{{Key: "payment_reference", Value: 1}, {Key: "product_code", Value: 1}, {Key: "cost_line", Value: 1}},
+				Options: options.Index().SetUnique(true).SetName("natural_key"),
+			})
+			So(err, ShouldBeNil)
+
+			So(migrate(m), ShouldBeNil)
+			// Running the migration again, as another instance would, finds nothing left to drop
+			So(migrations[5].Up(context.Background(), m), ShouldBeNil)
+
+			specs, err := products.Indexes().ListSpecifications(context.Background())
+			So(err, ShouldBeNil)
+			var names []string
+			for _, spec := range specs {
+				names = append(names, spec.Name)
+			}
+			So(names, ShouldContain, productsNaturalKey)
+			So(names, ShouldNotContain, "natural_key")
+		})
+
+		Convey("Then the amount in pence is filled in for existing records", func() {
+			So(migrate(m), ShouldBeNil)
+
+			var product models.EshuResourceDao
+			So(db.Collection("products").FindOne(context.Background(), map[string]interface{}{"payment_reference": "Xold"}).Decode(&product), ShouldBeNil)
+			So(product.AmountPence, ShouldEqual, 1250)
+		})
+
+		Convey("Then email addresses written in plain text are encrypted and hashed", func() {
+			So(migrate(m), ShouldBeNil)
+
+			var transaction models.PaymentTransactionsResourceDao
+			So(db.Collection("transactions").FindOne(context.Background(), map[string]interface{}{"transaction_id": "Xold"}).Decode(&transaction), ShouldBeNil)
+			So(transaction.Email, ShouldBeEmpty)
+			So(transaction.EmailHash, ShouldEqual, keyring.Hash("old@example.com"))
+			email, err := keyring.Decrypt(transaction.EncryptedEmail)
+			So(err, ShouldBeNil)
+			So(email, ShouldEqual, "Old@Example.com")
+		})
+	})
+}
+
+func migrate(m *MongoService) error {
+	_, err := m.Migrate(context.Background())
+	return err
+}
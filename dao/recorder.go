@@ -0,0 +1,73 @@
+package dao
+
+import (
+	"context"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/payment-reconciliation-consumer/keys"
+	"github.com/companieshouse/payment-reconciliation-consumer/models"
+)
+
+// Recorder is a DAO for dry runs. It logs the records that would have been written and, if it has a shadow DAO,
+// writes them there instead of to the reconciliation collections. Only the identifiers, product codes and amounts of
+// the records are logged, as dry runs are run against production traffic and the records hold personal fields.
+type Recorder struct {
+	Shadow DAO
+}
+
+// CreateEshuResource records the eshu resource that would have been written
+func (r *Recorder) CreateEshuResource(eshuResource *models.EshuResourceDao) error {
+	log.Info("dry run: would create eshu resource", log.Data{keys.PaymentID: eshuResource.PaymentRef,
+		"product_code": eshuResource.ProductCode, "cost_line": eshuResource.CostLine, "amount_pence": eshuResource.AmountPence})
+	if r.Shadow == nil {
+		return nil
+	}
+	return r.Shadow.CreateEshuResource(eshuResource)
+}
+
+// CreatePaymentTransactionsResource records the payment transaction resource that would have been written
+func (r *Recorder) CreatePaymentTransactionsResource(paymentTransactionsResource *models.PaymentTransactionsResourceDao) error {
+	log.Info("dry run: would create payment transactions resource", log.Data{keys.PaymentID: paymentTransactionsResource.TransactionID,
+		"cost_line": paymentTransactionsResource.CostLine, "amount_pence": paymentTransactionsResource.AmountPence})
+	if r.Shadow == nil {
+		return nil
+	}
+	return r.Shadow.CreatePaymentTransactionsResource(paymentTransactionsResource)
+}
+
+// CreateRefundResource records the refund resource that would have been written
+func (r *Recorder) CreateRefundResource(refundResource *models.RefundResourceDao) error {
+	log.Info("dry run: would create refund resource", log.Data{keys.PaymentID: refundResource.PaymentID,
+		keys.RefundID: refundResource.RefundID, "product_code": refundResource.ProductCode, "amount_pence": refundResource.AmountPence})
+	if r.Shadow == nil {
+		return nil
+	}
+	return r.Shadow.CreateRefundResource(refundResource)
+}
+
+// CreatePaymentResources records the eshu, payment transaction and skipped cost resources that would have been written
+func (r *Recorder) CreatePaymentResources(eshuResources []models.EshuResourceDao, paymentTransactionsResources []models.PaymentTransactionsResourceDao, skippedCostResources []models.SkippedCostResourceDao) error {
+	logData := log.Data{"eshus": len(eshuResources), "transactions": len(paymentTransactionsResources),
+		"skipped_costs": len(skippedCostResources)}
+	if len(eshuResources) > 0 {
+		logData[keys.PaymentID] = eshuResources[0].PaymentRef
+	}
+	var productCodes []int
+	for _, eshu := range eshuResources {
+		productCodes = append(productCodes, eshu.ProductCode)
+	}
+	logData["product_codes"] = productCodes
+	log.Info("dry run: would create payment resources", logData)
+	if r.Shadow == nil {
+		return nil
+	}
+	return r.Shadow.CreatePaymentResources(eshuResources, paymentTransactionsResources, skippedCostResources)
+}
+
+// Ping checks the shadow DAO, if there is one
+func (r *Recorder) Ping(ctx context.Context) error {
+	if r.Shadow == nil {
+		return nil
+	}
+	return r.Shadow.Ping(ctx)
+}
@@ -0,0 +1,39 @@
+package dao
+
+import (
+	"context"
+	"testing"
+
+	"github.com/companieshouse/payment-reconciliation-consumer/models"
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitRecorder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	eshus := []models.EshuResourceDao{{PaymentRef: "Xpayment"}}
+	txns := []models.PaymentTransactionsResourceDao{{TransactionID: "Xpayment"}}
+	skippedCosts := []models.SkippedCostResourceDao{{PaymentRef: "Xpayment", CostLine: 1}}
+	refund := &models.RefundResourceDao{RefundID: "refund"}
+
+	Convey("Given a recorder without a shadow DAO then records are only logged", t, func() {
+		recorder := &Recorder{}
+
+		So(recorder.CreatePaymentResources(eshus, txns, skippedCosts), ShouldBeNil)
+		So(recorder.CreateRefundResource(refund), ShouldBeNil)
+		So(recorder.Ping(context.Background()), ShouldBeNil)
+	})
+
+	Convey("Given a recorder with a shadow DAO then records are written to the shadow", t, func() {
+		shadow := NewMockDAO(ctrl)
+		recorder := &Recorder{Shadow: shadow}
+
+		shadow.EXPECT().CreatePaymentResources(eshus, txns, skippedCosts).Return(ErrAlreadyExists)
+		shadow.EXPECT().CreateRefundResource(refund).Return(nil)
+
+		So(recorder.CreatePaymentResources(eshus, txns, skippedCosts), ShouldEqual, ErrAlreadyExists)
+		So(recorder.CreateRefundResource(refund), ShouldBeNil)
+	})
+}
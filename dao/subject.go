@@ -0,0 +1,119 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/companieshouse/payment-reconciliation-consumer/metrics"
+	"github.com/companieshouse/payment-reconciliation-consumer/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrInvalidSubject is returned when a data subject cannot be used to find records
+var ErrInvalidSubject = errors.New("invalid data subject")
+
+// Subject identifies the person whose reconciliation records are wanted, by email address. Every transaction and
+// refund written by the consumer has the user ID "system", so records cannot be found by the payer's user ID.
+type Subject struct {
+	Email string
+}
+
+// Validate checks that the subject identifies a single person
+func (s Subject) Validate() error {
+	if normaliseEmail(s.Email) == "" {
+		return errors.New("an email address is required")
+	}
+	return nil
+}
+
+// Exporter finds reconciliation records with their encrypted fields decrypted, for authorised exports
+type Exporter interface {
+	FindSubject(ctx context.Context, subject Subject) (*SubjectRecords, error)
+}
+
+// SubjectRecords are the reconciliation records held for a data subject. Products hold no personal fields, and are
+// those of the payments the subject's transactions and refunds are for.
+type SubjectRecords struct {
+	Transactions []models.PaymentTransactionsResourceDao `json:"transactions"`
+	Refunds      []models.RefundResourceDao              `json:"refunds"`
+	Products     []models.EshuResourceDao                `json:"products"`
+}
+
+// Empty reports whether no records are held for the subject
+func (r *SubjectRecords) Empty() bool {
+	return len(r.Transactions) == 0 && len(r.Refunds) == 0 && len(r.Products) == 0
+}
+
+// FindSubject returns every transaction and refund record for the subject, with email addresses decrypted, and the
+// product records of the same payments. Encrypted records are matched on the keyed hash of the email address, and
+// records written before encryption was introduced on the address itself.
+func (m *MongoService) FindSubject(ctx context.Context, subject Subject) (*SubjectRecords, error) {
+	defer metrics.ObserveDuration(metrics.MongoDuration, "find_subject", time.Now())
+
+	if err := subject.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSubject, err)
+	}
+
+	filter := m.emailFilter(subject.Email)
+	records := &SubjectRecords{}
+	if err := find(ctx, m.db.Collection(m.TransactionsCollection), filter, &records.Transactions); err != nil {
+		return nil, err
+	}
+	if err := find(ctx, m.db.Collection(m.RefundsCollection), filter, &records.Refunds); err != nil {
+		return nil, err
+	}
+
+	var paymentRefs []string
+	for i := range records.Transactions {
+		t := &records.Transactions[i]
+		if err := m.decryptEmail(&t.Email, t.EncryptedEmail); err != nil {
+			return nil, err
+		}
+		paymentRefs = append(paymentRefs, t.TransactionID)
+	}
+	for i := range records.Refunds {
+		r := &records.Refunds[i]
+		if err := m.decryptEmail(&r.Email, r.EncryptedEmail); err != nil {
+			return nil, err
+		}
+		paymentRefs = append(paymentRefs, r.OriginalReference)
+	}
+
+	if len(paymentRefs) > 0 {
+		productsFilter := bson.M{"payment_reference": bson.M{"$in": paymentRefs}}
+		if err := find(ctx, m.db.Collection(m.ProductsCollection), productsFilter, &records.Products); err != nil {
+			return nil, err
+		}
+	}
+
+	return records, nil
+}
+
+// emailFilter matches records holding the email address, whether encrypted or in plain text. Addresses are matched
+// ignoring case and surrounding spaces, as the keyed hash of an encrypted address is.
+func (m *MongoService) emailFilter(email string) bson.M {
+	email = normaliseEmail(email)
+	plaintext := bson.M{"email": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(email) + "$", Options: "i"}}
+	if m.Keyring == nil {
+		return plaintext
+	}
+	return bson.M{"$or": []bson.M{{"email_hash": m.Keyring.Hash(email)}, plaintext}}
+}
+
+func normaliseEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func find(ctx context.Context, collection *mongo.Collection, filter bson.M, results interface{}) error {
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, results)
+}
@@ -0,0 +1,47 @@
+package dao
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/companieshouse/payment-reconciliation-consumer/encryption"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUnitSubject(t *testing.T) {
+
+	keyring, err := encryption.NewKeyring("test", bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &MongoService{Keyring: keyring}
+
+	Convey("A subject needs an email address", t, func() {
+		_, err := m.FindSubject(context.Background(), Subject{})
+
+		So(errors.Is(err, ErrInvalidSubject), ShouldBeTrue)
+	})
+
+	Convey("A subject needs an email address that is not just spaces", t, func() {
+		_, err := m.FindSubject(context.Background(), Subject{Email: "  "})
+
+		So(errors.Is(err, ErrInvalidSubject), ShouldBeTrue)
+	})
+
+	Convey("Records are matched on the hash of an email address or on the address itself, ignoring case", t, func() {
+		So(m.emailFilter(" Test.User+1@CH.gov.uk "), ShouldResemble, bson.M{"$or": []bson.M{
+			{"email_hash": keyring.Hash("test.user+1@ch.gov.uk")},
+			{"email": primitive.Regex{Pattern: `^test\.user\+1@ch\.gov\.uk$`, Options: "i"}},
+		}})
+	})
+
+	Convey("An erasure needs the person requesting it and a reference", t, func() {
+		_, err := m.EraseSubject(context.Background(), Subject{Email: "test@ch.gov.uk"}, "", "")
+
+		So(err, ShouldNotBeNil)
+	})
+}
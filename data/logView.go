@@ -0,0 +1,129 @@
+package data
+
+import (
+	"time"
+)
+
+// The types below are the views of the payments api responses that are written to the logs. Names and email addresses
+// are never logged. Other fields which identify a person or a secure application are only logged as keyed hashes, so
+// that log entries can still be matched to each other and to stored records without the values being recoverable by
+// anyone without the key. A view is either a summary, which holds enough to follow a payment through the logs, or
+// verbose, which adds every field that is safe to log.
+
+// Hasher returns a keyed hash of a value, such as the Hash of an encryption.Keyring
+type Hasher interface {
+	Hash(value string) string
+}
+
+// PaymentLogView is the view of a PaymentResponse written to the logs
+type PaymentLogView struct {
+	Reference     string          `json:"reference,omitempty"`
+	Status        string          `json:"status"`
+	Amount        string          `json:"amount"`
+	Kind          string          `json:"kind,omitempty"`
+	CompletedAt   *time.Time      `json:"completed_at,omitempty"`
+	PaymentMethod string          `json:"payment_method,omitempty"`
+	CreatedByHash string          `json:"created_by_hash,omitempty"`
+	EmailHash     string          `json:"email_hash,omitempty"`
+	CompanyHash   string          `json:"company_number_hash,omitempty"`
+	Costs         []CostLogView   `json:"costs,omitempty"`
+	Refunds       []RefundLogView `json:"refunds,omitempty"`
+}
+
+// CostLogView is the view of a Cost written to the logs. Description values are never logged, as they may hold
+// details entered by the customer.
+type CostLogView struct {
+	Amount         string   `json:"amount"`
+	ClassOfPayment []string `json:"class_of_payment"`
+	ProductType    string   `json:"product_type"`
+	Description    string   `json:"description,omitempty"`
+}
+
+// PaymentDetailsLogView is the view of a PaymentDetailsResponse written to the logs
+type PaymentDetailsLogView struct {
+	PaymentStatus     string `json:"payment_status"`
+	TransactionDate   string `json:"transaction_date"`
+	CardType          string `json:"card_type,omitempty"`
+	ExternalPaymentID string `json:"external_payment_id,omitempty"`
+}
+
+// RefundLogView is the view of a RefundResource written to the logs
+type RefundLogView struct {
+	RefundId          string     `json:"refund_id"`
+	Status            string     `json:"status"`
+	Amount            int        `json:"amount"`
+	CreatedAt         string     `json:"created_at,omitempty"`
+	RefundedAt        *time.Time `json:"refunded_at,omitempty"`
+	ExternalRefundUrl string     `json:"external_refund_url,omitempty"`
+}
+
+// LogView returns the view of the payment written to the logs, with its costs and refunds when verbose. Personal fields
+// are only added by VerboseLogView.
+func (payment PaymentResponse) LogView(verbose bool) PaymentLogView {
+	view := PaymentLogView{
+		Reference: payment.Reference,
+		Status:    payment.Status,
+		Amount:    payment.Amount,
+	}
+	if !verbose {
+		return view
+	}
+
+	view.Kind = payment.Kind
+	view.CompletedAt = &payment.CompletedAt
+	view.PaymentMethod = payment.PaymentMethod
+	for _, cost := range payment.Costs {
+		view.Costs = append(view.Costs, CostLogView{
+			Amount:         cost.Amount,
+			ClassOfPayment: cost.ClassOfPayment,
+			ProductType:    cost.ProductType,
+			Description:    cost.Description,
+		})
+	}
+	for _, refund := range payment.Refunds {
+		view.Refunds = append(view.Refunds, refund.LogView(verbose))
+	}
+	return view
+}
+
+// VerboseLogView returns the verbose view of the payment with the payer's ID and email address and the company number
+// hashed with hasher. They are left out when hasher is nil.
+func (payment PaymentResponse) VerboseLogView(hasher Hasher) PaymentLogView {
+	view := payment.LogView(true)
+	if hasher == nil {
+		return view
+	}
+	view.CreatedByHash = hasher.Hash(payment.CreatedBy.ID)
+	view.EmailHash = hasher.Hash(payment.CreatedBy.Email)
+	view.CompanyHash = hasher.Hash(payment.CompanyNumber)
+	return view
+}
+
+// LogView returns the view of the payment details written to the logs, with the card type and external payment ID
+// when verbose
+func (details PaymentDetailsResponse) LogView(verbose bool) PaymentDetailsLogView {
+	view := PaymentDetailsLogView{
+		PaymentStatus:   details.PaymentStatus,
+		TransactionDate: details.TransactionDate,
+	}
+	if verbose {
+		view.CardType = details.CardType
+		view.ExternalPaymentID = details.ExternalPaymentID
+	}
+	return view
+}
+
+// LogView returns the view of the refund written to the logs, with its dates and external URL when verbose
+func (refund RefundResource) LogView(verbose bool) RefundLogView {
+	view := RefundLogView{
+		RefundId: refund.RefundId,
+		Status:   refund.Status,
+		Amount:   refund.Amount,
+	}
+	if verbose {
+		view.CreatedAt = refund.CreatedAt
+		view.RefundedAt = &refund.RefundedAt
+		view.ExternalRefundUrl = refund.ExternalRefundUrl
+	}
+	return view
+}
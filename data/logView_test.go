@@ -0,0 +1,90 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testHasher stands in for a keyed hasher, such as an encryption.Keyring
+type testHasher struct{}
+
+func (testHasher) Hash(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "hashed"
+}
+
+func TestUnitLogView(t *testing.T) {
+
+	payment := PaymentResponse{
+		Amount:        "10.00",
+		Reference:     "ref",
+		Status:        "paid",
+		CompanyNumber: "00006400",
+		CompletedAt:   time.Date(2020, 7, 27, 9, 7, 12, 0, time.UTC),
+		CreatedBy:     Created{Email: "test@ch.gov.uk", Forename: "Jane", Surname: "Doe", ID: "user"},
+		Costs: []Cost{{
+			Amount:            "10.00",
+			ClassOfPayment:    []string{DataMaintenance},
+			ProductType:       "pro-app-1",
+			DescriptionValues: map[string]string{"applicant": "Jane Doe"},
+		}},
+		Refunds: []RefundResource{{RefundId: "refund", Status: "success", Amount: 1000, ExternalRefundUrl: "url"}},
+	}
+
+	Convey("A summary of a payment holds no personal fields", t, func() {
+		b, err := json.Marshal(payment.LogView(false))
+
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, `{"reference":"ref","status":"paid","amount":"10.00"}`)
+	})
+
+	Convey("A verbose view of a payment holds no personal fields", t, func() {
+		view := payment.LogView(true)
+
+		So(view.CreatedByHash, ShouldEqual, "")
+		So(view.EmailHash, ShouldEqual, "")
+		So(view.CompanyHash, ShouldEqual, "")
+		So(view.Costs, ShouldHaveLength, 1)
+	})
+
+	Convey("A verbose view of a payment hashes personal fields with the hasher and omits names and description values", t, func() {
+		view := payment.VerboseLogView(testHasher{})
+		b, err := json.Marshal(view)
+
+		So(err, ShouldBeNil)
+		So(view.CreatedByHash, ShouldEqual, "hashed")
+		So(view.EmailHash, ShouldEqual, "hashed")
+		So(view.CompanyHash, ShouldEqual, "hashed")
+		So(view.Costs, ShouldResemble, []CostLogView{{Amount: "10.00", ClassOfPayment: []string{DataMaintenance}, ProductType: "pro-app-1"}})
+		So(view.Refunds[0].ExternalRefundUrl, ShouldEqual, "url")
+		for _, personal := range []string{"test@ch.gov.uk", "Jane", "Doe", "00006400", `"user"`} {
+			So(string(b), ShouldNotContainSubstring, personal)
+		}
+	})
+
+	Convey("Personal fields are left out of a verbose view without a hasher", t, func() {
+		So(payment.VerboseLogView(nil), ShouldResemble, payment.LogView(true))
+	})
+
+	Convey("Empty personal fields are not hashed", t, func() {
+		view := PaymentResponse{}.VerboseLogView(testHasher{})
+
+		So(view.EmailHash, ShouldEqual, "")
+		So(view.CompanyHash, ShouldEqual, "")
+	})
+
+	Convey("Payment details and refunds have summary and verbose views", t, func() {
+		details := PaymentDetailsResponse{CardType: "visa", ExternalPaymentID: "external", TransactionDate: "2020-07-27", PaymentStatus: "accepted"}
+		refund := payment.Refunds[0]
+
+		So(details.LogView(false), ShouldResemble, PaymentDetailsLogView{PaymentStatus: "accepted", TransactionDate: "2020-07-27"})
+		So(details.LogView(true).ExternalPaymentID, ShouldEqual, "external")
+		So(refund.LogView(false), ShouldResemble, RefundLogView{RefundId: "refund", Status: "success", Amount: 1000})
+		So(refund.LogView(true).ExternalRefundUrl, ShouldEqual, "url")
+	})
+}
@@ -1,8 +1,16 @@
 package data
 
+import _ "embed"
+
+// PaymentProcessedSchema is the payment-processed avro schema the consumer is built against, for use when the schema
+// registry is unavailable
+//
+//go:embed payment-processed.avsc
+var PaymentProcessedSchema string
+
 // PaymentProcessed represents payment change avro schema
 type PaymentProcessed struct {
-	Attempt     int32  `avro:"attempt"`
-	ResourceURI string `avro:"payment_resource_id"`
-	RefundId    string `avro:"refund_id,omitempty"`
+	Attempt     int32  `avro:"attempt" json:"attempt"`
+	ResourceURI string `avro:"payment_resource_id" json:"payment_resource_id"`
+	RefundId    string `avro:"refund_id,omitempty" json:"refund_id,omitempty"`
 }
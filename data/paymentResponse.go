@@ -66,27 +66,67 @@ type RefundResource struct {
 	ExternalRefundUrl string    `json:"external_refund_url"`
 }
 
-// Indicates whether the payment is reconcilable or not.
-func (payment PaymentResponse) IsReconcilable(productMap *config.ProductMap) bool {
-
-	classOfPayment := payment.Costs[0].ClassOfPayment[0]
+// Reasons a cost is not reconcilable in CHS
+const (
+	ReasonNoClassOfPayment    = "cost has no class of payment"
+	ReasonReconciledElsewhere = "class of payment is reconciled elsewhere"
+	ReasonNoProductCode       = "no product code mapped for product type"
+)
 
-	// only reconcile these payment classes, others like penalty and legacy reconcile elsewhere
-	if classOfPayment == DataMaintenance || classOfPayment == OrderableItem {
+// HasReconcilableClass reports whether any cost of the payment has a class of payment reconciled in CHS. A payment
+// without one is never reconcilable, so it can be skipped before its details are fetched.
+func (payment PaymentResponse) HasReconcilableClass() bool {
+	for _, cost := range payment.Costs {
+		if cost.classReason() == "" {
+			return true
+		}
+	}
 
-		productType := payment.Costs[0].ProductType
-		productCode := productMap.Codes[productType]
+	log.Info("Not reconcilable as no costs have a reconcilable class of payment", log.Data{"reference": payment.Reference})
+	return false
+}
 
-		// if product code is zero there is no mapping for the product type so the payment is not reconcilable in CHS
-		if productCode == 0 {
-			log.Info("Not reconcilable due to product code", log.Data{"reference": payment.Reference, "class_of_payment": classOfPayment, "product_type": productType, "product_code": productCode})
-			return false
+// Indicates whether the payment is reconcilable or not at its transaction date. A payment is reconcilable when any of
+// its costs are, the remaining costs being skipped individually.
+func (payment PaymentResponse) IsReconcilable(productMap *config.ProductMap, at time.Time) bool {
+	for _, cost := range payment.Costs {
+		if reconcilable, _ := cost.IsReconcilable(productMap, at); reconcilable {
+			log.Info("Reconcilable payment", log.Data{"reference": payment.Reference})
+			return true
 		}
-
-		log.Info("Reconcilable payment", log.Data{"reference": payment.Reference, "class_of_payment": classOfPayment, "product_type": productType, "product_code": productCode})
-		return true
 	}
 
-	log.Info("Not reconcilable due to class of payment", log.Data{"reference": payment.Reference, "class_of_payment": classOfPayment})
+	log.Info("Not reconcilable as no costs are reconcilable", log.Data{"reference": payment.Reference})
 	return false
 }
+
+// IsReconcilable indicates whether the cost is reconcilable at the transaction date at, and if not the reason why. A
+// cost is only reconcilable if its product type has a product code effective at that date.
+func (cost Cost) IsReconcilable(productMap *config.ProductMap, at time.Time) (bool, string) {
+	if reason := cost.classReason(); reason != "" {
+		log.Info("Cost not reconcilable due to class of payment", log.Data{"class_of_payment": cost.ClassOfPayment, "product_type": cost.ProductType})
+		return false, reason
+	}
+
+	// if there is no mapping for the product type at the date the cost is not reconcilable in CHS
+	if productMap.Code(cost.ProductType, at) == 0 {
+		log.Info("Cost not reconcilable due to product code", log.Data{"class_of_payment": cost.ClassOfPayment[0], "product_type": cost.ProductType})
+		return false, ReasonNoProductCode
+	}
+
+	return true, ""
+}
+
+// classReason returns the reason the class of payment of the cost is not reconciled in CHS, or "" if it is
+func (cost Cost) classReason() string {
+	if len(cost.ClassOfPayment) == 0 {
+		return ReasonNoClassOfPayment
+	}
+
+	// only reconcile these payment classes, others like penalty and legacy reconcile elsewhere
+	classOfPayment := cost.ClassOfPayment[0]
+	if classOfPayment != DataMaintenance && classOfPayment != OrderableItem {
+		return ReasonReconciledElsewhere
+	}
+	return ""
+}
@@ -10,8 +10,12 @@ import (
 	"io/ioutil"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
+// paidAt is the transaction date of the payments tested, when every product type in the product map has a code
+var paidAt = time.Date(2020, 7, 27, 9, 7, 12, 0, time.UTC)
+
 func TestUnitIsReconcilable(t *testing.T) {
 
 	productMap, err := createProductMap()
@@ -22,36 +26,104 @@ func TestUnitIsReconcilable(t *testing.T) {
 
 	Convey("data-maintenance payments are reconcilable", t, func() {
 		dataMaintenance := createPaymentResponse(DataMaintenance, "ds01")
-		Equal(t, dataMaintenance.IsReconcilable(productMap), true, "data-maintenance payments should be reconcilable")
+		Equal(t, dataMaintenance.IsReconcilable(productMap, paidAt), true, "data-maintenance payments should be reconcilable")
 	})
 
 	Convey("orderable-item payments are reconcilable", t, func() {
 		orderableItem := createPaymentResponse(OrderableItem, "certificate")
-		Equal(t, orderableItem.IsReconcilable(productMap), true, "orderable-item payments should be reconcilable")
+		Equal(t, orderableItem.IsReconcilable(productMap, paidAt), true, "orderable-item payments should be reconcilable")
 	})
 
 	Convey("penalty payments are not reconcilable", t, func() {
 		penalty := createPaymentResponse(Penalty, "lfp")
-		Equal(t, penalty.IsReconcilable(productMap), false, "penalty payments should not be reconcilable")
+		Equal(t, penalty.IsReconcilable(productMap, paidAt), false, "penalty payments should not be reconcilable")
 	})
 
 	Convey("legacy payments are not reconcilable", t, func() {
 		penalty := createPaymentResponse(Legacy, "webfiling")
-		Equal(t, penalty.IsReconcilable(productMap), false, "legacy payments should not be reconcilable")
+		Equal(t, penalty.IsReconcilable(productMap, paidAt), false, "legacy payments should not be reconcilable")
 	})
 
 	Convey("extractives payments are not reconcilable", t, func() {
 		penalty := createPaymentResponse(DataMaintenance, "extractives")
-		Equal(t, penalty.IsReconcilable(productMap), false, "extractives payments should not be reconcilable")
+		Equal(t, penalty.IsReconcilable(productMap, paidAt), false, "extractives payments should not be reconcilable")
 	})
 
 	Convey("empty product type payments are not reconcilable", t, func() {
 		penalty := createPaymentResponse(DataMaintenance, "")
-		Equal(t, penalty.IsReconcilable(productMap), false, "empty product type payment should not be reconcilable")
+		Equal(t, penalty.IsReconcilable(productMap, paidAt), false, "empty product type payment should not be reconcilable")
+	})
+
+	Convey("payments mixing reconcilable and unreconcilable costs are reconcilable", t, func() {
+		mixed := createPaymentResponse(Penalty, "lfp")
+		mixed.Costs = append(mixed.Costs, createPaymentResponse(OrderableItem, "certificate").Costs...)
+		Equal(t, mixed.IsReconcilable(productMap, paidAt), true, "a payment with any reconcilable cost should be reconcilable")
+	})
+
+	Convey("payments without a class of payment are not reconcilable", t, func() {
+		missing := PaymentResponse{Costs: []Cost{{ProductType: "certificate"}}}
+		Equal(t, missing.IsReconcilable(productMap, paidAt), false, "a payment without a class of payment should not be reconcilable")
 	})
 
 }
 
+func TestUnitCostIsReconcilable(t *testing.T) {
+
+	productMap, err := createProductMap()
+
+	if err != nil {
+		log.Error(fmt.Errorf("error initialising productMap: %s", err), nil)
+	}
+
+	Convey("reconcilable costs have no reason", t, func() {
+		reconcilable, reason := createPaymentResponse(OrderableItem, "certificate").Costs[0].IsReconcilable(productMap, paidAt)
+		Equal(t, true, reconcilable)
+		Equal(t, "", reason)
+	})
+
+	Convey("costs reconciled elsewhere give their reason", t, func() {
+		reconcilable, reason := createPaymentResponse(Penalty, "lfp").Costs[0].IsReconcilable(productMap, paidAt)
+		Equal(t, false, reconcilable)
+		Equal(t, ReasonReconciledElsewhere, reason)
+	})
+
+	Convey("costs without a product code give their reason", t, func() {
+		reconcilable, reason := createPaymentResponse(DataMaintenance, "extractives").Costs[0].IsReconcilable(productMap, paidAt)
+		Equal(t, false, reconcilable)
+		Equal(t, ReasonNoProductCode, reason)
+	})
+
+	Convey("costs without a class of payment give their reason", t, func() {
+		reconcilable, reason := Cost{ProductType: "certificate"}.IsReconcilable(productMap, paidAt)
+		Equal(t, false, reconcilable)
+		Equal(t, ReasonNoClassOfPayment, reason)
+	})
+
+	Convey("costs whose product type only has a code at other dates give their reason", t, func() {
+		changeDate := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+		dated := &config.ProductMap{Codes: map[string]config.ProductCodes{
+			"new-product": {{Code: 27102, EffectiveFrom: changeDate}},
+		}}
+		cost := createPaymentResponse(OrderableItem, "new-product").Costs[0]
+
+		reconcilable, reason := cost.IsReconcilable(dated, changeDate.Add(-time.Hour))
+		Equal(t, false, reconcilable)
+		Equal(t, ReasonNoProductCode, reason)
+
+		reconcilable, _ = cost.IsReconcilable(dated, changeDate)
+		Equal(t, true, reconcilable)
+	})
+}
+
+func TestUnitHasReconcilableClass(t *testing.T) {
+
+	Convey("payments with a cost of a reconcilable class have a reconcilable class, whatever its product type", t, func() {
+		Equal(t, true, createPaymentResponse(OrderableItem, "unmapped-product").HasReconcilableClass())
+		Equal(t, false, createPaymentResponse(Penalty, "lfp").HasReconcilableClass())
+		Equal(t, false, PaymentResponse{Costs: []Cost{{ProductType: "certificate"}}}.HasReconcilableClass())
+	})
+}
+
 // Creates a payment response with the class of payment specified.
 func createPaymentResponse(classOfPayment string, productType string) PaymentResponse {
 	cost := Cost{
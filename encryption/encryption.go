@@ -0,0 +1,180 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrInvalidKey is returned when the configured key cannot be used
+var ErrInvalidKey = errors.New("invalid field encryption key")
+
+// ErrUnknownKey is returned when a value was encrypted with a key other than the one held by the Keyring
+var ErrUnknownKey = errors.New("value was encrypted with an unknown key")
+
+// ErrDecrypt is returned when a value cannot be decrypted, because it has been altered or the key is wrong
+var ErrDecrypt = errors.New("unable to decrypt value")
+
+// keySize is the size in bytes of the configured key and of the data keys, which are AES-256 keys
+const keySize = 32
+
+// EncryptedValue is a value encrypted with its own data key, which is stored alongside it encrypted with the key
+// identified by KeyID. The nonce used for each encryption is prepended to the ciphertext.
+type EncryptedValue struct {
+	KeyID        string `bson:"key_id" json:"key_id"`
+	EncryptedKey []byte `bson:"encrypted_key" json:"encrypted_key"`
+	Ciphertext   []byte `bson:"ciphertext" json:"ciphertext"`
+}
+
+// Keyring encrypts values using envelope encryption and hashes them so that they can be looked up without being
+// decrypted. The key encryption key and the hash key are both derived from the configured key.
+type Keyring struct {
+	keyID   string
+	kek     cipher.AEAD
+	hashKey []byte
+}
+
+// NewKeyring creates a Keyring from a 32 byte key, which is recorded against every value it encrypts as keyID
+func NewKeyring(keyID string, key []byte) (*Keyring, error) {
+	if keyID == "" {
+		return nil, fmt.Errorf("%w: no key id", ErrInvalidKey)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("%w: key is %d bytes rather than %d", ErrInvalidKey, len(key), keySize)
+	}
+
+	kek, err := newAEAD(derive(key, "key-encryption"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Keyring{keyID: keyID, kek: kek, hashKey: derive(key, "lookup-hash")}, nil
+}
+
+// Load creates a Keyring from a base64 encoded key, which is read from keyFile when encodedKey is empty
+func Load(keyID, encodedKey, keyFile string) (*Keyring, error) {
+	if encodedKey == "" {
+		if keyFile == "" {
+			return nil, fmt.Errorf("%w: no key or key file configured", ErrInvalidKey)
+		}
+		b, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		encodedKey = string(b)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+	if err != nil {
+		return nil, fmt.Errorf("%w: key is not base64 encoded", ErrInvalidKey)
+	}
+	return NewKeyring(keyID, key)
+}
+
+// Encrypt encrypts value with a new data key. An empty value is not encrypted, and nil is returned.
+func (k *Keyring) Encrypt(value string) (*EncryptedValue, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	dataKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := seal(aead, []byte(value), nil)
+	if err != nil {
+		return nil, err
+	}
+	// The key id is authenticated with the data key, so that it cannot be swapped for another
+	encryptedKey, err := seal(k.kek, dataKey, []byte(k.keyID))
+	if err != nil {
+		return nil, err
+	}
+
+	return &EncryptedValue{KeyID: k.keyID, EncryptedKey: encryptedKey, Ciphertext: ciphertext}, nil
+}
+
+// Decrypt returns the value held in encrypted, or an empty string if encrypted is nil
+func (k *Keyring) Decrypt(encrypted *EncryptedValue) (string, error) {
+	if encrypted == nil {
+		return "", nil
+	}
+	if encrypted.KeyID != k.keyID {
+		return "", fmt.Errorf("%w: [%s]", ErrUnknownKey, encrypted.KeyID)
+	}
+
+	dataKey, err := open(k.kek, encrypted.EncryptedKey, []byte(encrypted.KeyID))
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	value, err := open(aead, encrypted.Ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// Hash returns a keyed hash of value, which is the same for every value differing only in case or surrounding spaces
+// so that email addresses can be looked up however they were entered. An empty value hashes to an empty string.
+func (k *Keyring) Hash(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, k.hashKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// derive returns a key for a single purpose from key, so that the same key is never used for two purposes
+func derive(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,150 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var testKey = bytes.Repeat([]byte{1}, keySize)
+
+func TestUnitLoad(t *testing.T) {
+
+	encodedKey := base64.StdEncoding.EncodeToString(testKey)
+
+	Convey("A keyring is created from a base64 encoded key", t, func() {
+		keyring, err := Load("test", encodedKey, "")
+
+		So(err, ShouldBeNil)
+		So(keyring.keyID, ShouldEqual, "test")
+	})
+
+	Convey("A keyring is created from a key file when no key is given", t, func() {
+		path := filepath.Join(t.TempDir(), "field_encryption.key")
+		So(os.WriteFile(path, []byte(encodedKey+"\n"), 0o600), ShouldBeNil)
+
+		keyring, err := Load("test", "", path)
+
+		So(err, ShouldBeNil)
+		So(keyring.Hash("test@ch.gov.uk"), ShouldEqual, mustKeyring().Hash("test@ch.gov.uk"))
+	})
+
+	Convey("A missing key is rejected", t, func() {
+		_, err := Load("test", "", "")
+
+		So(errors.Is(err, ErrInvalidKey), ShouldBeTrue)
+	})
+
+	Convey("A key which is not base64 encoded is rejected", t, func() {
+		_, err := Load("test", "not a key!", "")
+
+		So(errors.Is(err, ErrInvalidKey), ShouldBeTrue)
+	})
+
+	Convey("A key of the wrong length is rejected", t, func() {
+		_, err := Load("test", base64.StdEncoding.EncodeToString([]byte("short")), "")
+
+		So(errors.Is(err, ErrInvalidKey), ShouldBeTrue)
+	})
+
+	Convey("A key without an id is rejected", t, func() {
+		_, err := Load("", encodedKey, "")
+
+		So(errors.Is(err, ErrInvalidKey), ShouldBeTrue)
+	})
+}
+
+func TestUnitEncrypt(t *testing.T) {
+
+	keyring := mustKeyring()
+
+	Convey("An encrypted value is decrypted", t, func() {
+		encrypted, err := keyring.Encrypt("test@ch.gov.uk")
+		So(err, ShouldBeNil)
+
+		So(encrypted.KeyID, ShouldEqual, "test")
+		So(bytes.Contains(encrypted.Ciphertext, []byte("test@ch.gov.uk")), ShouldBeFalse)
+
+		value, err := keyring.Decrypt(encrypted)
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "test@ch.gov.uk")
+	})
+
+	Convey("Each value is encrypted with a different data key", t, func() {
+		first, _ := keyring.Encrypt("test@ch.gov.uk")
+		second, _ := keyring.Encrypt("test@ch.gov.uk")
+
+		So(first.EncryptedKey, ShouldNotResemble, second.EncryptedKey)
+		So(first.Ciphertext, ShouldNotResemble, second.Ciphertext)
+	})
+
+	Convey("Empty values are not encrypted", t, func() {
+		encrypted, err := keyring.Encrypt("")
+		So(err, ShouldBeNil)
+		So(encrypted, ShouldBeNil)
+
+		value, err := keyring.Decrypt(nil)
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "")
+	})
+
+	Convey("Values encrypted with another key are not decrypted", t, func() {
+		other, _ := NewKeyring("test", bytes.Repeat([]byte{2}, keySize))
+		encrypted, _ := other.Encrypt("test@ch.gov.uk")
+
+		_, err := keyring.Decrypt(encrypted)
+
+		So(errors.Is(err, ErrDecrypt), ShouldBeTrue)
+	})
+
+	Convey("Values encrypted under another key id are not decrypted", t, func() {
+		encrypted, _ := keyring.Encrypt("test@ch.gov.uk")
+		encrypted.KeyID = "other"
+
+		_, err := keyring.Decrypt(encrypted)
+
+		So(errors.Is(err, ErrUnknownKey), ShouldBeTrue)
+	})
+
+	Convey("Altered values are not decrypted", t, func() {
+		encrypted, _ := keyring.Encrypt("test@ch.gov.uk")
+		encrypted.Ciphertext[len(encrypted.Ciphertext)-1] ^= 1
+
+		_, err := keyring.Decrypt(encrypted)
+
+		So(errors.Is(err, ErrDecrypt), ShouldBeTrue)
+	})
+}
+
+func TestUnitHash(t *testing.T) {
+
+	keyring := mustKeyring()
+
+	Convey("Hashes ignore case and surrounding spaces", t, func() {
+		So(keyring.Hash(" Test@CH.gov.uk "), ShouldEqual, keyring.Hash("test@ch.gov.uk"))
+		So(keyring.Hash("test@ch.gov.uk"), ShouldHaveLength, 64)
+	})
+
+	Convey("Hashes depend on the key", t, func() {
+		other, _ := NewKeyring("test", bytes.Repeat([]byte{2}, keySize))
+
+		So(other.Hash("test@ch.gov.uk"), ShouldNotEqual, keyring.Hash("test@ch.gov.uk"))
+	})
+
+	Convey("Empty values hash to an empty string", t, func() {
+		So(keyring.Hash(" "), ShouldEqual, "")
+	})
+}
+
+func mustKeyring() *Keyring {
+	keyring, err := NewKeyring("test", testKey)
+	if err != nil {
+		panic(err)
+	}
+	return keyring
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/payment-reconciliation-consumer/config"
+	"github.com/companieshouse/payment-reconciliation-consumer/dao"
+	"github.com/companieshouse/payment-reconciliation-consumer/models"
+)
+
+// erasureReport is written to standard output, listing the records found for the subject and, unless it was a dry
+// run, the audit of their erasure
+type erasureReport struct {
+	Records *dao.SubjectRecords     `json:"records"`
+	Erasure *models.ErasureAuditDao `json:"erasure,omitempty"`
+}
+
+// runErase pseudonymises the personal fields of every reconciliation record held for a data subject, identified by
+// email address, for a right to erasure request
+func runErase(args []string) error {
+	flags := flag.NewFlagSet("erase", flag.ContinueOnError)
+	email := flags.String("email", "", "Email address of the data subject")
+	userID := flags.String("user-id", "", "Not supported, as records do not hold the payer's user ID")
+	requestedBy := flags.String("requested-by", "", "Person requesting the erasure, recorded in the audit")
+	reference := flags.String("reference", "", "Reference of the erasure request, recorded in the audit")
+	dryRun := flags.Bool("dry-run", false, "Report the records that would be erased without changing them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *userID != "" {
+		return errors.New("-user-id is not supported, as every record is written with the user ID \"system\" rather " +
+			"than the payer's - find the subject by -email instead")
+	}
+	if !*dryRun && (*requestedBy == "" || *reference == "") {
+		return errors.New("-requested-by and -reference are required unless it is a -dry-run")
+	}
+
+	cfg, err := config.Get()
+	if err != nil {
+		return fmt.Errorf("error configuring service: %s", err)
+	}
+
+	eraser, err := dao.NewEraser(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	subject := dao.Subject{Email: *email}
+
+	records, err := eraser.FindSubject(ctx, subject)
+	if err != nil {
+		return fmt.Errorf("error finding records: %s", err)
+	}
+	report := erasureReport{Records: records}
+
+	if records.Empty() {
+		log.Info("no records held for data subject, nothing to erase", nil)
+	} else if *dryRun {
+		log.Info("dry run: records would be erased", log.Data{"transactions": len(records.Transactions),
+			"refunds": len(records.Refunds), "products": len(records.Products)})
+	} else {
+		audit, err := eraser.EraseSubject(ctx, subject, *requestedBy, *reference)
+		if err != nil {
+			return fmt.Errorf("error erasing records: %s", err)
+		}
+		report.Erasure = audit
+		// The audit holds no personal fields, so unlike the records it can be logged
+		log.Info("erased data subject", log.Data{"erasure": audit})
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/payment-reconciliation-consumer/config"
+	"github.com/companieshouse/payment-reconciliation-consumer/dao"
+)
+
+// runExport writes every reconciliation record held for an email address, with the address decrypted, as JSON. Only those holding the field encryption key can decrypt the records, so the export is as restricted as the key.
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	email := flags.String("email", "", "Email address to export the records of")
+	output := flags.String("output", "-", "File to write the records to, or - for standard output")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" {
+		return errors.New("an -email address is required")
+	}
+
+	cfg, err := config.Get()
+	if err != nil {
+		return fmt.Errorf("error configuring service: %s", err)
+	}
+
+	exporter, err := dao.NewExporter(cfg)
+	if err != nil {
+		return err
+	}
+
+	records, err := exporter.FindSubject(context.Background(), dao.Subject{Email: *email})
+	if err != nil {
+		return fmt.Errorf("error finding records: %s", err)
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(records); err != nil {
+		return fmt.Errorf("error writing records: %s", err)
+	}
+
+	// The address itself is not logged, as the logs are not restricted
+	log.Info("export complete", log.Data{"output": *output, "transactions": len(records.Transactions), "refunds": len(records.Refunds),
+		"products": len(records.Products)})
+	return nil
+}
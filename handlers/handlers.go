@@ -2,13 +2,24 @@ package handlers
 
 import (
 	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/payment-reconciliation-consumer/service"
 	"github.com/gorilla/pat"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func Init(r *pat.Router) {
-	log.Info("initialising healthcheck endpoint beneath basePath: /payment-reconciliation-consumer")
+// Init registers the healthcheck, readiness and metrics endpoints. The readiness endpoint runs the given checks. The
+// admin endpoints are only registered when a reconciler is given, and only serve requests authenticated with adminKey.
+func Init(r *pat.Router, checks map[string]ReadinessCheck, reconciler service.Reconciler, adminKey string) {
+	log.Info("initialising healthcheck, readiness and metrics endpoints beneath basePath: /payment-reconciliation-consumer")
 
 	appRouter := r.PathPrefix("/payment-reconciliation-consumer").Subrouter()
 
 	appRouter.Path("/healthcheck").Methods("GET").HandlerFunc(HealthCheck)
+	appRouter.Path("/readiness").Methods("GET").HandlerFunc(Readiness(checks))
+	appRouter.Path("/metrics").Methods("GET").Handler(promhttp.Handler())
+
+	if reconciler != nil {
+		log.Info("initialising admin endpoints beneath basePath: /payment-reconciliation-consumer/admin")
+		appRouter.Path("/admin/reconcile/{paymentId}").Methods("POST").HandlerFunc(requireAdmin(adminKey, Reconcile(reconciler)))
+	}
 }
@@ -3,6 +3,7 @@ package handlers
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gorilla/pat"
@@ -10,7 +11,7 @@ import (
 
 func TestUnitInit(t *testing.T) {
 	r := pat.New()
-	Init(r)
+	Init(r, nil, nil, "")
 
 	req := httptest.NewRequest("GET", "/payment-reconciliation-consumer/healthcheck", nil)
 	rr := httptest.NewRecorder()
@@ -21,3 +22,34 @@ func TestUnitInit(t *testing.T) {
 		t.Errorf("Expected status code %d, got %d", http.StatusOK, rr.Code)
 	}
 }
+
+func TestUnitInitMetrics(t *testing.T) {
+	r := pat.New()
+	Init(r, nil, nil, "")
+
+	req := httptest.NewRequest("GET", "/payment-reconciliation-consumer/metrics", nil)
+	rr := httptest.NewRecorder()
+
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "go_goroutines") {
+		t.Errorf("Expected metrics in response body, got %s", rr.Body.String())
+	}
+}
+
+func TestUnitInitReadiness(t *testing.T) {
+	r := pat.New()
+	Init(r, map[string]ReadinessCheck{"mongo": notReady}, nil, "")
+
+	req := httptest.NewRequest("GET", "/payment-reconciliation-consumer/readiness", nil)
+	rr := httptest.NewRecorder()
+
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/companieshouse/chs.go/log"
+)
+
+const readinessTimeout = 5 * time.Second
+
+// ReadinessCheck returns an error if the dependency it checks is not ready
+type ReadinessCheck func(ctx context.Context) error
+
+// DependencyStatus is the readiness of a single dependency
+type DependencyStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ReadinessResponse is the body returned from the readiness endpoint
+type ReadinessResponse struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+// Readiness returns a handler which runs each of the named checks, responding with the status of every dependency and
+// a 503 if any of them is not ready.
+func Readiness(checks map[string]ReadinessCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+
+		response := ReadinessResponse{Status: "ok", Dependencies: map[string]DependencyStatus{}}
+		statusCode := http.StatusOK
+
+		for name, check := range checks {
+			if err := check(ctx); err != nil {
+				log.Info("dependency is not ready", log.Data{"dependency": name, "error": err.Error()})
+				response.Dependencies[name] = DependencyStatus{Status: "unavailable", Error: err.Error()}
+				response.Status = "unavailable"
+				statusCode = http.StatusServiceUnavailable
+				continue
+			}
+			response.Dependencies[name] = DependencyStatus{Status: "ok"}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Error(err, nil)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func ready(ctx context.Context) error {
+	return nil
+}
+
+func notReady(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+func TestUnitReadiness(t *testing.T) {
+	Convey("Given the readiness endpoint is called", t, func() {
+		req, err := http.NewRequest("GET", "/payment-reconciliation-consumer/readiness", nil)
+		So(err, ShouldBeNil)
+		response := httptest.NewRecorder()
+
+		Convey("When every dependency is ready then a 200 is returned", func() {
+			Readiness(map[string]ReadinessCheck{"mongo": ready, "kafka": ready})(response, req)
+
+			var body ReadinessResponse
+			So(json.Unmarshal(response.Body.Bytes(), &body), ShouldBeNil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(body.Status, ShouldEqual, "ok")
+			So(body.Dependencies["mongo"].Status, ShouldEqual, "ok")
+			So(body.Dependencies["kafka"].Status, ShouldEqual, "ok")
+		})
+
+		Convey("When a dependency is not ready then a 503 is returned with the reason", func() {
+			Readiness(map[string]ReadinessCheck{"mongo": notReady, "kafka": ready})(response, req)
+
+			var body ReadinessResponse
+			So(json.Unmarshal(response.Body.Bytes(), &body), ShouldBeNil)
+			So(response.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(body.Status, ShouldEqual, "unavailable")
+			So(body.Dependencies["mongo"], ShouldResemble, DependencyStatus{Status: "unavailable", Error: "connection refused"})
+			So(body.Dependencies["kafka"].Status, ShouldEqual, "ok")
+		})
+	})
+}
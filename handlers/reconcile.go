@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/payment-reconciliation-consumer/keys"
+	"github.com/companieshouse/payment-reconciliation-consumer/models"
+	"github.com/companieshouse/payment-reconciliation-consumer/service"
+)
+
+// ReconcileResponse is the body returned from the admin reconcile endpoint. Email addresses are removed from the
+// records written before they are returned.
+type ReconcileResponse struct {
+	PaymentID    string                                  `json:"payment_id"`
+	RefundID     string                                  `json:"refund_id,omitempty"`
+	Outcome      string                                  `json:"outcome"`
+	Reason       string                                  `json:"reason,omitempty"`
+	Error        string                                  `json:"error,omitempty"`
+	Products     []models.EshuResourceDao                `json:"products,omitempty"`
+	Transactions []models.PaymentTransactionsResourceDao `json:"transactions,omitempty"`
+	SkippedCosts []models.SkippedCostResourceDao         `json:"skipped_costs,omitempty"`
+	Refund       *models.RefundResourceDao               `json:"refund,omitempty"`
+}
+
+// Reconcile returns a handler which synchronously reconciles the payment named in the path, or one of its refunds
+// when a refund_id query parameter is given, and responds with the records written or the reason nothing was.
+func Reconcile(reconciler service.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		paymentID := r.URL.Query().Get(":paymentId")
+		refundID := r.URL.Query().Get("refund_id")
+		log.Info("Reconciliation requested through admin endpoint", log.Data{keys.PaymentID: paymentID, keys.RefundID: refundID})
+
+		reconciliation := reconciler.Reconcile(r.Context(), paymentID, refundID)
+
+		response := ReconcileResponse{
+			PaymentID:    reconciliation.PaymentID,
+			RefundID:     reconciliation.RefundID,
+			Outcome:      reconciliation.Kind.String(),
+			Reason:       reconciliation.Reason,
+			Error:        reconciliation.ErrorMessage(),
+			Products:     reconciliation.Eshus,
+			Transactions: transactionsWithoutEmail(reconciliation.Transactions),
+			SkippedCosts: reconciliation.SkippedCosts,
+			Refund:       refundWithoutEmail(reconciliation.Refund),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(reconcileStatus(reconciliation.Kind))
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Error(err, nil)
+		}
+	}
+}
+
+func reconcileStatus(kind service.OutcomeKind) int {
+	switch kind {
+	case service.RetryableFailure:
+		return http.StatusServiceUnavailable
+	case service.PermanentFailure:
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusOK
+}
+
+func transactionsWithoutEmail(txns []models.PaymentTransactionsResourceDao) []models.PaymentTransactionsResourceDao {
+	if txns == nil {
+		return nil
+	}
+	views := make([]models.PaymentTransactionsResourceDao, len(txns))
+	for i, txn := range txns {
+		txn.Email = ""
+		views[i] = txn
+	}
+	return views
+}
+
+func refundWithoutEmail(refund *models.RefundResourceDao) *models.RefundResourceDao {
+	if refund == nil {
+		return nil
+	}
+	view := *refund
+	view.Email = ""
+	return &view
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/companieshouse/payment-reconciliation-consumer/data"
+	"github.com/companieshouse/payment-reconciliation-consumer/models"
+	"github.com/companieshouse/payment-reconciliation-consumer/service"
+	"github.com/gorilla/pat"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type mockReconciler struct {
+	received data.PaymentProcessed
+	outcome  service.Outcome
+}
+
+func (m *mockReconciler) Reconcile(ctx context.Context, paymentID, refundID string) service.Reconciliation {
+	m.received = data.PaymentProcessed{ResourceURI: paymentID, RefundId: refundID}
+	return service.Reconciliation{PaymentID: paymentID, RefundID: refundID, Outcome: m.outcome}
+}
+
+func TestUnitReconcile(t *testing.T) {
+	Convey("Given a reconciliation is requested through the admin endpoint", t, func() {
+		reconciler := &mockReconciler{}
+		r := pat.New()
+		Init(r, nil, reconciler, "admin-key")
+		response := httptest.NewRecorder()
+
+		Convey("When the payment is reconciled then the records written are returned", func() {
+			reconciler.outcome = service.Outcome{
+				Kind:         service.Reconciled,
+				Eshus:        []models.EshuResourceDao{{PaymentRef: "Xpayment", ProductCode: 27007}},
+				Transactions: []models.PaymentTransactionsResourceDao{{TransactionID: "Xpayment", Amount: "15", Email: "test@ch.gov.uk"}},
+			}
+
+			r.ServeHTTP(response, adminRequest("/payment-reconciliation-consumer/admin/reconcile/payment"))
+
+			var body ReconcileResponse
+			So(json.Unmarshal(response.Body.Bytes(), &body), ShouldBeNil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(reconciler.received, ShouldResemble, data.PaymentProcessed{ResourceURI: "payment"})
+			So(body.Outcome, ShouldEqual, "reconciled")
+			So(body.Products, ShouldResemble, reconciler.outcome.Eshus)
+			So(body.Transactions, ShouldResemble, []models.PaymentTransactionsResourceDao{{TransactionID: "Xpayment", Amount: "15"}})
+			So(response.Body.String(), ShouldNotContainSubstring, "test@ch.gov.uk")
+		})
+
+		Convey("When a refund is reconciled then its email address is not returned", func() {
+			reconciler.outcome = service.Outcome{Kind: service.Reconciled, Refund: &models.RefundResourceDao{RefundID: "refund", Email: "test@ch.gov.uk"}}
+
+			r.ServeHTTP(response, adminRequest("/payment-reconciliation-consumer/admin/reconcile/payment?refund_id=refund"))
+
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldNotContainSubstring, "test@ch.gov.uk")
+			So(reconciler.outcome.Refund.Email, ShouldEqual, "test@ch.gov.uk")
+		})
+
+		Convey("When the request does not have the admin key then it is rejected", func() {
+			for _, authorization := range []string{"", "admin-key", "Bearer wrong-key"} {
+				response := httptest.NewRecorder()
+				request := httptest.NewRequest("POST", "/payment-reconciliation-consumer/admin/reconcile/payment", nil)
+				request.Header.Set("Authorization", authorization)
+
+				r.ServeHTTP(response, request)
+
+				So(response.Code, ShouldEqual, http.StatusUnauthorized)
+			}
+			So(reconciler.received, ShouldResemble, data.PaymentProcessed{})
+		})
+
+		Convey("When a refund is skipped then the reason is returned", func() {
+			reconciler.outcome = service.Outcome{Kind: service.Skipped, Reason: "refund failed"}
+
+			r.ServeHTTP(response, adminRequest("/payment-reconciliation-consumer/admin/reconcile/payment?refund_id=refund"))
+
+			var body ReconcileResponse
+			So(json.Unmarshal(response.Body.Bytes(), &body), ShouldBeNil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(reconciler.received, ShouldResemble, data.PaymentProcessed{ResourceURI: "payment", RefundId: "refund"})
+			So(body.Outcome, ShouldEqual, "skipped")
+			So(body.Reason, ShouldEqual, "refund failed")
+			So(body.RefundID, ShouldEqual, "refund")
+		})
+
+		Convey("When reconciliation fails then the error is returned with a failure status", func() {
+			reconciler.outcome = service.Outcome{Kind: service.PermanentFailure, Err: errors.New("no product code mapped for product type")}
+
+			r.ServeHTTP(response, adminRequest("/payment-reconciliation-consumer/admin/reconcile/payment"))
+
+			var body ReconcileResponse
+			So(json.Unmarshal(response.Body.Bytes(), &body), ShouldBeNil)
+			So(response.Code, ShouldEqual, http.StatusUnprocessableEntity)
+			So(body.Error, ShouldEqual, "no product code mapped for product type")
+		})
+	})
+
+	Convey("The admin endpoint is not registered without a reconciler", t, func() {
+		r := pat.New()
+		Init(r, nil, nil, "admin-key")
+		response := httptest.NewRecorder()
+
+		r.ServeHTTP(response, adminRequest("/payment-reconciliation-consumer/admin/reconcile/payment"))
+
+		So(response.Code, ShouldEqual, http.StatusNotFound)
+	})
+
+	Convey("Every request to the admin endpoint is rejected when no admin key is configured", t, func() {
+		reconciler := &mockReconciler{}
+		r := pat.New()
+		Init(r, nil, reconciler, "")
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest("POST", "/payment-reconciliation-consumer/admin/reconcile/payment", nil)
+		request.Header.Set("Authorization", "Bearer ")
+
+		r.ServeHTTP(response, request)
+
+		So(response.Code, ShouldEqual, http.StatusUnauthorized)
+	})
+}
+
+// adminRequest returns a request to path authenticated with the admin key
+func adminRequest(path string) *http.Request {
+	request := httptest.NewRequest("POST", path, nil)
+	request.Header.Set("Authorization", "Bearer admin-key")
+	return request
+}
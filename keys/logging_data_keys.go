@@ -7,11 +7,15 @@ const BaseTopic = "base_topic"
 const MaxRetries = "maxRetries"
 const Message = "message"
 const Offset = "message_offset"
+const Outcome = "outcome"
 const Payment = "payment"
 const PaymentDetails = "payment_details"
+const PaymentID = "payment_id"
 const RefundDetails = "refund_details"
+const RefundID = "refund_id"
 const PaymentResponse = "payment_response"
 const Producer = "producer"
+const Reason = "reason"
 const Request = "Request"
 const SchemaName = "schema_name"
 const StatusCode = "status_code"
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	gologger "log"
 	"net/http"
@@ -19,18 +21,47 @@ import (
 	"github.com/gorilla/pat"
 )
 
+// subcommands run a single task in place of the consumer, by name
+var subcommands = map[string]func(args []string) error{
+	"archive":  runArchive,
+	"backfill": runBackfill,
+	"erase":    runErase,
+	"export":   runExport,
+	"migrate":  runMigrate,
+	"replay":   runReplay,
+}
+
 func main() {
 	log.Namespace = "payment-reconciliation-consumer"
 
 	// Push the Sarama logs into our custom writer
 	sarama.Logger = gologger.New(&log.Writer{}, "[Sarama] ", gologger.LstdFlags)
 
+	// Subcommands have their own flags, so they must be removed before the service config is read
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			name, args := os.Args[1], os.Args[2:]
+			os.Args = os.Args[:1]
+			if err := run(args); err != nil {
+				log.Error(fmt.Errorf("error running %s: %s", name, err), nil)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	cfg, err := config.Get()
 	if err != nil {
 		log.Error(fmt.Errorf("error configuring service: %s. Exiting", err), nil)
 		return
 	}
 
+	// The admin endpoints write to the database, so they are never exposed without authentication
+	if cfg.AdminEndpointsEnabled && cfg.AdminAPIKey == "" {
+		log.Error(errors.New("ADMIN_API_KEY must be set when ADMIN_ENDPOINTS_ENABLED is true. Exiting"), nil)
+		return
+	}
+
 	log.Info("intialising payment-reconciliation-consumer service...")
 
 	mainChannel := make(chan os.Signal, 1)
@@ -42,14 +73,30 @@ func main() {
 		return
 	}
 
+	// The product map can be changed without a restart, by sending SIGHUP or by replacing the file
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloadChannel := make(chan os.Signal, 1)
+	signal.Notify(reloadChannel, syscall.SIGHUP)
+	go config.ProductMaps().Watch(ctx, time.Duration(cfg.ProductMapPollInterval)*time.Second, reloadChannel)
+
+	checks := map[string]handlers.ReadinessCheck{
+		"mongodb":                  svc.DAO.Ping,
+		"kafka":                    svc.CheckConsumer,
+		"payment_processed_schema": svc.CheckSchema,
+	}
+
 	var wg sync.WaitGroup
-	if !cfg.IsErrorConsumer {
+	// A dry run never produces to the retry topic, so there is nothing for a retry consumer to do
+	if !cfg.IsErrorConsumer && !cfg.DryRun {
 		retrySvc, err := getRetryService(cfg)
 		if err != nil {
 			log.Error(fmt.Errorf("error initialising retry consumer service: '%s'. Exiting", err), nil)
 			svc.Shutdown(cfg.PaymentProcessedTopic)
 			return
 		}
+		checks["kafka_retry"] = retrySvc.CheckConsumer
+
 		wg.Add(1)
 		go retrySvc.Start(&wg, retryChannel)
 	}
@@ -57,8 +104,13 @@ func main() {
 	wg.Add(1)
 	go svc.Start(&wg, mainChannel)
 
+	var reconciler service.Reconciler
+	if cfg.AdminEndpointsEnabled {
+		reconciler = svc.Handler
+	}
+
 	router := pat.New()
-	handlers.Init(router)
+	handlers.Init(router, checks, reconciler, cfg.AdminAPIKey)
 	go func() {
 		log.Info("Starting HTTP server on :" + "8080")
 		if err := http.ListenAndServe(":8080", router); err != nil {
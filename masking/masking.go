@@ -0,0 +1,186 @@
+package masking
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// PolicyFile is the file the masking policy is loaded from
+const PolicyFile = "assets/masking.yml"
+
+// ErrInvalidPolicy is returned when a masking policy cannot be used
+var ErrInvalidPolicy = errors.New("invalid masking policy")
+
+// Fields which can be masked in reconciliation records
+const (
+	CompanyNumber  = "company_number"
+	Email          = "email"
+	OrderReference = "order_reference"
+)
+
+var knownFields = map[string]bool{CompanyNumber: true, Email: true, OrderReference: true}
+
+// Action is how a field is masked
+type Action string
+
+const (
+	// Blank replaces the value with an empty string
+	Blank Action = "blank"
+	// Hash replaces the value with its keyed hash, so records can still be matched on it without the value being
+	// recoverable by anyone without the key
+	Hash Action = "hash"
+	// Truncate keeps the first Length characters of the value
+	Truncate Action = "truncate"
+)
+
+// FieldRule is the action to take on a single field
+type FieldRule struct {
+	Field  string `yaml:"field"`
+	Action Action `yaml:"action"`
+	Length int    `yaml:"length"`
+}
+
+// Rule masks fields of the records for costs with any of the product types or product codes listed
+type Rule struct {
+	Name         string      `yaml:"name"`
+	ProductTypes []string    `yaml:"product_types"`
+	ProductCodes []int       `yaml:"product_codes"`
+	Fields       []FieldRule `yaml:"fields"`
+}
+
+// Hasher returns a keyed hash of a value, such as the Hash of an encryption.Keyring
+type Hasher interface {
+	Hash(value string) string
+}
+
+// Policy is the set of rules used to mask reconciliation records. A nil Policy masks nothing.
+type Policy struct {
+	Rules []Rule `yaml:"rules"`
+	// Hasher hashes the fields masked with the Hash action. Those fields are blanked when it is nil, as an unkeyed
+	// hash of a value such as an email address can be reversed by hashing guesses.
+	Hasher Hasher `yaml:"-"`
+}
+
+// Fields holds pointers to the fields of a record which may be masked, by field name
+type Fields map[string]*string
+
+// Load reads the masking policy from the file at path
+func Load(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// Parse reads a masking policy from yaml and validates it
+func Parse(b []byte) (*Policy, error) {
+	var policy Policy
+	if err := yaml.UnmarshalStrict(b, &policy); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPolicy, err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPolicy, err)
+	}
+	return &policy, nil
+}
+
+// Validate checks that every rule matches at least one product and masks at least one known field with a known action
+func (p *Policy) Validate() error {
+	var problems []error
+	for i, rule := range p.Rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("%d", i)
+		}
+		if len(rule.ProductTypes) == 0 && len(rule.ProductCodes) == 0 {
+			problems = append(problems, fmt.Errorf("rule [%s] has no product types or product codes", name))
+		}
+		if len(rule.Fields) == 0 {
+			problems = append(problems, fmt.Errorf("rule [%s] has no fields", name))
+		}
+		for _, field := range rule.Fields {
+			if !knownFields[field.Field] {
+				problems = append(problems, fmt.Errorf("rule [%s] has unknown field [%s]", name, field.Field))
+			}
+			switch field.Action {
+			case Blank, Hash:
+			case Truncate:
+				if field.Length < 1 {
+					problems = append(problems, fmt.Errorf("rule [%s] truncates field [%s] without a positive length", name, field.Field))
+				}
+			default:
+				problems = append(problems, fmt.Errorf("rule [%s] has unknown action [%s] for field [%s]", name, field.Action, field.Field))
+			}
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// Apply masks the fields of a record for a cost with the given product type and product code, using every rule which
+// matches the cost. Fields not held in fields are left alone.
+func (p *Policy) Apply(productType string, productCode int, fields Fields) {
+	if p == nil {
+		return
+	}
+	for _, rule := range p.Rules {
+		if !rule.matches(productType, productCode) {
+			continue
+		}
+		for _, field := range rule.Fields {
+			if value, ok := fields[field.Field]; ok && value != nil {
+				*value = field.apply(*value, p.Hasher)
+			}
+		}
+	}
+}
+
+// Hashes reports whether any rule masks a field with the Hash action, and so needs a Hasher
+func (p *Policy) Hashes() bool {
+	if p == nil {
+		return false
+	}
+	for _, rule := range p.Rules {
+		for _, field := range rule.Fields {
+			if field.Action == Hash {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (rule Rule) matches(productType string, productCode int) bool {
+	for _, t := range rule.ProductTypes {
+		if t == productType {
+			return true
+		}
+	}
+	for _, c := range rule.ProductCodes {
+		if c == productCode {
+			return true
+		}
+	}
+	return false
+}
+
+func (field FieldRule) apply(value string, hasher Hasher) string {
+	switch field.Action {
+	case Blank:
+		return ""
+	case Hash:
+		if value == "" || hasher == nil {
+			return ""
+		}
+		return hasher.Hash(value)
+	case Truncate:
+		runes := []rune(value)
+		if len(runes) > field.Length {
+			return string(runes[:field.Length])
+		}
+	}
+	return value
+}
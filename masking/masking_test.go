@@ -0,0 +1,170 @@
+package masking
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/companieshouse/payment-reconciliation-consumer/encryption"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitLoad(t *testing.T) {
+
+	Convey("The masking policy shipped with the service is valid", t, func() {
+		policy, err := Load(filepath.Join("..", PolicyFile))
+
+		So(err, ShouldBeNil)
+		So(policy.Rules, ShouldNotBeEmpty)
+	})
+}
+
+func TestUnitParse(t *testing.T) {
+
+	Convey("A valid policy is parsed", t, func() {
+		policy, err := Parse([]byte(`
+rules:
+  - name: secure
+    product_types: [pro-app-1]
+    product_codes: [16800]
+    fields:
+      - field: email
+        action: hash
+      - field: company_number
+        action: truncate
+        length: 2
+`))
+
+		So(err, ShouldBeNil)
+		So(policy.Rules, ShouldResemble, []Rule{{
+			Name:         "secure",
+			ProductTypes: []string{"pro-app-1"},
+			ProductCodes: []int{16800},
+			Fields:       []FieldRule{{Field: Email, Action: Hash}, {Field: CompanyNumber, Action: Truncate, Length: 2}},
+		}})
+	})
+
+	Convey("A rule without products is rejected", t, func() {
+		_, err := Parse([]byte("rules:\n  - name: secure\n    fields:\n      - field: email\n        action: blank\n"))
+
+		So(errors.Is(err, ErrInvalidPolicy), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, "rule [secure] has no product types or product codes")
+	})
+
+	Convey("A rule without fields is rejected", t, func() {
+		_, err := Parse([]byte("rules:\n  - product_codes: [16800]\n"))
+
+		So(errors.Is(err, ErrInvalidPolicy), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, "rule [0] has no fields")
+	})
+
+	Convey("Unknown fields and actions are rejected", t, func() {
+		_, err := Parse([]byte("rules:\n  - product_codes: [16800]\n    fields:\n      - field: surname\n        action: scramble\n"))
+
+		So(errors.Is(err, ErrInvalidPolicy), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, "unknown field [surname]")
+		So(err.Error(), ShouldContainSubstring, "unknown action [scramble]")
+	})
+
+	Convey("Truncating without a length is rejected", t, func() {
+		_, err := Parse([]byte("rules:\n  - product_codes: [16800]\n    fields:\n      - field: email\n        action: truncate\n"))
+
+		So(errors.Is(err, ErrInvalidPolicy), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, "truncates field [email] without a positive length")
+	})
+
+	Convey("Misspelt keys are rejected", t, func() {
+		_, err := Parse([]byte("rules:\n  - product_code: [16800]\n    fields:\n      - field: email\n        action: blank\n"))
+
+		So(errors.Is(err, ErrInvalidPolicy), ShouldBeTrue)
+	})
+}
+
+func TestUnitApply(t *testing.T) {
+
+	keyring, err := encryption.NewKeyring("1", bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("error creating keyring: %s", err)
+	}
+
+	policy := &Policy{Hasher: keyring, Rules: []Rule{
+		{
+			ProductCodes: []int{16800},
+			Fields:       []FieldRule{{Field: CompanyNumber, Action: Blank}, {Field: Email, Action: Hash}},
+		},
+		{
+			ProductTypes: []string{"secure-type"},
+			Fields:       []FieldRule{{Field: CompanyNumber, Action: Truncate, Length: 2}},
+		},
+	}}
+
+	Convey("Fields of records for a matching product code are masked", t, func() {
+		companyNumber, email := "00006400", "test@ch.gov.uk"
+
+		policy.Apply("pro-app-1", 16800, Fields{CompanyNumber: &companyNumber, Email: &email})
+
+		So(companyNumber, ShouldEqual, "")
+		So(email, ShouldEqual, keyring.Hash("test@ch.gov.uk"))
+		So(email, ShouldNotEqual, "2ac0fc399f2578889e0191a4e5180aa6e70268fb5d686f4d5541ef301fe6c0bd")
+	})
+
+	Convey("Fields to be hashed are blanked when the policy has no Hasher", t, func() {
+		unkeyed := &Policy{Rules: policy.Rules}
+		email := "test@ch.gov.uk"
+
+		unkeyed.Apply("pro-app-1", 16800, Fields{Email: &email})
+
+		So(email, ShouldEqual, "")
+	})
+
+	Convey("Fields of records for a matching product type are masked", t, func() {
+		companyNumber := "00006400"
+
+		policy.Apply("secure-type", 27000, Fields{CompanyNumber: &companyNumber})
+
+		So(companyNumber, ShouldEqual, "00")
+	})
+
+	Convey("Fields of records for other products are left alone", t, func() {
+		companyNumber, email := "00006400", "test@ch.gov.uk"
+
+		policy.Apply("certificate", 27007, Fields{CompanyNumber: &companyNumber, Email: &email})
+
+		So(companyNumber, ShouldEqual, "00006400")
+		So(email, ShouldEqual, "test@ch.gov.uk")
+	})
+
+	Convey("Fields a record does not have are ignored", t, func() {
+		companyNumber := "00006400"
+
+		policy.Apply("pro-app-1", 16800, Fields{CompanyNumber: &companyNumber})
+
+		So(companyNumber, ShouldEqual, "")
+	})
+
+	Convey("Empty values stay empty when hashed", t, func() {
+		email := ""
+
+		policy.Apply("pro-app-1", 16800, Fields{Email: &email})
+
+		So(email, ShouldEqual, "")
+	})
+
+	Convey("A policy needs a Hasher only when a rule hashes a field", t, func() {
+		var nilPolicy *Policy
+
+		So(policy.Hashes(), ShouldBeTrue)
+		So((&Policy{Rules: policy.Rules[1:]}).Hashes(), ShouldBeFalse)
+		So(nilPolicy.Hashes(), ShouldBeFalse)
+	})
+
+	Convey("A nil policy masks nothing", t, func() {
+		var nilPolicy *Policy
+		companyNumber := "00006400"
+
+		nilPolicy.Apply("pro-app-1", 16800, Fields{CompanyNumber: &companyNumber})
+
+		So(companyNumber, ShouldEqual, "00006400")
+	})
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "payment_reconciliation_consumer"
+
+var (
+	// Messages counts the payment-processed messages processed, by outcome, skip reason, class of payment and
+	// product type
+	Messages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_total",
+		Help:      "Number of payment-processed messages processed, by outcome.",
+	}, []string{"outcome", "reason", "class_of_payment", "product_type"})
+
+	// PaymentsAPIDuration observes the latency of requests to the payments api, by operation
+	PaymentsAPIDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "payments_api_request_duration_seconds",
+		Help:      "Latency of requests to the payments api.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	// MongoDuration observes the latency of mongo operations, by operation
+	MongoDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mongo_operation_duration_seconds",
+		Help:      "Latency of mongo operations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	// LastProcessedOffset records the offset of the last message processed, by topic
+	LastProcessedOffset = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_processed_offset",
+		Help:      "Offset of the last message processed from each topic.",
+	}, []string{"topic"})
+)
+
+func init() {
+	prometheus.MustRegister(Messages, PaymentsAPIDuration, MongoDuration, LastProcessedOffset)
+}
+
+// ObserveDuration records the time elapsed since start against the given operation of the histogram
+func ObserveDuration(histogram *prometheus.HistogramVec, operation string, start time.Time) {
+	histogram.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/payment-reconciliation-consumer/config"
+	"github.com/companieshouse/payment-reconciliation-consumer/dao"
+)
+
+// runMigrate applies any pending migrations to the reconciliation collections and writes each one applied to
+// standard output, or with -status writes every migration and when it was applied without changing anything. It
+// allows migrations to be run ahead of a deployment when MIGRATE_ON_STARTUP is turned off.
+func runMigrate(args []string) error {
+	cfg, err := config.Get()
+	if err != nil {
+		return fmt.Errorf("error configuring service: %s", err)
+	}
+
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	status := flags.Bool("status", false, "Report the migrations and whether they have been applied without running any")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	migrator, err := dao.NewMigrator(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var migrations []dao.MigrationStatus
+	if *status {
+		migrations, err = migrator.MigrationStatus(ctx)
+	} else {
+		migrations, err = migrator.Migrate(ctx)
+	}
+
+	// The migrations applied before any failure are still reported, as they will not be run again
+	encoder := json.NewEncoder(os.Stdout)
+	for _, migration := range migrations {
+		if encodeErr := encoder.Encode(migration); encodeErr != nil {
+			return encodeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if !*status {
+		log.Info("migrations complete", log.Data{"applied": len(migrations)})
+	}
+	return nil
+}
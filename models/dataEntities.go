@@ -1,49 +1,97 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"github.com/companieshouse/payment-reconciliation-consumer/encryption"
+	"github.com/companieshouse/payment-reconciliation-consumer/money"
+)
 
 // EshuResourceDao represents the Eshu data structure
 type EshuResourceDao struct {
-	PaymentRef      string    `bson:"payment_reference"`
-	ProductCode     int       `bson:"product_code"`
-	CompanyNumber   string    `bson:"company_number"`
-	FilingDate      string    `bson:"filing_date"`
-	MadeUpdate      string    `bson:"made_up_date"`
-	TransactionDate time.Time `bson:"transaction_date"`
+	PaymentRef      string      `bson:"payment_reference" json:"payment_reference"`
+	ProductCode     int         `bson:"product_code" json:"product_code"`
+	CompanyNumber   string      `bson:"company_number" json:"company_number"`
+	FilingDate      string      `bson:"filing_date" json:"filing_date"`
+	MadeUpdate      string      `bson:"made_up_date" json:"made_up_date"`
+	TransactionDate time.Time   `bson:"transaction_date" json:"transaction_date"`
+	CostLine        int         `bson:"cost_line" json:"cost_line"`
+	Amount          string      `bson:"amount" json:"amount"`
+	AmountPence     money.Pence `bson:"amount_pence" json:"amount_pence"`
 }
 
 // PaymentTransactionsResourceDao represents the payment transaction data structure
 type PaymentTransactionsResourceDao struct {
-	TransactionID     string    `bson:"transaction_id"`
-	TransactionDate   time.Time `bson:"transaction_date"`
-	Email             string    `bson:"email"`
-	PaymentMethod     string    `bson:"payment_method"`
-	Amount            string    `bson:"amount"`
-	CompanyNumber     string    `bson:"company_number"`
-	TransactionType   string    `bson:"transaction_type"`
-	OrderReference    string    `bson:"order_reference"`
-	Status            string    `bson:"status"`
-	UserID            string    `bson:"user_id"`
-	OriginalReference string    `bson:"original_reference"`
-	DisputeDetails    string    `bson:"dispute_details"`
+	TransactionID     string                     `bson:"transaction_id" json:"transaction_id"`
+	TransactionDate   time.Time                  `bson:"transaction_date" json:"transaction_date"`
+	Email             string                     `bson:"email,omitempty" json:"email,omitempty"`
+	EncryptedEmail    *encryption.EncryptedValue `bson:"encrypted_email,omitempty" json:"-"`
+	EmailHash         string                     `bson:"email_hash,omitempty" json:"-"`
+	PaymentMethod     string                     `bson:"payment_method" json:"payment_method"`
+	Amount            string                     `bson:"amount" json:"amount"`
+	AmountPence       money.Pence                `bson:"amount_pence" json:"amount_pence"`
+	CompanyNumber     string                     `bson:"company_number" json:"company_number"`
+	TransactionType   string                     `bson:"transaction_type" json:"transaction_type"`
+	OrderReference    string                     `bson:"order_reference" json:"order_reference"`
+	Status            string                     `bson:"status" json:"status"`
+	UserID            string                     `bson:"user_id" json:"user_id"`
+	OriginalReference string                     `bson:"original_reference" json:"original_reference"`
+	DisputeDetails    string                     `bson:"dispute_details" json:"dispute_details"`
+	CostLine          int                        `bson:"cost_line" json:"cost_line"`
 }
 
 // RefundResourceDao represents the refund data structure
 type RefundResourceDao struct {
-	TransactionID     string    `bson:"transaction_id"`
-	TransactionDate   time.Time `bson:"transaction_date"`
-	Email             string    `bson:"email"`
-	PaymentMethod     string    `bson:"payment_method"`
-	Amount            string    `bson:"amount"`
-	CompanyNumber     string    `bson:"company_number"`
-	TransactionType   string    `bson:"transaction_type"`
-	OrderReference    string    `bson:"order_reference"`
-	Status            string    `bson:"status"`
-	UserID            string    `bson:"user_id"`
-	OriginalReference string    `bson:"original_reference"`
-	DisputeDetails    string    `bson:"dispute_details"`
-	ProductCode       int       `bson:"product_code"`
-	PaymentID         string    `bson:"payment_id"`
-	RefundID          string    `bson:"refund_id"`
-	RefundedAt        time.Time `bson:"refunded_at"`
+	TransactionID     string                     `bson:"transaction_id" json:"transaction_id"`
+	TransactionDate   time.Time                  `bson:"transaction_date" json:"transaction_date"`
+	Email             string                     `bson:"email,omitempty" json:"email,omitempty"`
+	EncryptedEmail    *encryption.EncryptedValue `bson:"encrypted_email,omitempty" json:"-"`
+	EmailHash         string                     `bson:"email_hash,omitempty" json:"-"`
+	PaymentMethod     string                     `bson:"payment_method" json:"payment_method"`
+	Amount            string                     `bson:"amount" json:"amount"`
+	AmountPence       money.Pence                `bson:"amount_pence" json:"amount_pence"`
+	CompanyNumber     string                     `bson:"company_number" json:"company_number"`
+	TransactionType   string                     `bson:"transaction_type" json:"transaction_type"`
+	OrderReference    string                     `bson:"order_reference" json:"order_reference"`
+	Status            string                     `bson:"status" json:"status"`
+	UserID            string                     `bson:"user_id" json:"user_id"`
+	OriginalReference string                     `bson:"original_reference" json:"original_reference"`
+	DisputeDetails    string                     `bson:"dispute_details" json:"dispute_details"`
+	ProductCode       int                        `bson:"product_code" json:"product_code"`
+	PaymentID         string                     `bson:"payment_id" json:"payment_id"`
+	RefundID          string                     `bson:"refund_id" json:"refund_id"`
+	RefundedAt        time.Time                  `bson:"refunded_at" json:"refunded_at"`
+}
+
+// SkippedCostResourceDao represents a cost of a partially reconciled payment which was not reconciled
+type SkippedCostResourceDao struct {
+	PaymentRef      string      `bson:"payment_reference" json:"payment_reference"`
+	CostLine        int         `bson:"cost_line" json:"cost_line"`
+	ClassOfPayment  string      `bson:"class_of_payment" json:"class_of_payment"`
+	ProductType     string      `bson:"product_type" json:"product_type"`
+	Amount          string      `bson:"amount" json:"amount"`
+	AmountPence     money.Pence `bson:"amount_pence" json:"amount_pence"`
+	Reason          string      `bson:"reason" json:"reason"`
+	TransactionDate time.Time   `bson:"transaction_date" json:"transaction_date"`
+}
+
+// ErasureAuditDao records the erasure of a data subject's personal fields from the reconciliation records. The subject
+// is held only as keyed hashes, so the audit does not itself identify them.
+type ErasureAuditDao struct {
+	ErasureID    string    `bson:"erasure_id" json:"erasure_id"`
+	EmailHash    string    `bson:"email_hash,omitempty" json:"email_hash,omitempty"`
+	Pseudonym    string    `bson:"pseudonym" json:"pseudonym"`
+	RequestedBy  string    `bson:"requested_by" json:"requested_by"`
+	Reference    string    `bson:"reference" json:"reference"`
+	ErasedAt     time.Time `bson:"erased_at" json:"erased_at"`
+	Transactions int       `bson:"transactions" json:"transactions"`
+	Refunds      int       `bson:"refunds" json:"refunds"`
+	Products     int       `bson:"products" json:"products"`
+}
+
+// MigrationDao records that a migration has been applied to the reconciliation collections
+type MigrationDao struct {
+	Version     int       `bson:"_id" json:"version"`
+	Description string    `bson:"description" json:"description"`
+	AppliedAt   time.Time `bson:"applied_at" json:"applied_at"`
 }
@@ -0,0 +1,54 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidAmount is returned when an amount cannot be parsed as a non-negative number of pounds and pence
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// Pounds and pence, with at most two decimal places, as returned in payments api amounts such as "15", "12.5" and
+// "12.50"
+var amountPattern = regexp.MustCompile(`^(\d+)(?:\.(\d{1,2}))?$`)
+
+// Pence is an exact amount of money, held as a whole number of pence
+type Pence int64
+
+// Parse parses an amount of pounds, as returned by the payments api, into pence
+func Parse(amount string) (Pence, error) {
+	match := amountPattern.FindStringSubmatch(strings.TrimSpace(amount))
+	if match == nil {
+		return 0, fmt.Errorf("%w: [%s]", ErrInvalidAmount, amount)
+	}
+
+	pounds, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil || pounds > math.MaxInt64/100-1 {
+		return 0, fmt.Errorf("%w: [%s]", ErrInvalidAmount, amount)
+	}
+
+	pence := int64(0)
+	if match[2] != "" {
+		// A single decimal place is tenths of a pound, so "12.5" is 50 pence
+		pence, _ = strconv.ParseInt((match[2] + "0")[:2], 10, 64)
+	}
+
+	return Pence(pounds*100 + pence), nil
+}
+
+// FromPence returns the amount for a whole number of pence, as returned in payments api refund amounts
+func FromPence(pence int) (Pence, error) {
+	if pence < 0 {
+		return 0, fmt.Errorf("%w: [%d] pence", ErrInvalidAmount, pence)
+	}
+	return Pence(pence), nil
+}
+
+// String formats the amount as pounds with two decimal places, such as "12.50"
+func (p Pence) String() string {
+	return fmt.Sprintf("%d.%02d", p/100, p%100)
+}
@@ -0,0 +1,55 @@
+package money
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitParse(t *testing.T) {
+	Convey("Payments api amounts are parsed into exact pence", t, func() {
+		for amount, expected := range map[string]Pence{
+			"15":      1500,
+			"0":       0,
+			"12.5":    1250,
+			"12.50":   1250,
+			"12.05":   1205,
+			"0.99":    99,
+			" 100.00": 10000,
+		} {
+			pence, err := Parse(amount)
+			So(err, ShouldBeNil)
+			So(pence, ShouldEqual, expected)
+		}
+	})
+
+	Convey("Amounts that are not pounds and pence are rejected", t, func() {
+		for _, amount := range []string{"", "abc", "12.505", "-5", "12.", ".5", "1,000", "£12", "999999999999999999", "99999999999999999999"} {
+			_, err := Parse(amount)
+			So(errors.Is(err, ErrInvalidAmount), ShouldBeTrue)
+		}
+	})
+}
+
+func TestUnitFromPence(t *testing.T) {
+	Convey("Whole pence are kept exactly", t, func() {
+		pence, err := FromPence(1250)
+		So(err, ShouldBeNil)
+		So(pence, ShouldEqual, 1250)
+	})
+
+	Convey("Negative pence are rejected", t, func() {
+		_, err := FromPence(-1)
+		So(errors.Is(err, ErrInvalidAmount), ShouldBeTrue)
+	})
+}
+
+func TestUnitString(t *testing.T) {
+	Convey("Amounts are formatted as pounds with two decimal places", t, func() {
+		So(Pence(1250).String(), ShouldEqual, "12.50")
+		So(Pence(800).String(), ShouldEqual, "8.00")
+		So(Pence(5).String(), ShouldEqual, "0.05")
+		So(Pence(0).String(), ShouldEqual, "0.00")
+	})
+}
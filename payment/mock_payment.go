@@ -5,9 +5,9 @@
 package payment
 
 import (
+	context "context"
 	data "github.com/companieshouse/payment-reconciliation-consumer/data"
 	gomock "github.com/golang/mock/gomock"
-	http "net/http"
 	reflect "reflect"
 )
 
@@ -35,9 +35,9 @@ func (m *MockFetcher) EXPECT() *MockFetcherMockRecorder {
 }
 
 // GetPayment mocks base method
-func (m *MockFetcher) GetPayment(paymentAPIURL string, HTTPClient *http.Client, apiKey string) (data.PaymentResponse, int, error) {
+func (m *MockFetcher) GetPayment(ctx context.Context, paymentID string) (data.PaymentResponse, int, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetPayment", paymentAPIURL, HTTPClient, apiKey)
+	ret := m.ctrl.Call(m, "GetPayment", ctx, paymentID)
 	ret0, _ := ret[0].(data.PaymentResponse)
 	ret1, _ := ret[1].(int)
 	ret2, _ := ret[2].(error)
@@ -45,15 +45,15 @@ func (m *MockFetcher) GetPayment(paymentAPIURL string, HTTPClient *http.Client,
 }
 
 // GetPayment indicates an expected call of GetPayment
-func (mr *MockFetcherMockRecorder) GetPayment(paymentAPIURL, HTTPClient, apiKey interface{}) *gomock.Call {
+func (mr *MockFetcherMockRecorder) GetPayment(ctx, paymentID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPayment", reflect.TypeOf((*MockFetcher)(nil).GetPayment), paymentAPIURL, HTTPClient, apiKey)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPayment", reflect.TypeOf((*MockFetcher)(nil).GetPayment), ctx, paymentID)
 }
 
 // GetPaymentDetails mocks base method
-func (m *MockFetcher) GetPaymentDetails(paymentAPIURL string, HTTPClient *http.Client, apiKey string) (data.PaymentDetailsResponse, int, error) {
+func (m *MockFetcher) GetPaymentDetails(ctx context.Context, paymentID string) (data.PaymentDetailsResponse, int, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetPaymentDetails", paymentAPIURL, HTTPClient, apiKey)
+	ret := m.ctrl.Call(m, "GetPaymentDetails", ctx, paymentID)
 	ret0, _ := ret[0].(data.PaymentDetailsResponse)
 	ret1, _ := ret[1].(int)
 	ret2, _ := ret[2].(error)
@@ -61,23 +61,23 @@ func (m *MockFetcher) GetPaymentDetails(paymentAPIURL string, HTTPClient *http.C
 }
 
 // GetPaymentDetails indicates an expected call of GetPaymentDetails
-func (mr *MockFetcherMockRecorder) GetPaymentDetails(paymentAPIURL, HTTPClient, apiKey interface{}) *gomock.Call {
+func (mr *MockFetcherMockRecorder) GetPaymentDetails(ctx, paymentID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPaymentDetails", reflect.TypeOf((*MockFetcher)(nil).GetPaymentDetails), paymentAPIURL, HTTPClient, apiKey)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPaymentDetails", reflect.TypeOf((*MockFetcher)(nil).GetPaymentDetails), ctx, paymentID)
 }
 
-// GetLatestRefundStatus mocks base method
-func (m *MockFetcher) GetLatestRefundStatus(paymentAPIURL string, HTTPClient *http.Client, apiKey string) (*data.RefundResource, int, error) {
+// RefreshRefund mocks base method
+func (m *MockFetcher) RefreshRefund(ctx context.Context, paymentID, refundID string) (*data.RefundResource, int, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetLatestRefundStatus", paymentAPIURL, HTTPClient, apiKey)
+	ret := m.ctrl.Call(m, "RefreshRefund", ctx, paymentID, refundID)
 	ret0, _ := ret[0].(*data.RefundResource)
 	ret1, _ := ret[1].(int)
 	ret2, _ := ret[2].(error)
 	return ret0, ret1, ret2
 }
 
-// GetLatestRefundStatus indicates an expected call of GetLatestRefundStatus
-func (mr *MockFetcherMockRecorder) GetLatestRefundStatus(paymentAPIURL, HTTPClient, apiKey interface{}) *gomock.Call {
+// RefreshRefund indicates an expected call of RefreshRefund
+func (mr *MockFetcherMockRecorder) RefreshRefund(ctx, paymentID, refundID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLatestRefundStatus", reflect.TypeOf((*MockFetcher)(nil).GetLatestRefundStatus), paymentAPIURL, HTTPClient, apiKey)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefreshRefund", reflect.TypeOf((*MockFetcher)(nil).RefreshRefund), ctx, paymentID, refundID)
 }
@@ -1,13 +1,17 @@
 package payment
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/companieshouse/payment-reconciliation-consumer/data"
 	"github.com/companieshouse/payment-reconciliation-consumer/keys"
+	"github.com/companieshouse/payment-reconciliation-consumer/metrics"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/companieshouse/chs.go/log"
 )
@@ -15,6 +19,9 @@ import (
 // ErrResourceGone is a sentinel error used when payment resources have been intentionally removed
 var ErrResourceGone = errors.New(`The requested resource for payment is gone - status [410]`)
 
+// ErrInvalidID is returned when a payment or refund ID is empty or cannot be used as a single segment of a request path
+var ErrInvalidID = errors.New("invalid payment or refund id")
+
 // InvalidPaymentAPIResponse is returned when an invalid status is returned from the payments api
 type InvalidPaymentAPIResponse struct {
 	status int
@@ -25,128 +32,160 @@ func (e *InvalidPaymentAPIResponse) Error() string {
 	return fmt.Sprintf("invalid status returned from payments api: [%d]", e.status)
 }
 
-// Fetcher provides an interface by which to fetch payments data
-type Fetcher interface {
-	GetPayment(paymentAPIURL string, HTTPClient *http.Client, apiKey string) (data.PaymentResponse, int, error)
-	GetPaymentDetails(paymentAPIURL string, HTTPClient *http.Client, apiKey string) (data.PaymentDetailsResponse, int, error)
-	GetLatestRefundStatus(paymentAPIURL string, HTTPClient *http.Client, apiKey string) (*data.RefundResource, int, error)
+// Status returns the status returned from the payments api
+func (e *InvalidPaymentAPIResponse) Status() int {
+	return e.status
 }
 
-// Fetch implements the the Fetcher interface
-type Fetch struct{}
+// IsPermanent reports whether an error returned by a Fetcher will recur however often the request is retried. Client
+// errors other than 408 (Request Timeout) and 429 (Too Many Requests), and response bodies that cannot be decoded, are
+// permanent. Anything else, including network errors and timeouts, is treated as transient.
+func IsPermanent(err error) bool {
+	if errors.Is(err, ErrResourceGone) || errors.Is(err, ErrInvalidID) {
+		return true
+	}
 
-// New returns a new implementation of the Fetcher interface
-func New() *Fetch {
+	var invalidResponse *InvalidPaymentAPIResponse
+	if errors.As(err, &invalidResponse) {
+		return isPermanentStatus(invalidResponse.status)
+	}
 
-	return &Fetch{}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return errors.As(err, &syntaxErr) || errors.As(err, &typeErr)
 }
 
-// GetPayment executes a GET request to payment URL
-func (impl *Fetch) GetPayment(paymentAPIURL string, HTTPClient *http.Client, apiKey string) (data.PaymentResponse, int, error) {
-	var p data.PaymentResponse
-
-	req, err := http.NewRequest("GET", paymentAPIURL, nil)
-	if err != nil {
-		return p, 0, err
+func isPermanentStatus(status int) bool {
+	if status == http.StatusRequestTimeout || status == http.StatusTooManyRequests {
+		return false
 	}
+	return status >= 400 && status < 500
+}
 
-	req.SetBasicAuth(apiKey, "")
-	log.Trace("GET request to the payment api to get the payment session", log.Data{keys.Request: paymentAPIURL})
-
-	res, err := HTTPClient.Do(req)
-	if err != nil {
-		return p, 500, err
-	}
+// Fetcher provides an interface by which to fetch payments data
+type Fetcher interface {
+	GetPayment(ctx context.Context, paymentID string) (data.PaymentResponse, int, error)
+	GetPaymentDetails(ctx context.Context, paymentID string) (data.PaymentDetailsResponse, int, error)
+	RefreshRefund(ctx context.Context, paymentID, refundID string) (*data.RefundResource, int, error)
+}
 
-	defer res.Body.Close()
+// Client implements the Fetcher interface against the payments api at baseURL
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
 
-	if res.StatusCode != http.StatusOK {
-		if res.StatusCode == http.StatusGone {
-			return p, res.StatusCode, ErrResourceGone
-		}
-		return p, res.StatusCode, &InvalidPaymentAPIResponse{res.StatusCode}
+// NewClient returns a Client for the payments api at baseURL. Requests are authenticated with apiKey and abandoned if
+// they take longer than timeout.
+func NewClient(baseURL, apiKey string, timeout time.Duration) *Client {
+	return &Client{
+		baseURL:    baseURL,
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: timeout},
 	}
+}
 
-	body, err := ioutil.ReadAll(res.Body)
+// GetPayment executes a GET request for the payment session
+func (c *Client) GetPayment(ctx context.Context, paymentID string) (data.PaymentResponse, int, error) {
+	var p data.PaymentResponse
+
+	path, err := resourcePath("/payments/%s", paymentID)
 	if err != nil {
-		return p, res.StatusCode, err
+		return p, 0, err
 	}
 
-	if err := json.Unmarshal(body, &p); err != nil {
-		return p, res.StatusCode, err
+	log.Trace("GET request to the payment api to get the payment session", log.Data{keys.PaymentID: paymentID})
+	statusCode, err := c.do(ctx, "get_payment", http.MethodGet, path, &p)
+	if err != nil {
+		return p, statusCode, err
 	}
-	log.Info("Payment response body", log.Data{keys.Payment: p})
 
-	return p, res.StatusCode, nil
+	log.Info("Payment response body", log.Data{keys.Payment: p.LogView(false)})
+	return p, statusCode, nil
 }
 
-// GetPaymentDetails executes a GET request to payment Details URL
-func (impl *Fetch) GetPaymentDetails(paymentAPIURL string, HTTPClient *http.Client, apiKey string) (data.PaymentDetailsResponse, int, error) {
+// GetPaymentDetails executes a GET request for the payment details
+func (c *Client) GetPaymentDetails(ctx context.Context, paymentID string) (data.PaymentDetailsResponse, int, error) {
 	var p data.PaymentDetailsResponse
 
-	req, err := http.NewRequest("GET", paymentAPIURL, nil)
+	path, err := resourcePath("/private/payments/%s/payment-details", paymentID)
 	if err != nil {
 		return p, 0, err
 	}
 
-	req.SetBasicAuth(apiKey, "")
-	log.Trace("GET request to the payment api to get the payment details", log.Data{keys.Request: paymentAPIURL})
-
-	res, err := HTTPClient.Do(req)
+	log.Trace("GET request to the payment api to get the payment details", log.Data{keys.PaymentID: paymentID})
+	statusCode, err := c.do(ctx, "get_payment_details", http.MethodGet, path, &p)
 	if err != nil {
-		return p, 500, err
+		return p, statusCode, err
 	}
 
-	defer res.Body.Close()
+	log.Info("Payment details response body", log.Data{keys.PaymentDetails: p.LogView(false)})
+	return p, statusCode, nil
+}
 
-	if res.StatusCode != http.StatusOK {
-		return p, res.StatusCode, &InvalidPaymentAPIResponse{res.StatusCode}
-	}
+// RefreshRefund executes a PATCH request which makes the payments api update and return the latest refund information
+func (c *Client) RefreshRefund(ctx context.Context, paymentID, refundID string) (*data.RefundResource, int, error) {
+	var p data.RefundResource
 
-	body, err := ioutil.ReadAll(res.Body)
+	path, err := resourcePath("/payments/%s/refunds/%s", paymentID, refundID)
 	if err != nil {
-		return p, res.StatusCode, err
+		return &p, 0, err
 	}
 
-	if err := json.Unmarshal(body, &p); err != nil {
-		return p, res.StatusCode, err
+	log.Trace("PATCH request to the payment api to update and fetch latest refund information",
+		log.Data{keys.PaymentID: paymentID, keys.RefundID: refundID})
+	statusCode, err := c.do(ctx, "refresh_refund", http.MethodPatch, path, &p)
+	if err != nil {
+		return &p, statusCode, err
 	}
-	log.Info("Payment details response body", log.Data{keys.PaymentDetails: p})
 
-	return p, res.StatusCode, nil
+	log.Info("Refund response body", log.Data{keys.RefundDetails: p.LogView(false)})
+	return &p, statusCode, nil
 }
 
-func (impl *Fetch) GetLatestRefundStatus(refundEndpointUrl string, HTTPClient *http.Client, apiKey string) (*data.RefundResource, int, error) {
-	var p data.RefundResource
+// resourcePath returns path with each %s replaced by an ID, escaped so that it is always a single path segment and
+// cannot address a different resource
+func resourcePath(path string, ids ...string) (string, error) {
+	segments := make([]interface{}, len(ids))
+	for i, id := range ids {
+		if id == "" || id == "." || id == ".." {
+			return "", fmt.Errorf("%w: [%s]", ErrInvalidID, id)
+		}
+		segments[i] = url.PathEscape(id)
+	}
+	return fmt.Sprintf(path, segments...), nil
+}
+
+// do sends a request for path to the payments api and decodes the response body into v. The latency of the request is
+// recorded against operation.
+func (c *Client) do(ctx context.Context, operation, method, path string, v interface{}) (int, error) {
+	defer metrics.ObserveDuration(metrics.PaymentsAPIDuration, operation, time.Now())
 
-	req, err := http.NewRequest("PATCH", refundEndpointUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, nil)
 	if err != nil {
-		return &p, 0, err
+		return 0, err
 	}
 
-	req.SetBasicAuth(apiKey, "")
-	log.Trace("PATCH request to the payment api to update and fetch latest refund information", log.Data{keys.Request: refundEndpointUrl})
+	req.SetBasicAuth(c.apiKey, "")
 
-	res, err := HTTPClient.Do(req)
+	res, err := c.httpClient.Do(req)
 	if err != nil {
-		return &p, 500, err
+		return 500, err
 	}
-
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return &p, res.StatusCode, &InvalidPaymentAPIResponse{res.StatusCode}
+		if res.StatusCode == http.StatusGone {
+			return res.StatusCode, ErrResourceGone
+		}
+		return res.StatusCode, &InvalidPaymentAPIResponse{res.StatusCode}
 	}
 
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return &p, res.StatusCode, err
-	}
-
-	if err := json.Unmarshal(body, &p); err != nil {
-		return &p, res.StatusCode, err
+		return res.StatusCode, err
 	}
-	log.Info("Refund response body", log.Data{keys.RefundDetails: p})
 
-	return &p, res.StatusCode, nil
+	return res.StatusCode, json.Unmarshal(body, v)
 }
@@ -1,6 +1,12 @@
 package payment
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
 	"github.com/companieshouse/payment-reconciliation-consumer/testutil"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
@@ -62,34 +68,40 @@ const refundStatusTestData = `{
     "payment_external_refund_url": "http://test.url"
 }`
 
-func TestUnitGetPayment(t *testing.T) {
+const paymentID = "paymentID"
+const refundID = "refundID"
+
+func newTestClient(baseURL string, httpClient *http.Client) *Client {
+	return &Client{baseURL: baseURL, httpClient: httpClient}
+}
 
-	p := Fetch{}
+func TestUnitGetPayment(t *testing.T) {
 
 	Convey("test successful get request of payment ", t, func() {
-		b, statusCode, err := p.GetPayment("http://test-url.com", testutil.CreateMockClient(true, 200, paymentTestData), "")
+		b, statusCode, err := newTestClient("http://test-url.com", testutil.CreateMockClient(true, 200, paymentTestData)).GetPayment(context.Background(), paymentID)
 		So(err, ShouldBeNil)
 		So(statusCode, ShouldEqual, 200)
 		So(b, ShouldNotBeEmpty)
 	})
 
 	Convey("test error returned when client throws error", t, func() {
-		_, statusCode, err := p.GetPayment("test-url.com", testutil.CreateMockClient(false, 500, paymentTestData), "")
+		_, statusCode, err := newTestClient("test-url.com", testutil.CreateMockClient(false, 500, paymentTestData)).GetPayment(context.Background(), paymentID)
 		So(err, ShouldNotBeNil)
 		So(statusCode, ShouldEqual, 500)
 	})
 
 	Convey("test error returned when invalid http status returned", t, func() {
-		_, statusCode, err := p.GetPayment("http://test-url.com", testutil.CreateMockClient(false, 404, paymentTestData), "")
+		_, statusCode, err := newTestClient("http://test-url.com", testutil.CreateMockClient(false, 404, paymentTestData)).GetPayment(context.Background(), paymentID)
 		So(err, ShouldNotBeNil)
 		So(statusCode, ShouldEqual, 404)
 	})
 
 	Convey("test successful get request for certified copies order payment session contains expected costs", t, func() {
-		b, statusCode, err := p.GetPayment("http://test-url.com",
+		c := newTestClient("http://test-url.com",
 			testutil.CreateMockClient(true,
 				200,
-				testutil.CertifiedCopiesOrderGetPaymentSessionResponse), "")
+				testutil.CertifiedCopiesOrderGetPaymentSessionResponse))
+		b, statusCode, err := c.GetPayment(context.Background(), paymentID)
 		So(err, ShouldBeNil)
 		So(statusCode, ShouldEqual, 200)
 		So(b, ShouldNotBeEmpty)
@@ -103,13 +115,9 @@ func TestUnitGetPayment(t *testing.T) {
 
 func TestUnitGetDetailsPayment(t *testing.T) {
 
-	p := Fetch{}
-
 	Convey("test successful get request of payment ", t, func() {
 		b, statusCode, err :=
-			p.GetPaymentDetails("http://test-url.com",
-				testutil.CreateMockClient(true, 200, paymentDetailsTestData),
-				"")
+			newTestClient("http://test-url.com", testutil.CreateMockClient(true, 200, paymentDetailsTestData)).GetPaymentDetails(context.Background(), paymentID)
 		So(err, ShouldBeNil)
 		So(statusCode, ShouldEqual, 200)
 		So(b, ShouldNotBeEmpty)
@@ -117,18 +125,14 @@ func TestUnitGetDetailsPayment(t *testing.T) {
 
 	Convey("test error returned when client throws error", t, func() {
 		_, statusCode, err :=
-			p.GetPaymentDetails("test-url.com",
-				testutil.CreateMockClient(false, 500, paymentDetailsTestData),
-				"")
+			newTestClient("test-url.com", testutil.CreateMockClient(false, 500, paymentDetailsTestData)).GetPaymentDetails(context.Background(), paymentID)
 		So(err, ShouldNotBeNil)
 		So(statusCode, ShouldEqual, 500)
 	})
 
 	Convey("test error returned when invalid http status returned", t, func() {
 		_, statusCode, err :=
-			p.GetPaymentDetails("http://test-url.com",
-				testutil.CreateMockClient(false, 404, paymentDetailsTestData),
-				"")
+			newTestClient("http://test-url.com", testutil.CreateMockClient(false, 404, paymentDetailsTestData)).GetPaymentDetails(context.Background(), paymentID)
 		So(err, ShouldNotBeNil)
 		So(statusCode, ShouldEqual, 404)
 	})
@@ -136,24 +140,150 @@ func TestUnitGetDetailsPayment(t *testing.T) {
 
 func TestUnitGetLatestRefundStatus(t *testing.T) {
 
-	p := Fetch{}
-
 	Convey("test successful get refund details ", t, func() {
-		b, statusCode, err := p.GetLatestRefundStatus("http://test-url.com", testutil.CreateMockClient(true, 200, refundStatusTestData), "")
+		b, statusCode, err := newTestClient("http://test-url.com", testutil.CreateMockClient(true, 200, refundStatusTestData)).RefreshRefund(context.Background(), paymentID, refundID)
 		So(err, ShouldBeNil)
 		So(statusCode, ShouldEqual, 200)
 		So(b, ShouldNotBeEmpty)
 	})
 
 	Convey("test error returned when client throws error", t, func() {
-		_, statusCode, err := p.GetLatestRefundStatus("test-url.com", testutil.CreateMockClient(false, 500, refundStatusTestData), "")
+		_, statusCode, err := newTestClient("test-url.com", testutil.CreateMockClient(false, 500, refundStatusTestData)).RefreshRefund(context.Background(), paymentID, refundID)
 		So(err, ShouldNotBeNil)
 		So(statusCode, ShouldEqual, 500)
 	})
 
 	Convey("test error returned when invalid http status returned", t, func() {
-		_, statusCode, err := p.GetLatestRefundStatus("http://test-url.com", testutil.CreateMockClient(false, 404, refundStatusTestData), "")
+		_, statusCode, err := newTestClient("http://test-url.com", testutil.CreateMockClient(false, 404, refundStatusTestData)).RefreshRefund(context.Background(), paymentID, refundID)
 		So(err, ShouldNotBeNil)
 		So(statusCode, ShouldEqual, 404)
 	})
 }
+
+func TestUnitClient(t *testing.T) {
+
+	Convey("requests are built from the base url and authenticated with the api key", t, func() {
+		var method, path, user string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			method, path = req.Method, req.URL.Path
+			user, _, _ = req.BasicAuth()
+			w.Write([]byte(refundStatusTestData))
+		}))
+		defer server.Close()
+
+		c := NewClient(server.URL, "apiKey", time.Second)
+
+		c.GetPayment(context.Background(), paymentID)
+		So(method, ShouldEqual, http.MethodGet)
+		So(path, ShouldEqual, "/payments/"+paymentID)
+		So(user, ShouldEqual, "apiKey")
+
+		c.GetPaymentDetails(context.Background(), paymentID)
+		So(method, ShouldEqual, http.MethodGet)
+		So(path, ShouldEqual, "/private/payments/"+paymentID+"/payment-details")
+
+		c.RefreshRefund(context.Background(), paymentID, refundID)
+		So(method, ShouldEqual, http.MethodPatch)
+		So(path, ShouldEqual, "/payments/"+paymentID+"/refunds/"+refundID)
+	})
+
+	Convey("ids are escaped so that they cannot address a different resource", t, func() {
+		var path string
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			requests++
+			path = req.URL.EscapedPath()
+			w.Write([]byte(refundStatusTestData))
+		}))
+		defer server.Close()
+
+		c := NewClient(server.URL, "apiKey", time.Second)
+
+		c.GetPayment(context.Background(), "../private/x?y=z")
+		So(path, ShouldEqual, "/payments/..%2Fprivate%2Fx%3Fy=z")
+
+		c.RefreshRefund(context.Background(), paymentID, "a/b")
+		So(path, ShouldEqual, "/payments/"+paymentID+"/refunds/a%2Fb")
+
+		for _, id := range []string{"", ".", ".."} {
+			_, _, err := c.GetPayment(context.Background(), id)
+			So(errors.Is(err, ErrInvalidID), ShouldBeTrue)
+			So(IsPermanent(err), ShouldBeTrue)
+
+			_, _, err = c.GetPaymentDetails(context.Background(), id)
+			So(errors.Is(err, ErrInvalidID), ShouldBeTrue)
+
+			_, _, err = c.RefreshRefund(context.Background(), paymentID, id)
+			So(errors.Is(err, ErrInvalidID), ShouldBeTrue)
+		}
+		So(requests, ShouldEqual, 2)
+	})
+
+	Convey("a request is abandoned when it takes longer than the timeout", t, func() {
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			<-release
+		}))
+		defer server.Close()
+		defer close(release)
+
+		_, _, err := NewClient(server.URL, "", 10*time.Millisecond).GetPayment(context.Background(), paymentID)
+		So(err, ShouldNotBeNil)
+		So(IsPermanent(err), ShouldBeFalse)
+	})
+
+	Convey("a request is abandoned when its context is cancelled", t, func() {
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			<-release
+		}))
+		defer server.Close()
+		defer close(release)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+		}()
+
+		_, _, err := NewClient(server.URL, "", time.Minute).GetPayment(ctx, paymentID)
+		So(errors.Is(err, context.Canceled), ShouldBeTrue)
+	})
+}
+
+func TestUnitIsPermanent(t *testing.T) {
+
+	Convey("client errors other than request timeout and too many requests are permanent", t, func() {
+		for _, status := range []int{400, 401, 403, 404} {
+			_, _, err := newTestClient("http://test-url.com", testutil.CreateMockClient(false, status, paymentTestData)).GetPayment(context.Background(), paymentID)
+			So(IsPermanent(err), ShouldBeTrue)
+		}
+	})
+
+	Convey("a gone resource is permanent", t, func() {
+		So(IsPermanent(ErrResourceGone), ShouldBeTrue)
+	})
+
+	Convey("request timeouts, rate limiting and server errors are transient", t, func() {
+		for _, status := range []int{408, 429, 500, 502, 503} {
+			_, _, err := newTestClient("http://test-url.com", testutil.CreateMockClient(false, status, paymentTestData)).GetPayment(context.Background(), paymentID)
+			So(IsPermanent(err), ShouldBeFalse)
+		}
+	})
+
+	Convey("errors from the http client are transient", t, func() {
+		_, _, err := newTestClient("test-url.com", testutil.CreateMockClient(false, 500, paymentTestData)).GetPayment(context.Background(), paymentID)
+		So(err, ShouldNotBeNil)
+		So(IsPermanent(err), ShouldBeFalse)
+	})
+
+	Convey("a response body that cannot be decoded is permanent", t, func() {
+		_, _, err := newTestClient("http://test-url.com", testutil.CreateMockClient(true, 200, `{"payment_status": 1}`)).GetPaymentDetails(context.Background(), paymentID)
+		So(err, ShouldNotBeNil)
+		So(IsPermanent(err), ShouldBeTrue)
+
+		_, _, err = newTestClient("http://test-url.com", testutil.CreateMockClient(true, 200, `not json`)).GetPaymentDetails(context.Background(), paymentID)
+		So(err, ShouldNotBeNil)
+		So(IsPermanent(err), ShouldBeTrue)
+	})
+}
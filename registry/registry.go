@@ -0,0 +1,103 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when the schema registry does not hold the schema requested
+	ErrNotFound = errors.New("schema not found in the schema registry")
+	// ErrUnavailable is returned when the schema registry cannot be reached or fails to respond with a schema, in
+	// which case the request may succeed if it is retried
+	ErrUnavailable = errors.New("schema registry unavailable")
+)
+
+// Client fetches schemas from a Confluent schema registry. A schema ID always refers to the same schema, so schemas
+// fetched by ID are cached for the life of the client.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	schemas map[int]string
+}
+
+// NewClient returns a Client for the schema registry at baseURL. Requests are abandoned if they take longer than
+// timeout.
+func NewClient(baseURL string, timeout time.Duration) *Client {
+	return &Client{
+		baseURL:    baseURL,
+		httpClient: &http.Client{Timeout: timeout},
+		schemas:    map[int]string{},
+	}
+}
+
+// SchemaByID returns the schema registered with id
+func (c *Client) SchemaByID(ctx context.Context, id int) (string, error) {
+	c.mu.Lock()
+	schema, ok := c.schemas[id]
+	c.mu.Unlock()
+	if ok {
+		return schema, nil
+	}
+
+	schema, err := c.get(ctx, "/schemas/ids/"+strconv.Itoa(id))
+	if err != nil {
+		return "", fmt.Errorf("error fetching schema [%d]: %w", id, err)
+	}
+
+	c.mu.Lock()
+	c.schemas[id] = schema
+	c.mu.Unlock()
+	return schema, nil
+}
+
+// LatestSchema returns the latest version of the schema registered under subject. Versions change, so the schema is
+// never cached.
+func (c *Client) LatestSchema(ctx context.Context, subject string) (string, error) {
+	schema, err := c.get(ctx, "/subjects/"+url.PathEscape(subject)+"/versions/latest")
+	if err != nil {
+		return "", fmt.Errorf("error fetching latest %s schema: %w", subject, err)
+	}
+	return schema, nil
+}
+
+func (c *Client) get(ctx context.Context, path string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrUnavailable, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return "", ErrNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: status [%d]", ErrUnavailable, res.StatusCode)
+	}
+
+	var body struct {
+		Schema string `json:"schema"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrUnavailable, err)
+	}
+	if body.Schema == "" {
+		return "", fmt.Errorf("%w: response has no schema", ErrUnavailable)
+	}
+	return body.Schema, nil
+}
@@ -0,0 +1,104 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitSchemaByID(t *testing.T) {
+
+	Convey("Given a schema registry", t, func() {
+		requests := 0
+		status := http.StatusOK
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if r.URL.Path != "/schemas/ids/7" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(`{"schema":"{\"type\":\"string\"}"}`))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.URL, time.Second)
+
+		Convey("Then a schema is fetched by its ID, and only fetched once", func() {
+			schema, err := client.SchemaByID(context.Background(), 7)
+			So(err, ShouldBeNil)
+			So(schema, ShouldEqual, `{"type":"string"}`)
+
+			schema, err = client.SchemaByID(context.Background(), 7)
+			So(err, ShouldBeNil)
+			So(schema, ShouldEqual, `{"type":"string"}`)
+			So(requests, ShouldEqual, 1)
+		})
+
+		Convey("Then an unknown schema ID is not found", func() {
+			_, err := client.SchemaByID(context.Background(), 8)
+
+			So(errors.Is(err, ErrNotFound), ShouldBeTrue)
+		})
+
+		Convey("Then an error response means the registry is unavailable, and is not cached", func() {
+			status = http.StatusServiceUnavailable
+			_, err := client.SchemaByID(context.Background(), 7)
+			So(errors.Is(err, ErrUnavailable), ShouldBeTrue)
+
+			status = http.StatusOK
+			_, err = client.SchemaByID(context.Background(), 7)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Given a schema registry which cannot be reached", t, func() {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		_, err := NewClient(server.URL, time.Second).SchemaByID(context.Background(), 7)
+
+		Convey("Then it is unavailable", func() {
+			So(errors.Is(err, ErrUnavailable), ShouldBeTrue)
+		})
+	})
+}
+
+func TestUnitLatestSchema(t *testing.T) {
+
+	Convey("Given a schema registry", t, func() {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if r.URL.Path != "/subjects/payment-processed/versions/latest" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(`{"schema":"{\"type\":\"string\"}"}`))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.URL, time.Second)
+
+		Convey("Then the latest schema of a subject is fetched every time", func() {
+			schema, err := client.LatestSchema(context.Background(), "payment-processed")
+			So(err, ShouldBeNil)
+			So(schema, ShouldEqual, `{"type":"string"}`)
+
+			_, err = client.LatestSchema(context.Background(), "payment-processed")
+			So(err, ShouldBeNil)
+			So(requests, ShouldEqual, 2)
+		})
+
+		Convey("Then an unknown subject is not found", func() {
+			_, err := client.LatestSchema(context.Background(), "unknown")
+
+			So(errors.Is(err, ErrNotFound), ShouldBeTrue)
+		})
+	})
+}
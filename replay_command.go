@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/payment-reconciliation-consumer/config"
+	"github.com/companieshouse/payment-reconciliation-consumer/service"
+)
+
+// runReplay reconciles the payment-processed events captured in a JSON lines file through the same pipeline as the
+// consumer, without Kafka or the schema registry, and writes a summary of the outcomes to standard output. It is
+// intended for investigating problems locally, against a stub payments api and a local database.
+func runReplay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	input := flags.String("input", "", "JSON lines file of payment-processed events, each with a payment_resource_id and optionally a refund_id and attempt")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *input == "" {
+		return errors.New("an -input file is required")
+	}
+
+	cfg, err := config.Get()
+	if err != nil {
+		return fmt.Errorf("error configuring service: %s", err)
+	}
+
+	svc, err := service.NewReplay(cfg, *input)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	log.Info("starting replay", log.Data{"input": *input, "dry_run": cfg.DryRun})
+	summary, err := svc.Replay(ctx)
+
+	// The messages replayed before any failure are still summarised, as their records may have been written
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if encodeErr := encoder.Encode(summary); encodeErr != nil {
+		return encodeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error replaying %s: %s", *input, err)
+	}
+
+	log.Info("replay complete: "+summary.String(), log.Data{"input": *input})
+	return nil
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/payment-reconciliation-consumer/keys"
+)
+
+const (
+	// ZookeeperCoordinator selects the legacy cluster consumer, whose group is coordinated through ZooKeeper
+	ZookeeperCoordinator = "zookeeper"
+	// BrokerCoordinator selects a Kafka consumer group, coordinated by the brokers
+	BrokerCoordinator = "broker"
+
+	// consumeRetryInterval is how long to wait before rejoining the group after failing to consume from it
+	consumeRetryInterval = 5 * time.Second
+)
+
+// BrokerGroupConsumer is a MessageSource which joins a consumer group coordinated by the Kafka brokers rather than by
+// ZooKeeper. Messages are handed over one at a time, and each claim waits for its message to be acknowledged before
+// delivering the next, so that a message being processed when the group rebalances is acknowledged before its
+// partition is given up. Acknowledged offsets are committed by the group at its commit interval and whenever the group
+// rebalances.
+type BrokerGroupConsumer struct {
+	group    sarama.ConsumerGroup
+	messages chan *sarama.ConsumerMessage
+	marked   chan struct{}
+	errors   chan error
+	cancel   context.CancelFunc
+	closing  chan struct{}
+	done     chan struct{}
+	once     sync.Once
+
+	mu      sync.Mutex
+	session sarama.ConsumerGroupSession
+}
+
+// NewBrokerGroupConsumer joins the consumer group groupName and starts consuming topics from brokers running
+// kafkaVersion. A group consuming a topic for the first time starts from the oldest message.
+func NewBrokerGroupConsumer(brokers []string, kafkaVersion, groupName string, topics []string) (*BrokerGroupConsumer, error) {
+	version, err := sarama.ParseKafkaVersion(kafkaVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := sarama.NewConfig()
+	cfg.Version = version
+	cfg.Consumer.Return.Errors = true
+	cfg.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	group, err := sarama.NewConsumerGroup(brokers, groupName, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &BrokerGroupConsumer{
+		group:    group,
+		messages: make(chan *sarama.ConsumerMessage),
+		marked:   make(chan struct{}),
+		errors:   make(chan error),
+		cancel:   cancel,
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go c.forwardErrors()
+	go c.consume(ctx, topics)
+	return c, nil
+}
+
+// consume rejoins the group each time it rebalances, until the consumer is closed
+func (c *BrokerGroupConsumer) consume(ctx context.Context, topics []string) {
+	defer close(c.done)
+	for {
+		err := c.group.Consume(ctx, topics, c)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			c.sendError(fmt.Errorf("error consuming from consumer group: %w", err))
+			select {
+			case <-time.After(consumeRetryInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (c *BrokerGroupConsumer) forwardErrors() {
+	for err := range c.group.Errors() {
+		c.sendError(err)
+	}
+}
+
+func (c *BrokerGroupConsumer) sendError(err error) {
+	select {
+	case c.errors <- err:
+	case <-c.closing:
+	}
+}
+
+// Setup is called by the group when partitions have been assigned to the consumer
+func (c *BrokerGroupConsumer) Setup(session sarama.ConsumerGroupSession) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.session = session
+
+	log.Info("consumer group partitions assigned", log.Data{"member_id": session.MemberID(),
+		"generation_id": session.GenerationID(), "claims": session.Claims()})
+	return nil
+}
+
+// Cleanup is called by the group once every claim has finished, before the offsets marked are committed and the
+// partitions given up
+func (c *BrokerGroupConsumer) Cleanup(session sarama.ConsumerGroupSession) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.session = nil
+
+	log.Info("consumer group partitions revoked", log.Data{"member_id": session.MemberID(),
+		"generation_id": session.GenerationID()})
+	return nil
+}
+
+// ConsumeClaim hands each message of the claimed partition over to the service, until the group rebalances or the
+// consumer is closed
+func (c *BrokerGroupConsumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			select {
+			case c.messages <- message:
+			case <-session.Context().Done():
+				return nil
+			}
+			// The message is being processed, so wait for it to be acknowledged even if the group is rebalancing
+			select {
+			case <-c.marked:
+			case <-c.closing:
+				return nil
+			}
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// Messages returns the messages delivered by the group
+func (c *BrokerGroupConsumer) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+// Errors returns the errors reported by the group
+func (c *BrokerGroupConsumer) Errors() <-chan error {
+	return c.errors
+}
+
+// Ack marks msg as processed, allowing its claim to deliver the next message. The offset is committed by the group at
+// its commit interval and whenever it rebalances or is closed.
+func (c *BrokerGroupConsumer) Ack(msg *sarama.ConsumerMessage) error {
+	c.mu.Lock()
+	if c.session != nil {
+		c.session.MarkMessage(msg, "")
+	} else {
+		log.Info("no consumer group session to mark message in, it will be redelivered", log.Data{keys.Offset: msg.Offset})
+	}
+	c.mu.Unlock()
+
+	select {
+	case c.marked <- struct{}{}:
+	case <-c.closing:
+	}
+	return nil
+}
+
+// Close leaves the consumer group, committing the offsets acknowledged
+func (c *BrokerGroupConsumer) Close() error {
+	var err error
+	c.once.Do(func() {
+		close(c.closing)
+		c.cancel()
+		<-c.done
+		err = c.group.Close()
+	})
+	return err
+}
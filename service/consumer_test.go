@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// mockSession is a consumer group session which records the messages marked
+type mockSession struct {
+	sarama.ConsumerGroupSession
+	ctx    context.Context
+	marked chan *sarama.ConsumerMessage
+}
+
+func (s *mockSession) Context() context.Context { return s.ctx }
+
+func (s *mockSession) MarkMessage(msg *sarama.ConsumerMessage, _ string) { s.marked <- msg }
+
+func (s *mockSession) MemberID() string { return "member" }
+
+func (s *mockSession) GenerationID() int32 { return 1 }
+
+func (s *mockSession) Claims() map[string][]int32 { return map[string][]int32{"test": {0}} }
+
+// mockClaim is a claim on a single partition
+type mockClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *mockClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func newTestBrokerGroupConsumer() *BrokerGroupConsumer {
+	return &BrokerGroupConsumer{
+		messages: make(chan *sarama.ConsumerMessage),
+		marked:   make(chan struct{}),
+		errors:   make(chan error),
+		closing:  make(chan struct{}),
+	}
+}
+
+func TestUnitBrokerGroupConsumer(t *testing.T) {
+
+	Convey("Given a broker group consumer which has been assigned a partition", t, func() {
+		c := newTestBrokerGroupConsumer()
+		ctx, endSession := context.WithCancel(context.Background())
+		defer endSession()
+		session := &mockSession{ctx: ctx, marked: make(chan *sarama.ConsumerMessage, 2)}
+		claim := &mockClaim{messages: make(chan *sarama.ConsumerMessage, 2)}
+		So(c.Setup(session), ShouldBeNil)
+
+		claimed := make(chan error, 1)
+		go func() { claimed <- c.ConsumeClaim(session, claim) }()
+
+		first := &sarama.ConsumerMessage{Offset: 1}
+		second := &sarama.ConsumerMessage{Offset: 2}
+		claim.messages <- first
+		claim.messages <- second
+
+		Convey("Then messages are delivered one at a time, each once the previous message has been acknowledged", func() {
+			So(<-c.Messages(), ShouldEqual, first)
+			So(receivesSoon(c.Messages()), ShouldBeFalse)
+
+			So(c.Ack(first), ShouldBeNil)
+			So(<-session.marked, ShouldEqual, first)
+			So(<-c.Messages(), ShouldEqual, second)
+		})
+
+		Convey("Then a message being processed when the group rebalances is acknowledged before the partition is given up", func() {
+			So(<-c.Messages(), ShouldEqual, first)
+			endSession()
+
+			So(receivesSoon(claimed), ShouldBeFalse)
+
+			So(c.Ack(first), ShouldBeNil)
+			So(<-claimed, ShouldBeNil)
+			So(<-session.marked, ShouldEqual, first)
+			So(c.Cleanup(session), ShouldBeNil)
+		})
+
+		Convey("Then closing the consumer stops it waiting for the message being processed", func() {
+			So(<-c.Messages(), ShouldEqual, first)
+			close(c.closing)
+
+			So(<-claimed, ShouldBeNil)
+		})
+	})
+}
+
+// receivesSoon reports whether a value is received from ch within a short time
+func receivesSoon[T any](ch <-chan T) bool {
+	select {
+	case <-ch:
+		return true
+	case <-time.After(50 * time.Millisecond):
+		return false
+	}
+}
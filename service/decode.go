@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/companieshouse/chs.go/avro"
+	"github.com/companieshouse/payment-reconciliation-consumer/data"
+	"github.com/companieshouse/payment-reconciliation-consumer/registry"
+	hamba "github.com/hamba/avro/v2"
+)
+
+const (
+	// magicByte starts every message in the Confluent wire format. It is followed by the 4 byte ID of the schema the
+	// message was written with, and then the message encoded with that schema.
+	magicByte            = 0
+	wireFormatHeaderSize = 5
+)
+
+// AvroDecoder returns a function decoding payment-processed messages encoded with the avro schema
+func AvroDecoder(schema string) func(value []byte, pp *data.PaymentProcessed) error {
+	paymentProcessedSchema := &avro.Schema{Definition: schema}
+	return func(value []byte, pp *data.PaymentProcessed) error {
+		return paymentProcessedSchema.Unmarshal(value, pp)
+	}
+}
+
+// DecodeJSON decodes a JSON encoded payment-processed message, as read by a JSONLinesSource
+func DecodeJSON(value []byte, pp *data.PaymentProcessed) error {
+	if err := json.Unmarshal(value, pp); err != nil {
+		return err
+	}
+	if pp.ResourceURI == "" {
+		return errors.New("payment-processed message has no payment_resource_id")
+	}
+	return nil
+}
+
+// SchemaFetcher fetches the schemas that messages were written with from the schema registry
+type SchemaFetcher interface {
+	SchemaByID(ctx context.Context, id int) (string, error)
+}
+
+// WireFormatDecoder decodes payment-processed messages in the Confluent wire format. Each message is decoded with the
+// schema it was written with, fetched from the registry by the ID in the message, and resolved against the reader
+// schema so that messages written with any compatible version of the schema can be read. Messages without the wire
+// format header, and those whose schema ID is not in the registry, are decoded with the reader schema alone.
+type WireFormatDecoder struct {
+	reader   hamba.Schema
+	registry SchemaFetcher
+	unframed func(value []byte, pp *data.PaymentProcessed) error
+
+	mu       sync.Mutex
+	resolved map[int]hamba.Schema
+}
+
+// NewWireFormatDecoder returns a WireFormatDecoder reading messages with readerSchema, and fetching the schemas they
+// were written with from registry
+func NewWireFormatDecoder(readerSchema string, registry SchemaFetcher) (*WireFormatDecoder, error) {
+	reader, err := hamba.Parse(readerSchema)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing reader schema: %s", err)
+	}
+	return &WireFormatDecoder{
+		reader:   reader,
+		registry: registry,
+		unframed: AvroDecoder(readerSchema),
+		resolved: map[int]hamba.Schema{},
+	}, nil
+}
+
+// Decode decodes value into pp. An error wrapping registry.ErrUnavailable is returned when the schema the message was
+// written with cannot be fetched, in which case decoding may succeed if it is retried.
+func (d *WireFormatDecoder) Decode(value []byte, pp *data.PaymentProcessed) error {
+	if len(value) < wireFormatHeaderSize || value[0] != magicByte {
+		return d.unframed(value, pp)
+	}
+
+	id := int(binary.BigEndian.Uint32(value[1:wireFormatHeaderSize]))
+	schema, err := d.schema(id)
+	if errors.Is(err, registry.ErrNotFound) {
+		// The first byte of a message without the header can happen to match the magic byte
+		if unframedErr := d.unframed(value, pp); unframedErr != nil {
+			return fmt.Errorf("%w, and the message cannot be decoded without it: %s", err, unframedErr)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// The message is decoded into a map, so that fields can be read whether or not the reader schema makes them
+	// nullable
+	var record map[string]any
+	if err := hamba.Unmarshal(schema, value[wireFormatHeaderSize:], &record); err != nil {
+		return fmt.Errorf("error decoding message written with schema [%d]: %s", id, err)
+	}
+
+	*pp = data.PaymentProcessed{}
+	pp.ResourceURI, _ = record["payment_resource_id"].(string)
+	pp.RefundId, _ = record["refund_id"].(string)
+	if attempt, ok := record["attempt"].(int); ok {
+		pp.Attempt = int32(attempt)
+	}
+	return nil
+}
+
+// schema returns the schema for decoding messages written with schema id, fetching and resolving it against the
+// reader schema the first time it is seen
+func (d *WireFormatDecoder) schema(id int) (hamba.Schema, error) {
+	d.mu.Lock()
+	schema, ok := d.resolved[id]
+	d.mu.Unlock()
+	if ok {
+		return schema, nil
+	}
+
+	definition, err := d.registry.SchemaByID(context.Background(), id)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := hamba.Parse(definition)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing schema [%d]: %s", id, err)
+	}
+	schema, err = hamba.NewSchemaCompatibility().Resolve(d.reader, writer)
+	if err != nil {
+		return nil, fmt.Errorf("schema [%d] is not compatible with the reader schema: %s", id, err)
+	}
+
+	d.mu.Lock()
+	d.resolved[id] = schema
+	d.mu.Unlock()
+	return schema, nil
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/payment-reconciliation-consumer/data"
+	"github.com/companieshouse/payment-reconciliation-consumer/registry"
+	hamba "github.com/hamba/avro/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// A later version of the schema, with a field the reader schema does not have
+const extendedSchema = `{"type":"record","name":"payment_processed","namespace":"payments","fields":[
+	{"name":"payment_resource_id","type":"string"},
+	{"name":"refund_id","type":"string"},
+	{"name":"attempt","type":"int"},
+	{"name":"processed_at","type":["null","string"],"default":null}]}`
+
+// A schema without the refund_id the reader schema requires
+const incompatibleSchema = `{"type":"record","name":"payment_processed","namespace":"payments","fields":[
+	{"name":"payment_resource_id","type":"string"}]}`
+
+// mockSchemaFetcher serves schemas by ID, or fails with err, and counts the schemas fetched
+type mockSchemaFetcher struct {
+	schemas map[int]string
+	err     error
+	fetched int
+}
+
+func (f *mockSchemaFetcher) SchemaByID(_ context.Context, id int) (string, error) {
+	f.fetched++
+	if f.err != nil {
+		return "", f.err
+	}
+	schema, ok := f.schemas[id]
+	if !ok {
+		return "", fmt.Errorf("error fetching schema [%d]: %w", id, registry.ErrNotFound)
+	}
+	return schema, nil
+}
+
+// wireFormat encodes record with schema, framed with the ID of the schema
+func wireFormat(id int, schema string, record map[string]any) []byte {
+	value, err := hamba.Marshal(hamba.MustParse(schema), record)
+	So(err, ShouldBeNil)
+
+	header := make([]byte, wireFormatHeaderSize)
+	binary.BigEndian.PutUint32(header[1:], uint32(id))
+	return append(header, value...)
+}
+
+func TestUnitWireFormatDecoder(t *testing.T) {
+
+	Convey("Given a wire format decoder", t, func() {
+		fetcher := &mockSchemaFetcher{schemas: map[int]string{1: extendedSchema, 2: incompatibleSchema}}
+		decoder, err := NewWireFormatDecoder(getDefaultSchema(), fetcher)
+		So(err, ShouldBeNil)
+
+		var pp data.PaymentProcessed
+
+		Convey("When a message written with a later version of the schema is decoded", func() {
+			value := wireFormat(1, extendedSchema, map[string]any{"payment_resource_id": "P1", "refund_id": "R1",
+				"attempt": 2, "processed_at": "2024-01-01"})
+			err := decoder.Decode(value, &pp)
+
+			Convey("Then the fields in the reader schema are decoded and the others ignored", func() {
+				So(err, ShouldBeNil)
+				So(pp, ShouldResemble, data.PaymentProcessed{ResourceURI: "P1", RefundId: "R1"})
+			})
+
+			Convey("Then the writer schema is only fetched once", func() {
+				So(decoder.Decode(value, &pp), ShouldBeNil)
+				So(fetcher.fetched, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When a message written with an incompatible schema is decoded", func() {
+			err := decoder.Decode(wireFormat(2, incompatibleSchema, map[string]any{"payment_resource_id": "P1"}), &pp)
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+				So(errors.Is(err, registry.ErrUnavailable), ShouldBeFalse)
+			})
+		})
+
+		Convey("When a message without the wire format header is decoded", func() {
+			value, err := MockSchema.Marshal(data.PaymentProcessed{ResourceURI: "P1", RefundId: "R1"})
+			So(err, ShouldBeNil)
+			err = decoder.Decode(value, &pp)
+
+			Convey("Then it is decoded with the reader schema", func() {
+				So(err, ShouldBeNil)
+				So(pp.ResourceURI, ShouldEqual, "P1")
+				So(pp.RefundId, ShouldEqual, "R1")
+				So(fetcher.fetched, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the schema registry is unavailable", func() {
+			fetcher.err = fmt.Errorf("error fetching schema [1]: %w", registry.ErrUnavailable)
+			svc := &Service{Decode: decoder.Decode}
+			value := wireFormat(1, extendedSchema, map[string]any{"payment_resource_id": "P1", "refund_id": "R1",
+				"attempt": 2, "processed_at": nil})
+
+			outcome := svc.process(context.Background(), &sarama.ConsumerMessage{Value: value}, &pp)
+
+			Convey("Then the message can be retried", func() {
+				So(outcome.Kind, ShouldEqual, RetryableFailure)
+				So(errors.Is(outcome.Err, registry.ErrUnavailable), ShouldBeTrue)
+			})
+
+			Convey("Then the schema is fetched again once the registry is available", func() {
+				fetcher.err = nil
+				So(decoder.Decode(value, &pp), ShouldBeNil)
+				So(pp.ResourceURI, ShouldEqual, "P1")
+			})
+		})
+	})
+}
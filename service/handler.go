@@ -0,0 +1,304 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/payment-reconciliation-consumer/config"
+	"github.com/companieshouse/payment-reconciliation-consumer/dao"
+	"github.com/companieshouse/payment-reconciliation-consumer/data"
+	"github.com/companieshouse/payment-reconciliation-consumer/encryption"
+	"github.com/companieshouse/payment-reconciliation-consumer/keys"
+	"github.com/companieshouse/payment-reconciliation-consumer/masking"
+	"github.com/companieshouse/payment-reconciliation-consumer/models"
+	"github.com/companieshouse/payment-reconciliation-consumer/payment"
+	"github.com/companieshouse/payment-reconciliation-consumer/transformer"
+)
+
+// Handler reconciles a single payment-processed message, independently of where the message came from
+type Handler struct {
+	DAO                dao.DAO
+	ProductMaps        *config.ProductMapStore
+	Payments           payment.Fetcher
+	Transformer        transformer.Transformer
+	SkipGoneResource   bool
+	SkipGoneResourceId string
+	DryRun             bool
+	VerboseLogging     bool
+	// LogHasher hashes the personal fields of verbose log views and is only needed when VerboseLogging is set
+	LogHasher data.Hasher
+}
+
+// NewHandler creates a Handler which reconciles payments using the payments api and database given in cfg
+func NewHandler(cfg *config.Config) (*Handler, error) {
+	// Load the product map up front so that a missing or invalid file stops the service from starting
+	if _, err := config.GetProductMap(); err != nil {
+		log.Error(fmt.Errorf("error initialising productMap: %s", err), nil)
+		return nil, err
+	}
+
+	paymentsDAO := dao.NewPaymentReconciliationDAOService
+	if cfg.DryRun {
+		log.Info("dry run: reconciliation records will not be written")
+		paymentsDAO = dao.NewDryRunDAOService
+	}
+
+	policy, err := masking.Load(masking.PolicyFile)
+	if err != nil {
+		log.Error(fmt.Errorf("error initialising masking policy: %s", err), nil)
+		return nil, err
+	}
+
+	reconciliationDAO, err := paymentsDAO(cfg)
+	if err != nil {
+		log.Error(fmt.Errorf("error initialising database: %s", err), nil)
+		return nil, err
+	}
+
+	var logHasher data.Hasher
+	if cfg.VerbosePaymentLogging || policy.Hashes() {
+		// Personal fields are only logged, or masked by hashing, as hashes keyed on the field encryption key
+		keyring, err := encryption.Load(cfg.FieldEncryptionKeyID, cfg.FieldEncryptionKey, cfg.FieldEncryptionKeyFile)
+		if err != nil {
+			log.Error(fmt.Errorf("error loading field encryption key for hashing personal fields: %s", err), nil)
+			return nil, err
+		}
+		logHasher = keyring
+		policy.Hasher = keyring
+	}
+
+	return &Handler{
+		DAO:                reconciliationDAO,
+		ProductMaps:        config.ProductMaps(),
+		Payments:           payment.NewClient(cfg.PaymentsAPIURL, cfg.ChsAPIKey, time.Duration(cfg.PaymentsAPITimeout)*time.Second),
+		Transformer:        transformer.New(policy),
+		SkipGoneResource:   cfg.SkipGoneResource,
+		SkipGoneResourceId: cfg.SkipGoneResourceId,
+		DryRun:             cfg.DryRun,
+		VerboseLogging:     cfg.VerbosePaymentLogging,
+		LogHasher:          logHasher,
+	}, nil
+}
+
+// Handle runs the fetch, reconcilability check, transform and save steps for the payment referenced by pp. It stops
+// at the first step that fails and reports how processing ended, leaving the caller to decide whether the message
+// should be committed, retried or sent to the error topic.
+// Requests to the payments api are abandoned when ctx is cancelled.
+func (h *Handler) Handle(ctx context.Context, pp data.PaymentProcessed) Outcome {
+	//Call GetPayment payment session from payments API
+	paymentResponse, statusCode, err := h.Payments.GetPayment(ctx, pp.ResourceURI)
+	if err != nil {
+		if h.skipGoneResource(err, pp.ResourceURI) {
+			return skipped("payment resource is gone")
+		}
+		return failure(err)
+	}
+	h.logVerbose("Payment Response : ", keys.PaymentResponse, paymentResponse.VerboseLogView(h.LogHasher), statusCode)
+
+	return h.handlePayment(ctx, paymentResponse, pp).describing(paymentResponse)
+}
+
+// handlePayment reconciles the payment or refund referenced by pp once the payment itself has been fetched
+func (h *Handler) handlePayment(ctx context.Context, paymentResponse data.PaymentResponse, pp data.PaymentProcessed) Outcome {
+	logData := log.Data{keys.PaymentID: pp.ResourceURI}
+
+	// The map is taken once, so that every record for the message is built from the same map even if it is reloaded
+	productMap, err := h.ProductMaps.Get()
+	if err != nil {
+		return failure(err)
+	}
+
+	if !paymentResponse.HasReconcilableClass() {
+		return skipped("payment is not reconcilable")
+	}
+
+	//Call GetPayment payment details from payments API
+	paymentDetails, statusCode, err := h.Payments.GetPaymentDetails(ctx, pp.ResourceURI)
+	if err != nil {
+		return failure(err)
+	}
+	h.logVerbose("Payment Details Response : ", keys.PaymentDetails, paymentDetails.LogView(true), statusCode)
+
+	if isRefundTransaction(pp) {
+		log.Info("Handling refund transaction", logData)
+		return h.handleRefundTransaction(ctx, productMap, paymentResponse, paymentDetails, pp)
+	}
+
+	if paymentDetails.PaymentStatus != "accepted" {
+		log.Info("Payment has not been accepted", log.Data{keys.PaymentID: pp.ResourceURI,
+			"payment_status": paymentDetails.PaymentStatus})
+		return skipped("payment has not been accepted")
+	}
+
+	// A product type only has a product code for some dates, so whether the payment is reconcilable depends on when
+	// it was made
+	transactionDate, err := time.Parse(time.RFC3339Nano, paymentDetails.TransactionDate)
+	if err != nil {
+		return failure(err)
+	}
+	if !paymentResponse.IsReconcilable(productMap, transactionDate) {
+		return skipped("payment is not reconcilable")
+	}
+
+	eshus, err := h.Transformer.GetEshuResources(productMap, paymentResponse, paymentDetails, pp.ResourceURI)
+	if err != nil {
+		return failure(err)
+	}
+
+	txns, err := h.Transformer.GetTransactionResources(productMap, paymentResponse, paymentDetails, pp.ResourceURI)
+	if err != nil {
+		return failure(err)
+	}
+
+	skippedCosts, err := h.Transformer.GetSkippedCostResources(productMap, paymentResponse, paymentDetails, pp.ResourceURI)
+	if err != nil {
+		return failure(err)
+	}
+	if len(skippedCosts) > 0 {
+		reasons := make(map[int]string, len(skippedCosts))
+		for _, skippedCost := range skippedCosts {
+			reasons[skippedCost.CostLine] = skippedCost.Reason
+		}
+		log.Info("Partially reconciling payment", log.Data{keys.PaymentID: pp.ResourceURI,
+			"skipped_costs": len(skippedCosts), "skipped_cost_reasons": reasons})
+	}
+
+	//Add Eshu objects, Payment Transactions and skipped costs to the Database together
+	return h.savePaymentResources(eshus, txns, skippedCosts, pp)
+}
+
+// Saves Eshu, Transaction and skipped cost resources to the database in a single transaction
+func (h *Handler) savePaymentResources(
+	eshus []models.EshuResourceDao,
+	txns []models.PaymentTransactionsResourceDao,
+	skippedCosts []models.SkippedCostResourceDao,
+	pp data.PaymentProcessed) Outcome {
+
+	err := h.DAO.CreatePaymentResources(eshus, txns, skippedCosts)
+	if err == dao.ErrAlreadyExists {
+		log.Info("payment resources already exist in database, skipping", log.Data{keys.PaymentID: pp.ResourceURI,
+			"eshus": len(eshus), "transactions": len(txns), "skipped_costs": len(skippedCosts)})
+		return skipped("payment has already been reconciled")
+	}
+	if err != nil {
+		log.Error(err, log.Data{keys.Message: "failed to create payment resources in database",
+			keys.PaymentID: pp.ResourceURI, "eshus": len(eshus), "transactions": len(txns),
+			"skipped_costs": len(skippedCosts)})
+		return failure(err)
+	}
+
+	return reconciledPayment(eshus, txns, skippedCosts)
+}
+
+// Saves Refund resources to the database
+func (h *Handler) saveRefundResource(refund models.RefundResourceDao, pp data.PaymentProcessed) Outcome {
+
+	err := h.DAO.CreateRefundResource(&refund)
+	if err == dao.ErrAlreadyExists {
+		log.Info("refund resource already exists in database, skipping", log.Data{keys.PaymentID: pp.ResourceURI,
+			keys.RefundID: refund.RefundID})
+		return skipped("refund has already been reconciled")
+	}
+	if err != nil {
+		log.Error(err, log.Data{keys.Message: "failed to create refund request in database",
+			keys.PaymentID: pp.ResourceURI, keys.RefundID: refund.RefundID})
+		return failure(err)
+	}
+
+	return reconciledRefund(refund)
+}
+
+// logVerbose logs the verbose view of a payments api response at debug level when verbose logging is enabled. The
+// payments client logs a summary of every response, so nothing more is logged otherwise.
+func (h *Handler) logVerbose(message, key string, view interface{}, statusCode int) {
+	if h.VerboseLogging {
+		log.Debug(message, log.Data{key: view, keys.StatusCode: statusCode})
+	}
+}
+
+func isRefundTransaction(pp data.PaymentProcessed) bool {
+	return pp.RefundId != ""
+}
+
+func (h *Handler) handleRefundTransaction(ctx context.Context, productMap *config.ProductMap, paymentResponse data.PaymentResponse, paymentDetails data.PaymentDetailsResponse, pp data.PaymentProcessed) Outcome {
+	refund, err := getRefund(paymentResponse, pp)
+	if err != nil {
+		log.Error(err, log.Data{keys.Message: "Failed to handle refund transaction",
+			keys.PaymentResponse: paymentResponse.LogView(false)})
+		return failure(err)
+	}
+
+	if refund.Status == "submitted" || refund.Status == "refund-requested" {
+		// Refreshing a refund updates it in the payments api, which a dry run must not do
+		if h.DryRun {
+			return skipped("refund status is not final and is not refreshed in a dry run")
+		}
+		log.Info("Refund status is submitted. Fetching latest refund status", log.Data{"Refund": refund.LogView(false)})
+		var statusCode int
+		refund, statusCode, err = h.Payments.RefreshRefund(ctx, pp.ResourceURI, pp.RefundId)
+		if err != nil {
+			log.Error(err, log.Data{keys.PaymentID: pp.ResourceURI, keys.StatusCode: statusCode})
+			return failure(err)
+		}
+		h.logVerbose("Refund Response : ", keys.RefundDetails, refund.LogView(true), statusCode)
+	}
+
+	return h.handleRefund(productMap, paymentResponse, paymentDetails, refund, pp)
+}
+
+func (h *Handler) handleRefund(productMap *config.ProductMap, paymentResponse data.PaymentResponse, paymentDetails data.PaymentDetailsResponse, refund *data.RefundResource, pp data.PaymentProcessed) Outcome {
+	if refund.Status == "success" || refund.Status == "refund-success" {
+		log.Info("Refund successful. Reconciling...", log.Data{"Refund": refund.LogView(false)})
+		return h.reconcileRefund(productMap, paymentResponse, paymentDetails, refund, pp)
+	}
+	if refund.Status == "failed" {
+		log.Info("Refund failed. Skipping reconciliation", log.Data{"Refund": refund.LogView(false)})
+		return skipped("refund failed")
+	}
+	return retryableFailure(errors.New("status is still submitted, retrying"))
+}
+
+func (h *Handler) reconcileRefund(productMap *config.ProductMap, paymentResponse data.PaymentResponse, paymentDetails data.PaymentDetailsResponse, refund *data.RefundResource, pp data.PaymentProcessed) Outcome {
+	refundResource, err := h.Transformer.GetRefundResource(productMap, paymentResponse, paymentDetails, *refund, pp.ResourceURI)
+	if err != nil {
+		return failure(err)
+	}
+
+	return h.saveRefundResource(refundResource, pp)
+}
+
+func getRefund(paymentResponse data.PaymentResponse, pp data.PaymentProcessed) (*data.RefundResource, error) {
+	for _, ref := range paymentResponse.Refunds {
+		if ref.RefundId == pp.RefundId {
+			return &ref, nil
+		}
+	}
+	return nil, errors.New("refund id not found in payment refunds")
+}
+
+func (h *Handler) skipGoneResource(err error, paymentId string) bool {
+	if err == payment.ErrResourceGone {
+		log.Info("Resource could not be found for payment: ["+paymentId+"]. Checking if this payment should be skipped.", log.Data{keys.PaymentID: paymentId})
+		return h.checkSkipGoneResource(paymentId)
+	}
+	return false
+}
+
+func (h *Handler) checkSkipGoneResource(paymentId string) bool {
+	logData := log.Data{keys.PaymentID: paymentId, "skip_gone_resource": h.SkipGoneResource, "skip_gone_resource_id": h.SkipGoneResourceId}
+
+	if h.SkipGoneResource {
+		log.Info("SKIP_GONE_RESOURCE is true - checking if message should be skipped for Payment ID ["+paymentId+"]", logData)
+		if h.SkipGoneResourceId != "" && h.SkipGoneResourceId != paymentId {
+			log.Info("SKIP_GONE_RESOURCE_ID ["+h.SkipGoneResourceId+"] does not match Payment ID ["+paymentId+"] - not skipping message", logData)
+			return false
+		}
+		log.Info("Message for Payment ID ["+paymentId+"] meets criteria and will be skipped", logData)
+		return true
+	}
+	log.Info("SKIP_GONE_RESOURCE is false - not skipping message for Payment ID ["+paymentId+"]", logData)
+	return false
+}
@@ -0,0 +1,281 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/payment-reconciliation-consumer/config"
+	"github.com/companieshouse/payment-reconciliation-consumer/dao"
+	"github.com/companieshouse/payment-reconciliation-consumer/data"
+	"github.com/companieshouse/payment-reconciliation-consumer/models"
+	"github.com/companieshouse/payment-reconciliation-consumer/payment"
+	_ "github.com/companieshouse/payment-reconciliation-consumer/testing"
+	"github.com/companieshouse/payment-reconciliation-consumer/transformer"
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func createHandler(mockPayment *payment.MockFetcher, mockTransformer *transformer.MockTransformer, mockDao *dao.MockDAO) *Handler {
+	productMap, err := createProductMap()
+	if err != nil {
+		log.Error(fmt.Errorf("error initialising productMap: %s", err), nil)
+	}
+
+	return &Handler{
+		Payments:    mockPayment,
+		Transformer: mockTransformer,
+		DAO:         mockDao,
+		ProductMaps: createProductMapStore(productMap),
+	}
+}
+
+func TestUnitHandle(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+	mockError := errors.New("test-simulated mock error")
+
+	pr := data.PaymentResponse{
+		CompanyNumber: "123456",
+		Costs:         []data.Cost{{ClassOfPayment: []string{data.OrderableItem}, ProductType: "certificate"}},
+	}
+	pdr := data.PaymentDetailsResponse{PaymentStatus: "accepted", TransactionDate: "2020-07-27T09:07:12.864Z"}
+	pp := data.PaymentProcessed{ResourceURI: paymentResourceID}
+
+	Convey("Given a payment-processed message is handled", t, func() {
+		mockPayment := payment.NewMockFetcher(ctrl)
+		mockTransformer := transformer.NewMockTransformer(ctrl)
+		mockDao := dao.NewMockDAO(ctrl)
+		handler := createHandler(mockPayment, mockTransformer, mockDao)
+		productMap, err := handler.ProductMaps.Get()
+		So(err, ShouldBeNil)
+
+		Convey("When the product map cannot be loaded then processing stops with a retryable failure", func() {
+			handler.ProductMaps = config.NewProductMapStore("missing_product_code.yml")
+			mockPayment.EXPECT().GetPayment(ctx, paymentResourceID).Return(pr, 200, nil)
+			mockPayment.EXPECT().GetPaymentDetails(gomock.Any(), gomock.Any()).Times(0)
+
+			outcome := handler.Handle(ctx, pp)
+
+			So(outcome.Kind, ShouldEqual, RetryableFailure)
+			So(outcome.Err, ShouldNotBeNil)
+		})
+
+		Convey("When the payment cannot be fetched then processing stops with a retryable failure", func() {
+			mockPayment.EXPECT().GetPayment(ctx, paymentResourceID).Return(data.PaymentResponse{}, 500, mockError)
+			mockPayment.EXPECT().GetPaymentDetails(gomock.Any(), gomock.Any()).Times(0)
+
+			outcome := handler.Handle(ctx, pp)
+
+			So(outcome.Kind, ShouldEqual, RetryableFailure)
+			So(outcome.Err, ShouldEqual, mockError)
+		})
+
+		Convey("When the payment is gone and gone resources are not skipped then processing stops with a permanent failure", func() {
+			mockPayment.EXPECT().GetPayment(ctx, paymentResourceID).Return(data.PaymentResponse{}, 410, payment.ErrResourceGone)
+
+			outcome := handler.Handle(ctx, pp)
+
+			So(outcome.Kind, ShouldEqual, PermanentFailure)
+			So(outcome.Err, ShouldEqual, payment.ErrResourceGone)
+		})
+
+		Convey("When the payment is gone and gone resources are skipped then the message is skipped", func() {
+			handler.SkipGoneResource = true
+			mockPayment.EXPECT().GetPayment(ctx, paymentResourceID).Return(data.PaymentResponse{}, 410, payment.ErrResourceGone)
+
+			outcome := handler.Handle(ctx, pp)
+
+			So(outcome.Kind, ShouldEqual, Skipped)
+			So(outcome.Reason, ShouldEqual, "payment resource is gone")
+		})
+
+		Convey("When the payment is not reconcilable then the message is skipped", func() {
+			penalty := data.PaymentResponse{Costs: []data.Cost{{ClassOfPayment: []string{data.Penalty}}}}
+			mockPayment.EXPECT().GetPayment(ctx, paymentResourceID).Return(penalty, 200, nil)
+			mockPayment.EXPECT().GetPaymentDetails(gomock.Any(), gomock.Any()).Times(0)
+
+			outcome := handler.Handle(ctx, pp)
+
+			So(outcome.Kind, ShouldEqual, Skipped)
+			So(outcome.Reason, ShouldEqual, "payment is not reconcilable")
+		})
+
+		Convey("When the payment details cannot be fetched then a refund is never reconciled", func() {
+			refundPP := data.PaymentProcessed{ResourceURI: paymentResourceID, RefundId: refundID}
+			mockPayment.EXPECT().GetPayment(ctx, paymentResourceID).Return(pr, 200, nil)
+			mockPayment.EXPECT().GetPaymentDetails(ctx, paymentResourceID).Return(data.PaymentDetailsResponse{}, 500, mockError)
+			mockPayment.EXPECT().RefreshRefund(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+			mockTransformer.EXPECT().GetRefundResource(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			outcome := handler.Handle(ctx, refundPP)
+
+			So(outcome.Kind, ShouldEqual, RetryableFailure)
+			So(outcome.Err, ShouldEqual, mockError)
+		})
+
+		Convey("When a submitted refund is handled during a dry run then it is not refreshed", func() {
+			handler.DryRun = true
+			refundPP := data.PaymentProcessed{ResourceURI: paymentResourceID, RefundId: refundID}
+			refundPayment := pr
+			refundPayment.Refunds = []data.RefundResource{{RefundId: refundID, Status: "submitted"}}
+			mockPayment.EXPECT().GetPayment(ctx, paymentResourceID).Return(refundPayment, 200, nil)
+			mockPayment.EXPECT().GetPaymentDetails(ctx, paymentResourceID).Return(pdr, 200, nil)
+			mockPayment.EXPECT().RefreshRefund(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			outcome := handler.Handle(ctx, refundPP)
+
+			So(outcome.Kind, ShouldEqual, Skipped)
+			So(outcome.Reason, ShouldEqual, "refund status is not final and is not refreshed in a dry run")
+		})
+
+		Convey("When the payment has not been accepted then the message is skipped", func() {
+			mockPayment.EXPECT().GetPayment(ctx, paymentResourceID).Return(pr, 200, nil)
+			mockPayment.EXPECT().GetPaymentDetails(ctx, paymentResourceID).Return(data.PaymentDetailsResponse{PaymentStatus: "failed"}, 200, nil)
+			mockTransformer.EXPECT().GetEshuResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			outcome := handler.Handle(ctx, pp)
+
+			So(outcome.Kind, ShouldEqual, Skipped)
+			So(outcome.Reason, ShouldEqual, "payment has not been accepted")
+		})
+
+		Convey("When the payment has been accepted without a valid transaction date then processing stops with a permanent failure", func() {
+			mockPayment.EXPECT().GetPayment(ctx, paymentResourceID).Return(pr, 200, nil)
+			mockPayment.EXPECT().GetPaymentDetails(ctx, paymentResourceID).Return(data.PaymentDetailsResponse{PaymentStatus: "accepted"}, 200, nil)
+			mockTransformer.EXPECT().GetEshuResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			outcome := handler.Handle(ctx, pp)
+
+			So(outcome.Kind, ShouldEqual, PermanentFailure)
+			So(outcome.Err, ShouldNotBeNil)
+		})
+
+		Convey("When no product code is mapped for the payment at its transaction date then the message is skipped", func() {
+			unmapped := data.PaymentResponse{Costs: []data.Cost{{ClassOfPayment: []string{data.OrderableItem}, ProductType: "unmapped"}}}
+			mockPayment.EXPECT().GetPayment(ctx, paymentResourceID).Return(unmapped, 200, nil)
+			mockPayment.EXPECT().GetPaymentDetails(ctx, paymentResourceID).Return(pdr, 200, nil)
+			mockTransformer.EXPECT().GetEshuResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			outcome := handler.Handle(ctx, pp)
+
+			So(outcome.Kind, ShouldEqual, Skipped)
+			So(outcome.Reason, ShouldEqual, "payment is not reconcilable")
+		})
+
+		Convey("When the payment has been accepted", func() {
+			mockPayment.EXPECT().GetPayment(ctx, paymentResourceID).Return(pr, 200, nil)
+			mockPayment.EXPECT().GetPaymentDetails(ctx, paymentResourceID).Return(pdr, 200, nil)
+
+			eshus := []models.EshuResourceDao{{}}
+			txns := []models.PaymentTransactionsResourceDao{{}}
+			skippedCosts := []models.SkippedCostResourceDao{}
+
+			Convey("And the eshu resources cannot be built then nothing is saved", func() {
+				mockTransformer.EXPECT().GetEshuResources(productMap, pr, pdr, paymentResourceID).Return(nil, mockError)
+				mockTransformer.EXPECT().GetTransactionResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+				mockDao.EXPECT().CreatePaymentResources(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+				outcome := handler.Handle(ctx, pp)
+
+				So(outcome.Kind, ShouldEqual, RetryableFailure)
+				So(outcome.Err, ShouldEqual, mockError)
+			})
+
+			Convey("And a cost has no product code then processing stops with a permanent failure", func() {
+				missingProductCode := fmt.Errorf("%w: [unmapped]", transformer.ErrMissingProductCode)
+				mockTransformer.EXPECT().GetEshuResources(productMap, pr, pdr, paymentResourceID).Return(nil, missingProductCode)
+				mockDao.EXPECT().CreatePaymentResources(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+				outcome := handler.Handle(ctx, pp)
+
+				So(outcome.Kind, ShouldEqual, PermanentFailure)
+				So(outcome.Err, ShouldEqual, missingProductCode)
+			})
+
+			Convey("And the transaction resources cannot be built then nothing is saved", func() {
+				mockTransformer.EXPECT().GetEshuResources(productMap, pr, pdr, paymentResourceID).Return(eshus, nil)
+				mockTransformer.EXPECT().GetTransactionResources(productMap, pr, pdr, paymentResourceID).Return(nil, mockError)
+				mockDao.EXPECT().CreatePaymentResources(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+				outcome := handler.Handle(ctx, pp)
+
+				So(outcome.Kind, ShouldEqual, RetryableFailure)
+				So(outcome.Err, ShouldEqual, mockError)
+			})
+
+			Convey("And the skipped costs cannot be built then nothing is saved", func() {
+				mockTransformer.EXPECT().GetEshuResources(productMap, pr, pdr, paymentResourceID).Return(eshus, nil)
+				mockTransformer.EXPECT().GetTransactionResources(productMap, pr, pdr, paymentResourceID).Return(txns, nil)
+				mockTransformer.EXPECT().GetSkippedCostResources(productMap, pr, pdr, paymentResourceID).Return(nil, mockError)
+				mockDao.EXPECT().CreatePaymentResources(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+				outcome := handler.Handle(ctx, pp)
+
+				So(outcome.Kind, ShouldEqual, RetryableFailure)
+				So(outcome.Err, ShouldEqual, mockError)
+			})
+
+			Convey("And some of the costs are skipped then they are saved with the reconciled costs", func() {
+				partialSkippedCosts := []models.SkippedCostResourceDao{{CostLine: 1, Reason: data.ReasonReconciledElsewhere}}
+				mockTransformer.EXPECT().GetEshuResources(productMap, pr, pdr, paymentResourceID).Return(eshus, nil)
+				mockTransformer.EXPECT().GetTransactionResources(productMap, pr, pdr, paymentResourceID).Return(txns, nil)
+				mockTransformer.EXPECT().GetSkippedCostResources(productMap, pr, pdr, paymentResourceID).Return(partialSkippedCosts, nil)
+				mockDao.EXPECT().CreatePaymentResources(eshus, txns, partialSkippedCosts).Return(nil)
+
+				outcome := handler.Handle(ctx, pp)
+
+				So(outcome.Kind, ShouldEqual, Reconciled)
+				So(outcome.SkippedCosts, ShouldResemble, partialSkippedCosts)
+			})
+
+			Convey("And the resources are built", func() {
+				mockTransformer.EXPECT().GetEshuResources(productMap, pr, pdr, paymentResourceID).Return(eshus, nil)
+				mockTransformer.EXPECT().GetTransactionResources(productMap, pr, pdr, paymentResourceID).Return(txns, nil)
+				mockTransformer.EXPECT().GetSkippedCostResources(productMap, pr, pdr, paymentResourceID).Return(skippedCosts, nil)
+
+				Convey("Then the payment is reconciled once they are saved", func() {
+					mockDao.EXPECT().CreatePaymentResources(eshus, txns, skippedCosts).Return(nil)
+
+					outcome := handler.Handle(ctx, pp)
+
+					So(outcome.Kind, ShouldEqual, Reconciled)
+					So(outcome.Eshus, ShouldResemble, eshus)
+					So(outcome.Transactions, ShouldResemble, txns)
+				})
+
+				Convey("Then the message is skipped if they had already been saved", func() {
+					mockDao.EXPECT().CreatePaymentResources(eshus, txns, skippedCosts).Return(dao.ErrAlreadyExists)
+
+					outcome := handler.Handle(ctx, pp)
+
+					So(outcome.Kind, ShouldEqual, Skipped)
+					So(outcome.Reason, ShouldEqual, "payment has already been reconciled")
+				})
+
+				Convey("Then processing fails with a retryable failure if they cannot be saved", func() {
+					saveErr := errors.New("connection reset")
+					mockDao.EXPECT().CreatePaymentResources(eshus, txns, skippedCosts).Return(saveErr)
+
+					outcome := handler.Handle(ctx, pp)
+
+					So(outcome.Kind, ShouldEqual, RetryableFailure)
+					So(outcome.Err, ShouldEqual, saveErr)
+				})
+
+				Convey("Then processing fails with a permanent failure if the database cannot run transactions", func() {
+					mockDao.EXPECT().CreatePaymentResources(eshus, txns, skippedCosts).Return(dao.ErrTransactionsUnsupported)
+
+					outcome := handler.Handle(ctx, pp)
+
+					So(outcome.Kind, ShouldEqual, PermanentFailure)
+					So(outcome.Err, ShouldEqual, dao.ErrTransactionsUnsupported)
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"github.com/companieshouse/payment-reconciliation-consumer/dao"
+	"github.com/companieshouse/payment-reconciliation-consumer/data"
+	"github.com/companieshouse/payment-reconciliation-consumer/models"
+	"github.com/companieshouse/payment-reconciliation-consumer/payment"
+	"github.com/companieshouse/payment-reconciliation-consumer/transformer"
+)
+
+// OutcomeKind identifies how the processing of a single payment-processed message ended
+type OutcomeKind int
+
+const (
+	// Reconciled indicates that the reconciliation records for the message were stored
+	Reconciled OutcomeKind = iota
+	// Skipped indicates that the message was deliberately not reconciled
+	Skipped
+	// RetryableFailure indicates that processing failed but may succeed if the message is retried
+	RetryableFailure
+	// PermanentFailure indicates that processing failed and retrying the message will not help
+	PermanentFailure
+)
+
+// String returns the name of the outcome kind, for use in logs
+func (k OutcomeKind) String() string {
+	switch k {
+	case Reconciled:
+		return "reconciled"
+	case Skipped:
+		return "skipped"
+	case RetryableFailure:
+		return "retryable_failure"
+	case PermanentFailure:
+		return "permanent_failure"
+	}
+	return "unknown"
+}
+
+// Outcome is the result of processing a single payment-processed message. Reason explains why a message was
+// skipped and Err holds the cause of a failure. ClassOfPayment and ProductType describe the first cost of the payment,
+// when it could be fetched, and the remaining fields hold the records written when it was reconciled, including the
+// costs which were skipped when only some of its costs were reconcilable.
+type Outcome struct {
+	Kind           OutcomeKind
+	Reason         string
+	Err            error
+	ClassOfPayment string
+	ProductType    string
+	Eshus          []models.EshuResourceDao
+	Transactions   []models.PaymentTransactionsResourceDao
+	SkippedCosts   []models.SkippedCostResourceDao
+	Refund         *models.RefundResourceDao
+}
+
+// describing returns a copy of the outcome labelled with the class of payment and product type of the payment
+func (o Outcome) describing(payment data.PaymentResponse) Outcome {
+	if len(payment.Costs) == 0 {
+		return o
+	}
+	if len(payment.Costs[0].ClassOfPayment) > 0 {
+		o.ClassOfPayment = payment.Costs[0].ClassOfPayment[0]
+	}
+	o.ProductType = payment.Costs[0].ProductType
+	return o
+}
+
+func reconciledPayment(eshus []models.EshuResourceDao, txns []models.PaymentTransactionsResourceDao, skippedCosts []models.SkippedCostResourceDao) Outcome {
+	return Outcome{Kind: Reconciled, Eshus: eshus, Transactions: txns, SkippedCosts: skippedCosts}
+}
+
+func reconciledRefund(refund models.RefundResourceDao) Outcome {
+	return Outcome{Kind: Reconciled, Refund: &refund}
+}
+
+func skipped(reason string) Outcome {
+	return Outcome{Kind: Skipped, Reason: reason}
+}
+
+func retryableFailure(err error) Outcome {
+	return Outcome{Kind: RetryableFailure, Err: err}
+}
+
+func permanentFailure(err error) Outcome {
+	return Outcome{Kind: PermanentFailure, Err: err}
+}
+
+// failure classifies err as a permanent failure if retrying the message cannot change the result, and as a
+// retryable failure otherwise. Database errors are treated as retryable, unless the deployment cannot run
+// transactions at all.
+func failure(err error) Outcome {
+	if payment.IsPermanent(err) || transformer.IsPermanent(err) || dao.IsPermanent(err) {
+		return permanentFailure(err)
+	}
+	return retryableFailure(err)
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// consumerErrorExpiry is how long an error reported by the consumer keeps it from being ready, if no message is
+// received in the meantime. Transient errors, such as those reported while the group rebalances, are not followed by
+// a message on a quiet topic.
+const consumerErrorExpiry = 2 * time.Minute
+
+// consumerStatus tracks whether the consumer is running and receiving messages, for use in readiness checks
+type consumerStatus struct {
+	mu           sync.Mutex
+	started      bool
+	lastReceived time.Time
+	lastErr      error
+	lastErrAt    time.Time
+}
+
+func (s *consumerStatus) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = true
+}
+
+func (s *consumerStatus) received() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastReceived = time.Now()
+}
+
+func (s *consumerStatus) failed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastErr = err
+	s.lastErrAt = time.Now()
+}
+
+// check returns an error if the consumer has not started, or if it has reported an error in the last
+// consumerErrorExpiry which it has not received a message since
+func (s *consumerStatus) check() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.started {
+		return errors.New("consumer has not started")
+	}
+	if s.lastErr != nil && s.lastErrAt.After(s.lastReceived) && time.Since(s.lastErrAt) < consumerErrorExpiry {
+		return s.lastErr
+	}
+	return nil
+}
+
+// CheckConsumer reports whether the service has joined its consumer group and is receiving messages without error
+func (svc *Service) CheckConsumer(ctx context.Context) error {
+	if svc.Source == nil {
+		return errors.New("consumer has not joined the consumer group")
+	}
+	return svc.status.check()
+}
+
+// CheckSchema reports whether the payment-processed schema has been loaded, and whether the schema registered in the
+// schema registry can be read with the schema embedded in the consumer. It fails while the consumer is running with
+// the embedded schema because the registry could not be reached.
+func (svc *Service) CheckSchema(ctx context.Context) error {
+	if svc.PpSchema == "" {
+		return errors.New("payment-processed schema has not been loaded")
+	}
+	if !json.Valid([]byte(svc.PpSchema)) {
+		return errors.New("payment-processed schema is not valid json")
+	}
+	if svc.schema == nil {
+		return nil
+	}
+	return svc.schema.check(ctx)
+}
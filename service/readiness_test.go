@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitCheckConsumer(t *testing.T) {
+	Convey("Given a service with a consumer", t, func() {
+		svc := &Service{Source: createSourceWithPaymentMessage(paymentResourceID)}
+
+		Convey("When it has not started consuming then it is not ready", func() {
+			So(svc.CheckConsumer(context.Background()), ShouldNotBeNil)
+		})
+
+		Convey("When it has started consuming", func() {
+			svc.status.start()
+
+			Convey("Then it is ready", func() {
+				So(svc.CheckConsumer(context.Background()), ShouldBeNil)
+			})
+
+			Convey("Then it is not ready once the consumer reports an error", func() {
+				consumerErr := errors.New("kicked from consumer group")
+				svc.status.failed(consumerErr)
+				So(svc.CheckConsumer(context.Background()), ShouldEqual, consumerErr)
+
+				Convey("And it is ready again once a message is received", func() {
+					svc.status.received()
+					So(svc.CheckConsumer(context.Background()), ShouldBeNil)
+				})
+
+				Convey("And it is ready again once the error has expired, even if no message is received", func() {
+					svc.status.lastErrAt = time.Now().Add(-consumerErrorExpiry)
+					So(svc.CheckConsumer(context.Background()), ShouldBeNil)
+				})
+			})
+		})
+	})
+}
+
+func TestUnitCheckSchema(t *testing.T) {
+	Convey("The schema check fails until a valid schema is loaded", t, func() {
+		svc := &Service{}
+		So(svc.CheckSchema(context.Background()), ShouldNotBeNil)
+
+		svc.PpSchema = "not json"
+		So(svc.CheckSchema(context.Background()), ShouldNotBeNil)
+
+		svc.PpSchema = getDefaultSchema()
+		So(svc.CheckSchema(context.Background()), ShouldBeNil)
+	})
+}
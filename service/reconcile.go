@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/payment-reconciliation-consumer/data"
+	"github.com/companieshouse/payment-reconciliation-consumer/keys"
+)
+
+// Reconciler reconciles a single payment, or one of its refunds, on request rather than from a payment-processed
+// message. The admin reconcile endpoint and the backfill subcommand both reconcile through it.
+type Reconciler interface {
+	Reconcile(ctx context.Context, paymentID, refundID string) Reconciliation
+}
+
+// Reconciliation is the outcome of reconciling a payment, or one of its refunds, on request
+type Reconciliation struct {
+	PaymentID string
+	RefundID  string
+	Outcome
+}
+
+// Status is "reconciled" or "skipped" when the payment was reconciled or skipped, and "failed" otherwise
+func (r Reconciliation) Status() string {
+	if r.Kind == Reconciled || r.Kind == Skipped {
+		return r.Kind.String()
+	}
+	return "failed"
+}
+
+// ErrorMessage returns the cause of a failed reconciliation, or "" if it did not fail
+func (r Reconciliation) ErrorMessage() string {
+	if r.Err == nil {
+		return ""
+	}
+	return r.Err.Error()
+}
+
+// Reconcile runs the same steps as a payment-processed message for the payment with paymentID, or for its refund with
+// refundID when one is given
+func (h *Handler) Reconcile(ctx context.Context, paymentID, refundID string) Reconciliation {
+	outcome := h.Handle(ctx, data.PaymentProcessed{ResourceURI: paymentID, RefundId: refundID})
+	reconciliation := Reconciliation{PaymentID: paymentID, RefundID: refundID, Outcome: outcome}
+
+	log.Info("Reconciled payment on request", log.Data{keys.PaymentID: paymentID, keys.RefundID: refundID,
+		keys.Outcome: reconciliation.Status(), keys.Reason: reconciliation.Reason})
+	return reconciliation
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/companieshouse/payment-reconciliation-consumer/dao"
+	"github.com/companieshouse/payment-reconciliation-consumer/data"
+	"github.com/companieshouse/payment-reconciliation-consumer/payment"
+	"github.com/companieshouse/payment-reconciliation-consumer/transformer"
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitReconcile(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a payment is reconciled on request", t, func() {
+		mockPayment := payment.NewMockFetcher(ctrl)
+		handler := createHandler(mockPayment, transformer.NewMockTransformer(ctrl), dao.NewMockDAO(ctrl))
+
+		Convey("When the payment is not reconcilable then it is skipped", func() {
+			mockPayment.EXPECT().GetPayment(gomock.Any(), paymentResourceID).Return(data.PaymentResponse{}, 200, nil)
+
+			reconciliation := handler.Reconcile(context.Background(), paymentResourceID, "")
+
+			So(reconciliation.PaymentID, ShouldEqual, paymentResourceID)
+			So(reconciliation.Status(), ShouldEqual, "skipped")
+			So(reconciliation.Reason, ShouldEqual, "payment is not reconcilable")
+			So(reconciliation.ErrorMessage(), ShouldBeEmpty)
+		})
+
+		Convey("When the payment cannot be fetched then it has failed with the cause", func() {
+			mockPayment.EXPECT().GetPayment(gomock.Any(), paymentResourceID).Return(data.PaymentResponse{}, 500, errors.New("timeout"))
+
+			reconciliation := handler.Reconcile(context.Background(), paymentResourceID, "refund")
+
+			So(reconciliation.RefundID, ShouldEqual, "refund")
+			So(reconciliation.Kind, ShouldEqual, RetryableFailure)
+			So(reconciliation.Status(), ShouldEqual, "failed")
+			So(reconciliation.ErrorMessage(), ShouldEqual, "timeout")
+		})
+	})
+
+	Convey("Both kinds of failure have the failed status", t, func() {
+		So(Reconciliation{Outcome: Outcome{Kind: Reconciled}}.Status(), ShouldEqual, "reconciled")
+		So(Reconciliation{Outcome: Outcome{Kind: RetryableFailure}}.Status(), ShouldEqual, "failed")
+		So(Reconciliation{Outcome: Outcome{Kind: PermanentFailure}}.Status(), ShouldEqual, "failed")
+	})
+}
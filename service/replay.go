@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/payment-reconciliation-consumer/config"
+	"github.com/companieshouse/payment-reconciliation-consumer/data"
+	"github.com/companieshouse/payment-reconciliation-consumer/keys"
+)
+
+// ReplaySummary counts how the messages replayed from a file were processed, and lists those which failed
+type ReplaySummary struct {
+	Messages int             `json:"messages"`
+	Outcomes map[string]int  `json:"outcomes"`
+	Failures []ReplayFailure `json:"failures,omitempty"`
+}
+
+// ReplayFailure is a message which failed to reconcile during a replay
+type ReplayFailure struct {
+	Line      int64  `json:"line"`
+	PaymentID string `json:"payment_id,omitempty"`
+	RefundID  string `json:"refund_id,omitempty"`
+	Outcome   string `json:"outcome"`
+	Error     string `json:"error"`
+}
+
+// String summarises the outcomes, for use in logs
+func (s *ReplaySummary) String() string {
+	return fmt.Sprintf("%d messages: %d reconciled, %d skipped, %d failed", s.Messages, s.Outcomes[Reconciled.String()],
+		s.Outcomes[Skipped.String()], s.Outcomes[RetryableFailure.String()]+s.Outcomes[PermanentFailure.String()])
+}
+
+func (s *ReplaySummary) add(line int64, pp data.PaymentProcessed, outcome Outcome) {
+	s.Messages++
+	s.Outcomes[outcome.Kind.String()]++
+	if outcome.Err != nil {
+		s.Failures = append(s.Failures, ReplayFailure{Line: line, PaymentID: pp.ResourceURI, RefundID: pp.RefundId,
+			Outcome: outcome.Kind.String(), Error: outcome.Err.Error()})
+	}
+}
+
+// NewReplay creates a service which reconciles the payment-processed events captured in the JSON lines file at
+// path, in place of consuming them from Kafka. Payments are fetched and records written as configured in cfg, but
+// failed messages are never retried or sent to the error topic.
+func NewReplay(cfg *config.Config, path string) (*Service, error) {
+	source, err := NewJSONLinesSource(path)
+	if err != nil {
+		return nil, err
+	}
+
+	handler, err := NewHandler(cfg)
+	if err != nil {
+		source.Close()
+		return nil, err
+	}
+
+	return &Service{
+		Handler:      handler,
+		Source:       source,
+		Decode:       DecodeJSON,
+		Topic:        path,
+		StopAtOffset: -1,
+		Replaying:    true,
+	}, nil
+}
+
+// Replay processes every message from the source in turn and returns a summary of the outcomes once the source has
+// no more messages. The summary of the messages processed so far is returned along with any error reading the
+// source, or when ctx is cancelled.
+func (svc *Service) Replay(ctx context.Context) (*ReplaySummary, error) {
+	defer svc.Source.Close()
+
+	summary := &ReplaySummary{Outcomes: map[string]int{}}
+	for {
+		select {
+		case <-ctx.Done():
+			return summary, ctx.Err()
+
+		case message, ok := <-svc.Source.Messages():
+			if !ok {
+				return summary, nil
+			}
+
+			var pp data.PaymentProcessed
+			outcome := svc.process(ctx, message, &pp)
+			if outcome.Kind == RetryableFailure && ctx.Err() != nil {
+				return summary, ctx.Err()
+			}
+			svc.route(message, pp, outcome)
+			summary.add(message.Offset, pp, outcome)
+
+			if err := svc.Source.Ack(message); err != nil {
+				log.Error(err, log.Data{keys.Offset: message.Offset})
+			}
+
+		case err := <-svc.Source.Errors():
+			return summary, err
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/companieshouse/payment-reconciliation-consumer/dao"
+	"github.com/companieshouse/payment-reconciliation-consumer/data"
+	"github.com/companieshouse/payment-reconciliation-consumer/payment"
+	"github.com/companieshouse/payment-reconciliation-consumer/transformer"
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitReplay(t *testing.T) {
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	productMap, err := createProductMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Given a file of captured events", t, func() {
+		path := filepath.Join(t.TempDir(), "events.jsonl")
+		events := `{"payment_resource_id":"` + paymentResourceID + `","attempt":1}
+{"refund_id":"` + refundID + `"}
+`
+		So(os.WriteFile(path, []byte(events), 0o644), ShouldBeNil)
+
+		source, err := NewJSONLinesSource(path)
+		So(err, ShouldBeNil)
+
+		mockPayment := payment.NewMockFetcher(ctrl)
+		svc := &Service{
+			Handler: &Handler{
+				Payments:    mockPayment,
+				Transformer: transformer.NewMockTransformer(ctrl),
+				DAO:         dao.NewMockDAO(ctrl),
+				ProductMaps: createProductMapStore(productMap),
+			},
+			Source:       source,
+			Decode:       DecodeJSON,
+			Topic:        path,
+			StopAtOffset: -1,
+			Replaying:    true,
+		}
+
+		penalty := data.PaymentResponse{Costs: []data.Cost{{ClassOfPayment: []string{data.Penalty}}}}
+		mockPayment.EXPECT().GetPayment(gomock.Any(), paymentResourceID).Return(penalty, 200, nil)
+
+		Convey("When the events are replayed then each is processed and summarised, and failures are not forwarded", func() {
+			summary, err := svc.Replay(context.Background())
+
+			So(err, ShouldBeNil)
+			So(summary.Messages, ShouldEqual, 2)
+			So(summary.Outcomes, ShouldResemble, map[string]int{"skipped": 1, "permanent_failure": 1})
+			So(summary.Failures, ShouldHaveLength, 1)
+			So(summary.Failures[0].Line, ShouldEqual, 2)
+			So(summary.Failures[0].RefundID, ShouldEqual, refundID)
+			So(summary.String(), ShouldEqual, "2 messages: 0 reconciled, 1 skipped, 1 failed")
+		})
+	})
+}
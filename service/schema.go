@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/payment-reconciliation-consumer/data"
+	"github.com/companieshouse/payment-reconciliation-consumer/keys"
+	hamba "github.com/hamba/avro/v2"
+)
+
+// schemaName is the subject the payment-processed schema is registered under
+const schemaName = "payment-processed"
+
+// latestSchemaFetcher fetches the latest schema registered under a subject, such as a registry.Client
+type latestSchemaFetcher interface {
+	LatestSchema(ctx context.Context, subject string) (string, error)
+}
+
+// schemaCheck records whether the payment-processed schema registered in the schema registry can be read with the
+// schema embedded in the consumer. While the registry cannot be reached the consumer is degraded, running with the
+// embedded schema, and the registered schema is compared again once the registry is back.
+type schemaCheck struct {
+	registry latestSchemaFetcher
+
+	mu       sync.Mutex
+	degraded error
+	mismatch error
+}
+
+// loadSchema returns the payment-processed schema registered in registry, or the schema embedded in the consumer if
+// the registry cannot be reached or the registered schema is not valid, and the check of the registered schema
+func loadSchema(ctx context.Context, registry latestSchemaFetcher) (string, *schemaCheck) {
+	check := &schemaCheck{registry: registry}
+
+	registered, err := registry.LatestSchema(ctx, schemaName)
+	if err != nil {
+		check.degraded = fmt.Errorf("running with the embedded %s schema, as the schema registry is unavailable: %w",
+			schemaName, err)
+		log.Info("warning: schema registry unavailable, starting with the embedded schema",
+			log.Data{keys.SchemaName: schemaName, keys.Message: err.Error()})
+		return data.PaymentProcessedSchema, check
+	}
+
+	log.Info("Successfully received schema", log.Data{keys.SchemaName: schemaName})
+
+	if !check.compare(registered) {
+		return data.PaymentProcessedSchema, check
+	}
+	return registered, check
+}
+
+// compare records whether the registered schema can be read with the embedded schema, and reports whether the
+// registered schema is valid
+func (c *schemaCheck) compare(registered string) bool {
+	writer, err := hamba.Parse(registered)
+	if err != nil {
+		c.mismatch = fmt.Errorf("registered %s schema is not valid: %s", schemaName, err)
+		log.Error(c.mismatch, log.Data{keys.SchemaName: schemaName})
+		return false
+	}
+
+	c.mismatch = compareSchemas(data.PaymentProcessedSchema, writer)
+	if c.mismatch != nil {
+		log.Error(c.mismatch, log.Data{keys.SchemaName: schemaName})
+	}
+	return true
+}
+
+// check returns an error while the consumer is degraded or the registered schema cannot be read with the embedded
+// schema. When degraded, the registered schema is fetched again, and compared if the registry is back.
+func (c *schemaCheck) check(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.degraded != nil {
+		registered, err := c.registry.LatestSchema(ctx, schemaName)
+		if err != nil {
+			return c.degraded
+		}
+		log.Info("schema registry available again, checking the registered schema", log.Data{keys.SchemaName: schemaName})
+		c.degraded = nil
+		c.compare(registered)
+	}
+	return c.mismatch
+}
+
+// compareSchemas returns an error if messages written with the registered schema cannot be read with the embedded
+// schema, meaning the embedded schema is out of date
+func compareSchemas(embedded string, registered hamba.Schema) error {
+	reader, err := hamba.Parse(embedded)
+	if err != nil {
+		return fmt.Errorf("embedded %s schema is not valid: %s", schemaName, err)
+	}
+	if err := hamba.NewSchemaCompatibility().Compatible(reader, registered); err != nil {
+		return fmt.Errorf("registered %s schema is not compatible with the embedded schema: %s", schemaName, err)
+	}
+	return nil
+}
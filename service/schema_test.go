@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/payment-reconciliation-consumer/data"
+	"github.com/companieshouse/payment-reconciliation-consumer/registry"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// A schema with a payment_resource_id the embedded schema cannot read
+const mismatchedSchema = `{"type":"record","name":"payment_processed","namespace":"payments","fields":[
+	{"name":"payment_resource_id","type":"long"}]}`
+
+// startSchemaRegistry starts a schema registry serving schema as the latest version of every subject
+func startSchemaRegistry(schema string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"schema": schema})
+	}))
+}
+
+// loadFrom loads the payment-processed schema from the schema registry at url
+func loadFrom(url string) (string, *schemaCheck) {
+	return loadSchema(context.Background(), registry.NewClient(url, time.Second))
+}
+
+func TestUnitLoadSchema(t *testing.T) {
+
+	Convey("Given a schema registry holding a schema the embedded schema can read", t, func() {
+		registry := startSchemaRegistry(getDefaultSchema())
+		defer registry.Close()
+
+		Convey("Then the registered schema is used", func() {
+			schema, check := loadFrom(registry.URL)
+			So(schema, ShouldEqual, getDefaultSchema())
+
+			svc := &Service{PpSchema: schema, schema: check}
+			So(svc.CheckSchema(context.Background()), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a schema registry holding a schema the embedded schema cannot read", t, func() {
+		registry := startSchemaRegistry(mismatchedSchema)
+		defer registry.Close()
+
+		Convey("Then the registered schema is used, and the mismatch is reported", func() {
+			schema, check := loadFrom(registry.URL)
+			So(schema, ShouldEqual, mismatchedSchema)
+
+			svc := &Service{PpSchema: schema, schema: check}
+			So(svc.CheckSchema(context.Background()), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a schema registry holding an invalid schema", t, func() {
+		registry := startSchemaRegistry(`{"type":"record"}`)
+		defer registry.Close()
+
+		Convey("Then the embedded schema is used, and the problem is reported", func() {
+			schema, check := loadFrom(registry.URL)
+			So(schema, ShouldEqual, data.PaymentProcessedSchema)
+
+			svc := &Service{PpSchema: schema, schema: check}
+			So(svc.CheckSchema(context.Background()), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a schema registry which cannot be reached", t, func() {
+		schemaToServe := ""
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if schemaToServe == "" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{"schema": schemaToServe})
+		}))
+		defer registry.Close()
+
+		schema, check := loadFrom(registry.URL)
+		svc := &Service{PpSchema: schema, schema: check}
+
+		Convey("Then the embedded schema is used, and the consumer is degraded", func() {
+			So(schema, ShouldEqual, data.PaymentProcessedSchema)
+			So(svc.CheckSchema(context.Background()), ShouldNotBeNil)
+		})
+
+		Convey("Then the registered schema is checked once the registry is back", func() {
+			schemaToServe = getDefaultSchema()
+			So(svc.CheckSchema(context.Background()), ShouldBeNil)
+		})
+
+		Convey("Then a mismatch is reported once the registry is back", func() {
+			schemaToServe = mismatchedSchema
+			So(svc.CheckSchema(context.Background()), ShouldNotBeNil)
+
+			schemaToServe = ""
+			So(svc.CheckSchema(context.Background()), ShouldNotBeNil)
+		})
+	})
+}
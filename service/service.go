@@ -1,21 +1,18 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"net/http"
 	"os"
 	"sync"
 	"time"
 
-	"github.com/companieshouse/payment-reconciliation-consumer/dao"
 	"github.com/companieshouse/payment-reconciliation-consumer/keys"
-	"github.com/companieshouse/payment-reconciliation-consumer/models"
-	"github.com/companieshouse/payment-reconciliation-consumer/transformer"
+	"github.com/companieshouse/payment-reconciliation-consumer/metrics"
 
 	"github.com/Shopify/sarama"
 	"github.com/companieshouse/chs.go/avro"
-	"github.com/companieshouse/chs.go/avro/schema"
 	"github.com/companieshouse/chs.go/kafka/client"
 	consumer "github.com/companieshouse/chs.go/kafka/consumer/cluster"
 	"github.com/companieshouse/chs.go/kafka/producer"
@@ -23,52 +20,51 @@ import (
 	"github.com/companieshouse/chs.go/log"
 	"github.com/companieshouse/payment-reconciliation-consumer/config"
 	"github.com/companieshouse/payment-reconciliation-consumer/data"
-	"github.com/companieshouse/payment-reconciliation-consumer/payment"
+	"github.com/companieshouse/payment-reconciliation-consumer/registry"
 )
 
+// schemaRegistryTimeout is how long to wait for the schema registry when fetching the schema a message was written with
+const schemaRegistryTimeout = 10 * time.Second
+
 // Service represents service config for payment-reconciliation-consumer
 type Service struct {
-	Consumer           *consumer.GroupConsumer
-	Producer           *producer.Producer
-	PpSchema           string
-	Client             *http.Client
-	InitialOffset      int64
-	HandleError        func(err error, offset int64, str interface{}) error
-	Topic              string
-	Retry              *resilience.ServiceRetry
-	IsErrorConsumer    bool
-	BrokerAddr         []string
-	APIKey             string
-	PaymentsAPIURL     string
-	DAO                dao.DAO
-	TranCollection     string
-	ProdCollection     string
-	ProductMap         *config.ProductMap
-	Payments           payment.Fetcher
-	Transformer        transformer.Transformer
-	StopAtOffset       int64
-	SkipGoneResource   bool
-	SkipGoneResourceId string
+	*Handler
+	Source          MessageSource
+	Producer        *producer.Producer
+	PpSchema        string
+	Decode          func(value []byte, pp *data.PaymentProcessed) error
+	InitialOffset   int64
+	HandleError     func(err error, offset int64, str interface{}) error
+	Topic           string
+	ErrorTopic      string
+	Retry           *resilience.ServiceRetry
+	IsErrorConsumer bool
+	BrokerAddr      []string
+	TranCollection  string
+	ProdCollection  string
+	StopAtOffset    int64
+	Replaying       bool
+	schema          *schemaCheck
+	status          consumerStatus
 }
 
 // New creates a new instance of service with a given consumerGroup name,
 // consumerTopic, throttleRate and payment-reconciliation-consumer config
 func New(consumerTopic, consumerGroupName string, cfg *config.Config, retry *resilience.ServiceRetry) (*Service, error) {
 
-	schemaName := "payment-processed"
-	ppSchema, err := schema.Get(cfg.SchemaRegistryURL, schemaName)
+	schemaRegistry := registry.NewClient(cfg.SchemaRegistryURL, schemaRegistryTimeout)
+	ppSchema, schemaCheck := loadSchema(context.Background(), schemaRegistry)
+
+	decoder, err := NewWireFormatDecoder(ppSchema, schemaRegistry)
 	if err != nil {
-		log.Error(fmt.Errorf("error receiving %s schema: %s", schemaName, err))
+		log.Error(err, log.Data{keys.SchemaName: schemaName})
 		return nil, err
 	}
 
-	log.Info("Successfully received schema", log.Data{keys.SchemaName: schemaName})
-
 	appName := cfg.Namespace()
 
-	productMap, err := config.GetProductMap()
+	handler, err := NewHandler(cfg)
 	if err != nil {
-		log.Error(fmt.Errorf("error initialising productMap: %s", err), nil)
 		return nil, err
 	}
 
@@ -99,28 +95,15 @@ func New(consumerTopic, consumerGroupName string, cfg *config.Config, retry *res
 		topicName = rh.GetErrorTopicName()
 	}
 
-	var resetOffset bool
-
-	consumerConfig := &consumer.Config{
-		Topics:       []string{topicName},
-		ZookeeperURL: cfg.ZookeeperURL,
-		BrokerAddr:   cfg.BrokerAddr,
-	}
-
 	log.Info("attempting to join consumer group", log.Data{
 		"consumer_group_name": consumerGroupName,
 		"topic":               topicName,
+		"coordinator":         cfg.ConsumerGroupCoordinator,
 	})
 
-	groupConfig := &consumer.GroupConfig{
-		GroupName:   consumerGroupName,
-		ResetOffset: resetOffset,
-		Chroot:      cfg.ZookeeperChroot,
-	}
-
-	c := consumer.NewConsumerGroup(consumerConfig)
-	if err = c.JoinGroup(groupConfig); err != nil {
-		log.Error(fmt.Errorf("error joining '"+consumerGroupName+"' consumer group", err), nil)
+	source, err := newSource(cfg, consumerGroupName, topicName)
+	if err != nil {
+		log.Error(fmt.Errorf("error joining '%s' consumer group: %s", consumerGroupName, err), nil)
 		return nil, err
 	}
 
@@ -136,154 +119,131 @@ func New(consumerTopic, consumerGroupName string, cfg *config.Config, retry *res
 	}
 
 	return &Service{
-		Consumer:           c,
-		Producer:           p,
-		PpSchema:           ppSchema,
-		Client:             &http.Client{},
-		HandleError:        rh.HandleError,
-		Topic:              topicName,
-		Retry:              retry,
-		IsErrorConsumer:    cfg.IsErrorConsumer,
-		BrokerAddr:         cfg.BrokerAddr,
-		APIKey:             cfg.ChsAPIKey,
-		PaymentsAPIURL:     cfg.PaymentsAPIURL,
-		DAO:                dao.NewPaymentReconciliationDAOService(cfg),
-		TranCollection:     cfg.TransactionsCollection,
-		ProdCollection:     cfg.ProductsCollection,
-		ProductMap:         productMap,
-		Payments:           payment.New(),
-		Transformer:        transformer.New(),
-		StopAtOffset:       stopAtOffset,
-		SkipGoneResource:   cfg.SkipGoneResource,
-		SkipGoneResourceId: cfg.SkipGoneResourceId,
+		Handler:         handler,
+		Source:          source,
+		Producer:        p,
+		PpSchema:        ppSchema,
+		schema:          schemaCheck,
+		Decode:          decoder.Decode,
+		HandleError:     rh.HandleError,
+		Topic:           topicName,
+		ErrorTopic:      rh.GetErrorTopicName(),
+		Retry:           retry,
+		IsErrorConsumer: cfg.IsErrorConsumer,
+		BrokerAddr:      cfg.BrokerAddr,
+		TranCollection:  cfg.TransactionsCollection,
+		ProdCollection:  cfg.ProductsCollection,
+		StopAtOffset:    stopAtOffset,
 	}, nil
 
 }
 
+// newSource joins the consumer group consuming topicName, coordinated as configured in cfg
+func newSource(cfg *config.Config, consumerGroupName, topicName string) (MessageSource, error) {
+	switch cfg.ConsumerGroupCoordinator {
+	case BrokerCoordinator:
+		return NewBrokerGroupConsumer(cfg.BrokerAddr, cfg.KafkaVersion, consumerGroupName, []string{topicName})
+
+	case ZookeeperCoordinator, "":
+		var resetOffset bool
+
+		consumerConfig := &consumer.Config{
+			Topics:       []string{topicName},
+			ZookeeperURL: cfg.ZookeeperURL,
+			BrokerAddr:   cfg.BrokerAddr,
+		}
+
+		groupConfig := &consumer.GroupConfig{
+			GroupName:   consumerGroupName,
+			ResetOffset: resetOffset,
+			Chroot:      cfg.ZookeeperChroot,
+		}
+
+		c := consumer.NewConsumerGroup(consumerConfig)
+		if err := c.JoinGroup(groupConfig); err != nil {
+			return nil, err
+		}
+		return &GroupConsumerSource{GroupConsumer: c}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown consumer group coordinator [%s], expected %s or %s",
+			cfg.ConsumerGroupCoordinator, ZookeeperCoordinator, BrokerCoordinator)
+	}
+}
+
 // Start begins the service - Messages are consumed from the payment-processed
 // topic
 func (svc *Service) Start(wg *sync.WaitGroup, c chan os.Signal) {
 	log.Info("service starting, consuming from the " + svc.Topic + " topic")
 
-	var err error
+	// Cancel any in-flight requests to the payments api as soon as we are asked to shut down
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
 	var message *sarama.ConsumerMessage
 
+	svc.status.start()
+
 	// We want to stop the processing of the service if consuming from an
 	// error queue if all messages that were initially in the queue have
-	// been cleared using the stopAtOffset
-	running := true
-	for running && (svc.StopAtOffset == -1 || message == nil || message.Offset < svc.StopAtOffset) {
-
-		if message != nil {
-			// Commit the message we've just been processing before starting the next
-			log.Trace("Committing message", log.Data{keys.Offset: message.Offset})
-			svc.Consumer.MarkOffset(message, "")
-			if err := svc.Consumer.CommitOffsets(); err != nil {
-				log.Error(err, log.Data{keys.Offset: message.Offset})
-			}
-		}
+	// been cleared using the stopAtOffset, or if the source has no more
+	// messages
+	running, exhausted := true, false
+	for running && !exhausted && (svc.StopAtOffset == -1 || message == nil || message.Offset < svc.StopAtOffset) {
 
 		if svc.Retry != nil && svc.Retry.ThrottleRate > 0 {
 			time.Sleep(svc.Retry.ThrottleRate * time.Second)
 		}
 
 		select {
-		case <-c:
+		case <-ctx.Done():
 			running = false
 
-		case message = <-svc.Consumer.Messages():
-			// Falls into this block when a message becomes available from consumer
-
-			if message != nil {
-				if message.Offset >= svc.InitialOffset {
-					log.Info("Received message from Payment Service. Attempting reconciliation...")
-
-					// GetPayment the payment session first
-					var pp data.PaymentProcessed
-					paymentProcessedSchema := &avro.Schema{
-						Definition: svc.PpSchema,
-					}
-
-					err = paymentProcessedSchema.Unmarshal(message.Value, &pp)
-					if err != nil {
-						log.Error(err, log.Data{keys.Offset: message.Offset})
-						retryErr := svc.HandleError(err, message.Offset, &pp)
-						if retryErr != nil {
-							log.Error(retryErr, log.Data{keys.Offset: message.Offset, keys.Topic: message.Topic})
-						}
-						continue
-					}
-
-					//Create GetPayment payment session URL
-					getPaymentURL := svc.PaymentsAPIURL + "/payments/" + pp.ResourceURI
-					log.Info("Payment URL : " + getPaymentURL)
-
-					//Call GetPayment payment session from payments API
-					paymentResponse, statusCode, err := svc.Payments.GetPayment(getPaymentURL, svc.Client, svc.APIKey)
-					if err != nil {
-						log.Error(err, log.Data{keys.Offset: message.Offset, keys.Topic: message.Topic})
-						skipGoneResource := svc.skipGoneResource(err, pp.ResourceURI, message)
-						if skipGoneResource {
-							continue
-						}
-						retryErr := svc.HandleError(err, message.Offset, &pp)
-						if retryErr != nil {
-							log.Error(retryErr, log.Data{keys.Offset: message.Offset, keys.Topic: message.Topic})
-						}
-					}
-					log.Info("Payment Response : ",
-						log.Data{keys.PaymentResponse: paymentResponse, keys.StatusCode: statusCode})
-
-					if err == nil && paymentResponse.IsReconcilable(svc.ProductMap) {
-
-						//Create GetPayment payment URL
-						getPaymentDetailsURL := svc.PaymentsAPIURL + "/private/payments/" + pp.ResourceURI + "/payment-details"
-						log.Info("Payment Details URL : " + getPaymentDetailsURL)
-
-						//Call GetPayment payment details from payments API
-						paymentDetails, statusCode, err := svc.Payments.GetPaymentDetails(getPaymentDetailsURL, svc.Client, svc.APIKey)
-
-						if err != nil {
-							log.Error(err, log.Data{keys.Offset: message.Offset})
-							retryErr := svc.HandleError(err, message.Offset, &pp)
-							if retryErr != nil {
-								log.Error(retryErr, log.Data{keys.Offset: message.Offset, keys.Topic: message.Topic})
-							}
-						}
-						log.Info("Payment Details Response : ",
-							log.Data{keys.PaymentDetails: paymentDetails, keys.StatusCode: statusCode})
-
-						if isRefundTransaction(pp) {
-							log.Info("Handling refund transaction")
-							svc.handleRefundTransaction(paymentResponse, getPaymentURL, message, pp)
-						} else if paymentDetails.PaymentStatus == "accepted" {
-
-							// We need to remove sensitive data fields for secure applications.
-							svc.MaskSensitiveFields(&paymentResponse)
-
-							// Get Eshu resources
-							eshus := svc.getEshuResources(message, paymentResponse, paymentDetails, pp)
-
-							//Add Eshu objects to the Database
-							svc.saveEshuResources(message, eshus, pp)
-
-							//Build Payment Transaction database objects
-							txns := svc.getTransactionResources(message, paymentResponse, paymentDetails, pp)
-
-							//Add Payment Transactions to the Database
-							svc.saveTransactionResources(message, txns, pp)
-						}
-					}
+		case received, ok := <-svc.Source.Messages():
+			if !ok {
+				log.Info("no more messages to consume", log.Data{keys.Topic: svc.Topic})
+				exhausted = true
+				continue
+			}
+			message = received
+			svc.status.received()
+
+			if message.Offset >= svc.InitialOffset {
+				log.Info("Received message from Payment Service. Attempting reconciliation...")
+
+				var pp data.PaymentProcessed
+				outcome := svc.process(ctx, message, &pp)
+				if outcome.Kind == RetryableFailure && ctx.Err() != nil {
+					// Shutdown interrupted processing, so leave the message unacknowledged for it to be redelivered
+					log.Info("Shutdown interrupted reconciliation, message will not be committed",
+						log.Data{keys.Offset: message.Offset, keys.PaymentID: pp.ResourceURI})
+					continue
 				}
+				svc.route(message, pp, outcome)
+			}
+
+			// Acknowledge the message we've just been processing before starting the next
+			log.Trace("Committing message", log.Data{keys.Offset: message.Offset})
+			if err := svc.Source.Ack(message); err != nil {
+				log.Error(err, log.Data{keys.Offset: message.Offset})
 			}
 
-		case err = <-svc.Consumer.Errors():
+		case err := <-svc.Source.Errors():
 			log.Error(err, log.Data{keys.Topic: svc.Topic})
+			svc.status.failed(err)
 		}
 	}
 
-	// We only get here if we're an error consumer and we've reached out stop offset
-	// We will not consume any further messages, so disconnect consumer.
+	// We only get here if we're an error consumer and we've reached out stop offset,
+	// or the source has no more messages. We will not consume any further messages,
+	// so disconnect consumer.
 	svc.Shutdown(svc.Topic)
 
 	// The app must not exit until explicitly asked to. If it did, when in
@@ -292,7 +252,7 @@ func (svc *Service) Start(wg *sync.WaitGroup, c chan os.Signal) {
 	// topic and chasing it's own tail, if something is really broken.
 	if running {
 		select {
-		case <-c: // Just wait for a shutdown event
+		case <-ctx.Done(): // Just wait for a shutdown event
 			log.Info("Received close notification")
 		}
 	}
@@ -302,24 +262,6 @@ func (svc *Service) Start(wg *sync.WaitGroup, c chan os.Signal) {
 	log.Info("Service successfully shutdown", log.Data{keys.Topic: svc.Topic})
 }
 
-// We need a function to mask potentially sensitive data fields in the event it's a secure application.
-// Currently there are product types/codes registered against these applications.
-func (svc *Service) MaskSensitiveFields(payment *data.PaymentResponse) {
-	log.Info("Blanking sensitive fields for secure applications. ")
-
-	// Define the value to be used for masked fields.
-	const maskedValue string = ""
-
-	// Find the product code associated with this product type.
-	productCode := svc.ProductMap.Codes[payment.Costs[0].ProductType]
-
-	if productCode == 16800 {
-		payment.CompanyNumber = maskedValue
-		payment.CreatedBy.Email = maskedValue
-	}
-
-}
-
 // Shutdown closes all producers and consumers for this service
 func (svc *Service) Shutdown(topic string) {
 
@@ -333,179 +275,80 @@ func (svc *Service) Shutdown(topic string) {
 	log.Info("Producer successfully closed", log.Data{keys.Topic: svc.Topic})
 
 	log.Info("Closing consumer", log.Data{keys.Topic: topic})
-	err = svc.Consumer.Close()
+	err = svc.Source.Close()
 	if err != nil {
 		log.Error(fmt.Errorf("error closing consumer: %s", err))
 	}
 	log.Info("Consumer successfully closed", log.Data{keys.Topic: svc.Topic})
 }
 
-// Creates Eshu resources
-func (svc *Service) getEshuResources(
-	message *sarama.ConsumerMessage,
-	paymentResponse data.PaymentResponse,
-	paymentDetailsResponse data.PaymentDetailsResponse,
-	pp data.PaymentProcessed) []models.EshuResourceDao {
-
-	eshus, err := svc.Transformer.GetEshuResources(paymentResponse, paymentDetailsResponse, pp.ResourceURI)
-	if err != nil {
-		log.Error(err, log.Data{keys.Offset: message.Offset})
-		_ = svc.HandleError(err, message.Offset, &pp)
-	}
-	return eshus
-}
-
-// Saves Eshu resources to the Database
-func (svc *Service) saveEshuResources(message *sarama.ConsumerMessage, eshus []models.EshuResourceDao, pp data.PaymentProcessed) {
-
-	for _, eshu := range eshus {
-		err := svc.DAO.CreateEshuResource(&eshu)
-		if err != nil {
-			log.Error(err, log.Data{keys.Message: "failed to create eshu request in database",
-				"data": eshu})
-			_ = svc.HandleError(err, message.Offset, &pp)
-		}
-	}
-}
-
-// Creates Payment Transaction database objects
-func (svc *Service) getTransactionResources(
-	message *sarama.ConsumerMessage,
-	paymentResponse data.PaymentResponse,
-	paymentDetailsResponse data.PaymentDetailsResponse,
-	pp data.PaymentProcessed) []models.PaymentTransactionsResourceDao {
-
-	txns, err := svc.Transformer.GetTransactionResources(paymentResponse, paymentDetailsResponse, pp.ResourceURI)
-	if err != nil {
-		log.Error(err, log.Data{keys.Offset: message.Offset})
-		_ = svc.HandleError(err, message.Offset, &pp)
-	}
-	return txns
-}
-
-// Saves Transaction resources to the database
-func (svc *Service) saveTransactionResources(
-	message *sarama.ConsumerMessage,
-	txns []models.PaymentTransactionsResourceDao,
-	pp data.PaymentProcessed) {
-
-	for _, txn := range txns {
-		err := svc.DAO.CreatePaymentTransactionsResource(&txn)
-		if err != nil {
-			log.Error(err, log.Data{keys.Message: "failed to create production request in database",
-				"data": txn})
-			_ = svc.HandleError(err, message.Offset, &pp)
+// process decodes the message into pp and reconciles the payment it refers to
+func (svc *Service) process(ctx context.Context, message *sarama.ConsumerMessage, pp *data.PaymentProcessed) Outcome {
+	// A message that cannot be decoded will never be decodable, so there is no point retrying it, unless the schema
+	// it was written with could not be fetched
+	if err := svc.Decode(message.Value, pp); err != nil {
+		if errors.Is(err, registry.ErrUnavailable) {
+			return retryableFailure(err)
 		}
+		return permanentFailure(err)
 	}
-}
 
-// Creates Refund resources
-func (svc *Service) getRefundResource(
-	message *sarama.ConsumerMessage,
-	paymentResponse data.PaymentResponse,
-	refund data.RefundResource,
-	pp data.PaymentProcessed) models.RefundResourceDao {
-
-	refundResource, err := svc.Transformer.GetRefundResource(paymentResponse, refund, pp.ResourceURI)
-	if err != nil {
-		log.Error(err, log.Data{keys.Offset: message.Offset})
-		_ = svc.HandleError(err, message.Offset, &pp)
-	}
-	return refundResource
+	return svc.Handle(ctx, *pp)
 }
 
-// Saves Refund resources to the database
-func (svc *Service) saveRefundResource(
-	message *sarama.ConsumerMessage,
-	refund models.RefundResourceDao,
-	pp data.PaymentProcessed) {
+// route commits, retries or sends the message to the error topic depending on the outcome of processing it. The
+// message offset itself is committed by the caller once routing has completed.
+func (svc *Service) route(message *sarama.ConsumerMessage, pp data.PaymentProcessed, outcome Outcome) {
+	logData := log.Data{keys.Offset: message.Offset, keys.Topic: message.Topic, keys.PaymentID: pp.ResourceURI,
+		keys.Outcome: outcome.Kind.String()}
 
-	err := svc.DAO.CreateRefundResource(&refund)
-	if err != nil {
-		log.Error(err, log.Data{keys.Message: "failed to create refund request in database",
-			"data": refund})
-		_ = svc.HandleError(err, message.Offset, &pp)
-	}
-}
+	metrics.Messages.WithLabelValues(outcome.Kind.String(), outcome.Reason, outcome.ClassOfPayment, outcome.ProductType).Inc()
+	metrics.LastProcessedOffset.WithLabelValues(message.Topic).Set(float64(message.Offset))
 
-func isRefundTransaction(pp data.PaymentProcessed) bool {
-	return pp.RefundId != ""
-}
+	switch outcome.Kind {
+	case Reconciled:
+		log.Info("Payment reconciled", logData)
 
-func (svc *Service) handleRefundTransaction(paymentResponse data.PaymentResponse, paymentUrl string, message *sarama.ConsumerMessage, pp data.PaymentProcessed) {
-	refund, err := getRefund(paymentResponse, pp)
+	case Skipped:
+		logData[keys.Reason] = outcome.Reason
+		log.Info("Payment skipped", logData)
 
-	if err != nil {
-		log.Error(err, log.Data{keys.Message: "Failed to handle refund transaction",
-			"data": paymentResponse})
-		_ = svc.HandleError(err, message.Offset, &pp)
-	}
-
-	if refund != nil {
-		if refund.Status == "submitted" || refund.Status == "refund-requested" {
-			log.Info("Refund status is submitted. Fetching latest refund status", log.Data{"Refund": refund})
-			var statusCode int
-			refund, statusCode, err = svc.Payments.GetLatestRefundStatus(paymentUrl+"/refunds/"+pp.RefundId, svc.Client, svc.APIKey)
-
-			if err != nil {
-				log.Error(err, log.Data{keys.Offset: message.Offset, keys.StatusCode: statusCode})
-				_ = svc.HandleError(err, message.Offset, &pp)
-			}
+	case RetryableFailure:
+		log.Error(outcome.Err, logData)
+		if svc.DryRun {
+			log.Info("dry run: message will not be retried", logData)
+			return
 		}
-		handleRefund(paymentResponse, refund, svc, message, pp, err)
-	}
-}
-
-func handleRefund(paymentResponse data.PaymentResponse, refund *data.RefundResource, svc *Service, message *sarama.ConsumerMessage, pp data.PaymentProcessed, err error) {
-	if refund.Status == "success" || refund.Status == "refund-success" {
-		log.Info("Refund successful. Reconciling...", log.Data{"Refund": refund})
-		reconcileRefund(paymentResponse, svc, message, refund, pp)
-	} else if refund.Status == "failed" {
-		log.Info("Refund failed. Skipping reconciliation", log.Data{"Refund": refund})
-	} else {
-		err = errors.New("status is still submitted, retrying")
-		_ = svc.HandleError(err, message.Offset, &pp)
-	}
-}
-
-func reconcileRefund(paymentResponse data.PaymentResponse, svc *Service, message *sarama.ConsumerMessage, refund *data.RefundResource, pp data.PaymentProcessed) {
-	// We need to remove sensitive data fields for secure applications.
-	svc.MaskSensitiveFields(&paymentResponse)
-
-	refundResource := svc.getRefundResource(message, paymentResponse, *refund, pp)
-
-	svc.saveRefundResource(message, refundResource, pp)
-}
-
-func getRefund(paymentResponse data.PaymentResponse, pp data.PaymentProcessed) (*data.RefundResource, error) {
-	for _, ref := range paymentResponse.Refunds {
-		if ref.RefundId == pp.RefundId {
-			return &ref, nil
+		if svc.Replaying {
+			log.Info("replay: message will not be retried", logData)
+			return
+		}
+		if retryErr := svc.HandleError(outcome.Err, message.Offset, &pp); retryErr != nil {
+			log.Error(retryErr, logData)
 		}
-	}
-	return nil, errors.New("refund id not found in payment refunds")
-}
 
-func (svc *Service) skipGoneResource(err error, paymentId string, message *sarama.ConsumerMessage) bool {
-	if err == payment.ErrResourceGone {
-		log.Info("Resource could not be found for payment: ["+paymentId+"]. Checking if this payment should be skipped.", log.Data{keys.Offset: message.Offset, keys.Topic: message.Topic})
-		return svc.checkSkipGoneResource(paymentId, message)
+	case PermanentFailure:
+		log.Error(outcome.Err, logData)
+		if svc.DryRun {
+			log.Info("dry run: message will not be sent to the error topic", logData)
+			return
+		}
+		if svc.Replaying {
+			log.Info("replay: message will not be sent to the error topic", logData)
+			return
+		}
+		if err := svc.sendToErrorTopic(message); err != nil {
+			log.Error(err, logData)
+		}
 	}
-	return false
 }
 
-func (svc *Service) checkSkipGoneResource(paymentId string, message *sarama.ConsumerMessage) bool {
-	logData := log.Data{"payment_id": paymentId, "skip_gone_resource": svc.SkipGoneResource, "skip_gone_resource_id": svc.SkipGoneResourceId, keys.Offset: message.Offset, keys.Topic: message.Topic}
-
-	if svc.SkipGoneResource {
-		log.Info("SKIP_GONE_RESOURCE is true - checking if message should be skipped for Payment ID ["+paymentId+"]", logData)
-		if svc.SkipGoneResourceId != "" && svc.SkipGoneResourceId != paymentId {
-			log.Info("SKIP_GONE_RESOURCE_ID ["+svc.SkipGoneResourceId+"] does not match Payment ID ["+paymentId+"] - not skipping message", logData)
-			return false
-		}
-		log.Info("Message for Payment ID ["+paymentId+"] meets criteria and will be skipped", logData)
-		return true
-	}
-	log.Info("SKIP_GONE_RESOURCE is false - not skipping message for Payment ID ["+paymentId+"]", logData)
-	return false
+// sendToErrorTopic forwards the original message to the error topic, bypassing the retry topic
+func (svc *Service) sendToErrorTopic(message *sarama.ConsumerMessage) error {
+	_, _, err := svc.Producer.SendMessage(&sarama.ProducerMessage{
+		Topic: svc.ErrorTopic,
+		Key:   sarama.ByteEncoder(message.Key),
+		Value: sarama.ByteEncoder(message.Value),
+	})
+	return err
 }
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -9,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Shopify/sarama"
 	"github.com/companieshouse/chs.go/avro/schema"
 	"github.com/companieshouse/chs.go/kafka/resilience"
 	"github.com/companieshouse/payment-reconciliation-consumer/config"
@@ -76,14 +78,16 @@ func TestNewService(t *testing.T) {
 	require.NotNil(t, schema)
 
 	cfg := &config.Config{
-		SchemaRegistryURL: mockSchemaRegistry.URL,
-		ZookeeperURL:      "localhost:2181",
-		BrokerAddr:        []string{bootstrapAddr},
-		ZookeeperChroot:   "",
-		ChsAPIKey:         "test-api-key",
-		PaymentsAPIURL:    "http://mock-payments",
-		IsErrorConsumer:   false,
-		MongoDBURL:        uri,
+		SchemaRegistryURL:    mockSchemaRegistry.URL,
+		ZookeeperURL:         "localhost:2181",
+		BrokerAddr:           []string{bootstrapAddr},
+		ZookeeperChroot:      "",
+		ChsAPIKey:            "test-api-key",
+		PaymentsAPIURL:       "http://mock-payments",
+		IsErrorConsumer:      false,
+		MongoDBURL:           uri,
+		FieldEncryptionKeyID: "test",
+		FieldEncryptionKey:   base64.StdEncoding.EncodeToString(make([]byte, 32)),
 	}
 
 	retry := &resilience.ServiceRetry{
@@ -96,5 +100,88 @@ func TestNewService(t *testing.T) {
 	require.NotNil(t, svc)
 	require.Equal(t, "test-topic-payment-reconciliation-consumer-retry", svc.Topic)
 	require.NotNil(t, svc.Producer)
-	require.NotNil(t, svc.Consumer)
+	require.NotNil(t, svc.Source)
+}
+
+func TestIntegrationNewServiceWithBrokerCoordinatedGroup(t *testing.T) {
+	container, uri, _ := testutils.SetupMongoContainer()
+	defer container.Terminate(context.Background())
+
+	mockSchemaRegistry := startMockSchemaRegistry(t)
+	defer mockSchemaRegistry.Close()
+	bootstrapAddr := kafkaBootstrapAddr(t, setupKafkaContainer(t))
+
+	cfg := &config.Config{
+		SchemaRegistryURL:        mockSchemaRegistry.URL,
+		BrokerAddr:               []string{bootstrapAddr},
+		ConsumerGroupCoordinator: BrokerCoordinator,
+		KafkaVersion:             "2.1.0",
+		ChsAPIKey:                "test-api-key",
+		PaymentsAPIURL:           "http://mock-payments",
+		MongoDBURL:               uri,
+		FieldEncryptionKeyID:     "test",
+		FieldEncryptionKey:       base64.StdEncoding.EncodeToString(make([]byte, 32)),
+	}
+
+	svc, err := New("test-topic", "test-group", cfg, nil)
+	require.NoError(t, err)
+	require.IsType(t, &BrokerGroupConsumer{}, svc.Source)
+	require.NoError(t, svc.Source.Close())
+}
+
+func TestIntegrationBrokerGroupConsumer(t *testing.T) {
+	bootstrapAddr := kafkaBootstrapAddr(t, setupKafkaContainer(t))
+	topic := "payment-processed"
+
+	producerConfig := sarama.NewConfig()
+	producerConfig.Producer.Return.Successes = true
+	p, err := sarama.NewSyncProducer([]string{bootstrapAddr}, producerConfig)
+	require.NoError(t, err)
+	defer p.Close()
+
+	send := func(value string) {
+		_, _, err := p.SendMessage(&sarama.ProducerMessage{Topic: topic, Value: sarama.StringEncoder(value)})
+		require.NoError(t, err)
+	}
+	receive := func(c *BrokerGroupConsumer) string {
+		select {
+		case message := <-c.Messages():
+			require.NoError(t, c.Ack(message))
+			return string(message.Value)
+		case err := <-c.Errors():
+			t.Fatalf("error consuming: %s", err)
+		case <-time.After(time.Minute):
+			t.Fatal("timed out waiting for message")
+		}
+		return ""
+	}
+
+	send("first")
+
+	c, err := NewBrokerGroupConsumer([]string{bootstrapAddr}, "2.1.0", "test-group", []string{topic})
+	require.NoError(t, err)
+	require.Equal(t, "first", receive(c))
+	require.NoError(t, c.Close())
+
+	// The offset acknowledged is committed when the consumer leaves the group, so a new member carries on from there
+	send("second")
+
+	c, err = NewBrokerGroupConsumer([]string{bootstrapAddr}, "2.1.0", "test-group", []string{topic})
+	require.NoError(t, err)
+	defer c.Close()
+	require.Equal(t, "second", receive(c))
+}
+
+func kafkaBootstrapAddr(t *testing.T, kafkaContainer *kafka.KafkaContainer) string {
+	t.Helper()
+
+	ctx := context.Background()
+
+	host, err := kafkaContainer.Host(ctx)
+	require.NoError(t, err)
+
+	mappedPort, err := kafkaContainer.MappedPort(ctx, "9092")
+	require.NoError(t, err)
+
+	return fmt.Sprintf("%s:%s", host, mappedPort.Port())
 }
@@ -1,10 +1,11 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"path/filepath"
 	"sync"
@@ -13,24 +14,25 @@ import (
 
 	"github.com/Shopify/sarama"
 	"github.com/companieshouse/chs.go/avro"
-	consumer "github.com/companieshouse/chs.go/kafka/consumer/cluster"
 	"github.com/companieshouse/chs.go/kafka/producer"
 	"github.com/companieshouse/chs.go/log"
 	"github.com/companieshouse/payment-reconciliation-consumer/config"
 	"github.com/companieshouse/payment-reconciliation-consumer/dao"
 	"github.com/companieshouse/payment-reconciliation-consumer/data"
+	"github.com/companieshouse/payment-reconciliation-consumer/masking"
+	"github.com/companieshouse/payment-reconciliation-consumer/metrics"
 	"github.com/companieshouse/payment-reconciliation-consumer/models"
+	"github.com/companieshouse/payment-reconciliation-consumer/money"
 	"github.com/companieshouse/payment-reconciliation-consumer/payment"
 	_ "github.com/companieshouse/payment-reconciliation-consumer/testing"
 	"github.com/companieshouse/payment-reconciliation-consumer/testutil"
 	"github.com/companieshouse/payment-reconciliation-consumer/transformer"
 	"github.com/golang/mock/gomock"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	. "github.com/smartystreets/goconvey/convey"
 	"gopkg.in/yaml.v2"
 )
 
-const paymentsAPIUrl = "paymentsAPIUrl"
-const apiKey = "apiKey"
 const paymentResourceID = "paymentResourceID"
 const differentPaymentResourceID = "differentPaymentResourceID"
 const refundID = "refundId"
@@ -38,22 +40,24 @@ const productsLogKey = "products"
 
 var (
 	handleErrorCalled bool = false
+	sentMessages      []*sarama.ProducerMessage
 )
 
 func createMockService(productMap *config.ProductMap, mockPayment *payment.MockFetcher, mockTransformer *transformer.MockTransformer, mockDao *dao.MockDAO) *Service {
 
 	return &Service{
-		Producer:       createMockProducer(),
-		PpSchema:       getDefaultSchema(),
-		Payments:       mockPayment,
-		Transformer:    mockTransformer,
-		DAO:            mockDao,
-		PaymentsAPIURL: paymentsAPIUrl,
-		APIKey:         apiKey,
-		ProductMap:     productMap,
-		Client:         &http.Client{},
-		StopAtOffset:   int64(-1),
-		Topic:          "test",
+		Handler: &Handler{
+			Payments:    mockPayment,
+			Transformer: mockTransformer,
+			DAO:         mockDao,
+			ProductMaps: createProductMapStore(productMap),
+		},
+		Producer:     createMockProducer(),
+		PpSchema:     getDefaultSchema(),
+		Decode:       AvroDecoder(getDefaultSchema()),
+		StopAtOffset: int64(-1),
+		Topic:        "test",
+		ErrorTopic:   "test-error",
 		HandleError: func(err error, offset int64, str interface{}) error {
 			handleErrorCalled = true
 			return err
@@ -68,33 +72,30 @@ func createMockServiceWithRealTransformer(productMap *config.ProductMap,
 	mockDao *dao.MockDAO) *Service {
 
 	return &Service{
-		Producer:       createMockProducer(),
-		PpSchema:       getDefaultSchema(),
-		Payments:       mockPayment,
-		Transformer:    transformer.New(),
-		DAO:            mockDao,
-		PaymentsAPIURL: paymentsAPIUrl,
-		APIKey:         apiKey,
-		ProductMap:     productMap,
-		Client:         &http.Client{},
-		StopAtOffset:   int64(-1),
-		Topic:          "test",
+		Handler: &Handler{
+			Payments:    mockPayment,
+			Transformer: transformer.New(createMaskingPolicy()),
+			DAO:         mockDao,
+			ProductMaps: createProductMapStore(productMap),
+		},
+		Producer:     createMockProducer(),
+		PpSchema:     getDefaultSchema(),
+		Decode:       AvroDecoder(getDefaultSchema()),
+		StopAtOffset: int64(-1),
+		Topic:        "test",
 	}
 }
-func createMockConsumerWithPaymentMessage(paymentId string) *consumer.GroupConsumer {
-	return createMockConsumerWithMessage(paymentId, "")
+func createSourceWithPaymentMessage(paymentId string) *ChannelSource {
+	return createSourceWithMessage(paymentId, "")
 }
 
-func createMockConsumerWithRefundMessage(paymentId string, refundId string) *consumer.GroupConsumer {
-	return createMockConsumerWithMessage(paymentId, refundId)
+func createSourceWithRefundMessage(paymentId string, refundId string) *ChannelSource {
+	return createSourceWithMessage(paymentId, refundId)
 }
 
-func createMockConsumerWithMessage(paymentId string, refundId string) *consumer.GroupConsumer {
-
-	return &consumer.GroupConsumer{
-		GConsumer: MockConsumer{paymentId: paymentId, RefundId: refundId},
-		Group:     MockGroup{},
-	}
+func createSourceWithMessage(paymentId string, refundId string) *ChannelSource {
+	bytes, _ := MockSchema.Marshal(data.PaymentProcessed{ResourceURI: paymentId, RefundId: refundId})
+	return NewChannelSource(&sarama.ConsumerMessage{Value: bytes})
 }
 
 func createMockProducer() *producer.Producer {
@@ -104,6 +105,25 @@ func createMockProducer() *producer.Producer {
 	}
 }
 
+func createMaskingPolicy() *masking.Policy {
+	policy, err := masking.Load(masking.PolicyFile)
+	if err != nil {
+		log.Error(fmt.Errorf("error initialising masking policy: %s", err), nil)
+	}
+	return policy
+}
+
+// createProductMapStore holds productMap in a store which is never loaded from a file
+func createProductMapStore(productMap *config.ProductMap) *config.ProductMapStore {
+	store := config.NewProductMapStore("")
+	if productMap != nil {
+		if err := store.Set(productMap); err != nil {
+			log.Error(fmt.Errorf("error storing productMap: %s", err), nil)
+		}
+	}
+	return store
+}
+
 func createProductMap() (*config.ProductMap, error) {
 	var productMap *config.ProductMap
 
@@ -144,45 +164,9 @@ func (m MockProducer) Close() error {
 	return nil
 }
 
-type MockConsumer struct {
-	paymentId string
-	RefundId  string
-}
-
-func (m MockConsumer) prepareTestKafkaMessage() ([]byte, error) {
-	return MockSchema.Marshal(data.PaymentProcessed{ResourceURI: m.paymentId, RefundId: m.RefundId})
-}
-
-func (m MockConsumer) Close() error {
-	return nil
-}
-
-func (m MockConsumer) Messages() <-chan *sarama.ConsumerMessage {
-	out := make(chan *sarama.ConsumerMessage)
-
-	bytes, _ := m.prepareTestKafkaMessage()
-	go func() {
-		out <- &sarama.ConsumerMessage{
-			Value: bytes,
-		}
-		close(out)
-	}()
-
-	return out
-}
-
-func (m MockConsumer) Errors() <-chan error {
-	return nil
-}
-
-type MockGroup struct{}
-
-func (m MockGroup) MarkOffset(msg *sarama.ConsumerMessage, metadata string) {
-
-}
-
-func (m MockGroup) CommitOffsets() error {
-	return nil
+func (m MockProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	sentMessages = append(sentMessages, msg)
+	return 0, 0, nil
 }
 
 func TestUnitStart(t *testing.T) {
@@ -211,7 +195,7 @@ func TestUnitStart(t *testing.T) {
 		svc := createMockService(productMap, mockPayment, mockTransformer, mockDao)
 
 		Convey("Given a message is readily available for the service to consume", func() {
-			svc.Consumer = createMockConsumerWithPaymentMessage(paymentResourceID)
+			svc.Source = createSourceWithPaymentMessage(paymentResourceID)
 
 			Convey("When the payment corresponding to the message is fetched successfully", func() {
 
@@ -223,20 +207,20 @@ func TestUnitStart(t *testing.T) {
 					Costs: []data.Cost{cost},
 				}
 
-				mockPayment.EXPECT().GetPayment(paymentsAPIUrl+"/payments/"+paymentResourceID, svc.Client, apiKey).DoAndReturn(func(paymentAPIURL string, HTTPClient *http.Client, apiKey string) (data.PaymentResponse, int, error) {
+				mockPayment.EXPECT().GetPayment(gomock.Any(), paymentResourceID).DoAndReturn(func(ctx context.Context, paymentID string) (data.PaymentResponse, int, error) {
 					endConsumerProcess(svc, c)
 
 					return pr, 200, nil
 				})
 
 				Convey("But payment details are never fetched", func() {
-					mockPayment.EXPECT().GetPaymentDetails(paymentsAPIUrl+"/private/payments/"+paymentResourceID+"/payment-details", svc.Client, apiKey).Times(0)
+					mockPayment.EXPECT().GetPaymentDetails(gomock.Any(), paymentResourceID).Times(0)
 
 					Convey("And no Eshu resource is ever constructed", func() {
-						mockTransformer.EXPECT().GetEshuResources(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+						mockTransformer.EXPECT().GetEshuResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
 
 						Convey("Nor is a transactions resource created", func() {
-							mockTransformer.EXPECT().GetTransactionResources(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+							mockTransformer.EXPECT().GetTransactionResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
 
 							svc.Start(wg, c)
 						})
@@ -275,80 +259,43 @@ func TestUnitStart(t *testing.T) {
 	})
 }
 
-func TestUnitMaskSensitiveFields(t *testing.T) {
-	ctrl := gomock.NewController(t)
-
-	mockPayment := payment.NewMockFetcher(ctrl)
-	mockTransformer := transformer.NewMockTransformer(ctrl)
-	mockDao := dao.NewMockDAO(ctrl)
-
-	productMap, err := createProductMap()
-	if err != nil {
-		log.Error(fmt.Errorf("error initialising productMap: %s", err), nil)
-	}
+func TestUnitStartFromSource(t *testing.T) {
 
-	svc := createMockService(productMap, mockPayment, mockTransformer, mockDao)
-
-	created := data.Created{
-		Email: "test@ch.gov.uk",
-	}
-
-	cost := data.Cost{
-		ClassOfPayment: []string{data.DataMaintenance},
-		ProductType:    "pro-app-1",
-	}
-
-	pdr := data.PaymentResponse{
-		CompanyNumber: "123456",
-		CreatedBy:     created,
-		Costs:         []data.Cost{cost},
-	}
-
-	Convey("test successful masking of sensitive fields ", t, func() {
-		svc.MaskSensitiveFields(&pdr)
-
-		So(pdr.CompanyNumber, ShouldEqual, "")
-		So(pdr.CreatedBy.Email, ShouldEqual, "")
-	})
-
-}
-
-func TestUnitDoNotMaskNormalFields(t *testing.T) {
 	ctrl := gomock.NewController(t)
-
-	mockPayment := payment.NewMockFetcher(ctrl)
-	mockTransformer := transformer.NewMockTransformer(ctrl)
-	mockDao := dao.NewMockDAO(ctrl)
+	defer ctrl.Finish()
 
 	productMap, err := createProductMap()
 	if err != nil {
 		log.Error(fmt.Errorf("error initialising productMap: %s", err), nil)
 	}
 
-	svc := createMockService(productMap, mockPayment, mockTransformer, mockDao)
-
-	created := data.Created{
-		Email: "test@ch.gov.uk",
-	}
-
-	cost := data.Cost{
-		ClassOfPayment: []string{data.DataMaintenance},
-		ProductType:    "cic-report",
-	}
-
-	pdr := data.PaymentResponse{
-		CompanyNumber: "123456",
-		CreatedBy:     created,
-		Costs:         []data.Cost{cost},
-	}
-
-	Convey("test successful masking of sensitive fields ", t, func() {
-		svc.MaskSensitiveFields(&pdr)
+	Convey("Given a source holding two messages", t, func() {
+		mockPayment := payment.NewMockFetcher(ctrl)
+		svc := createMockService(productMap, mockPayment, transformer.NewMockTransformer(ctrl), dao.NewMockDAO(ctrl))
+		paymentMessage := <-createSourceWithPaymentMessage(paymentResourceID).Messages()
+		undecodable := &sarama.ConsumerMessage{Offset: 1, Value: []byte{0xff}}
+		source := NewChannelSource(paymentMessage, undecodable)
+		svc.Source = source
+
+		penalty := data.PaymentResponse{Costs: []data.Cost{{ClassOfPayment: []string{data.Penalty}}}}
+		mockPayment.EXPECT().GetPayment(gomock.Any(), paymentResourceID).Return(penalty, 200, nil)
+
+		Convey("When the service is started then every message is processed and acknowledged", func() {
+			wg := &sync.WaitGroup{}
+			wg.Add(1)
+			c := make(chan os.Signal)
+			go svc.Start(wg, c)
+
+			for deadline := time.Now().Add(5 * time.Second); len(source.Acked()) < 2 && time.Now().Before(deadline); {
+				time.Sleep(10 * time.Millisecond)
+			}
+			c <- os.Interrupt
+			wg.Wait()
 
-		So(pdr.CompanyNumber, ShouldEqual, "123456")
-		So(pdr.CreatedBy.Email, ShouldEqual, "test@ch.gov.uk")
+			So(source.Acked(), ShouldHaveLength, 2)
+			So(source.Acked()[1], ShouldEqual, undecodable)
+		})
 	})
-
 }
 
 func TestUnitCertifiedCopies(t *testing.T) {
@@ -376,86 +323,105 @@ func TestUnitCertifiedCopies(t *testing.T) {
 				productMap,
 				testutil.CertifiedCopiesSingleCostOrderGetPaymentSessionResponse)
 		})
+}
 
-	Convey("HandleError invoked to handle errors", t, func() {
+func TestUnitRoute(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
 
-		mockPayment := payment.NewMockFetcher(ctrl)
-		mockTransformer := transformer.NewMockTransformer(ctrl)
-		mockDao := dao.NewMockDAO(ctrl)
+	productMap, err := createProductMap()
+	if err != nil {
+		log.Error(fmt.Errorf("error initialising productMap: %s", err), nil)
+	}
 
-		svc := createMockService(productMap, mockPayment, mockTransformer, mockDao)
+	mockPayment := payment.NewMockFetcher(ctrl)
+	mockTransformer := transformer.NewMockTransformer(ctrl)
+	mockDao := dao.NewMockDAO(ctrl)
 
-		message := sarama.ConsumerMessage{Offset: 1}
-		paymentResponse := data.PaymentResponse{}
-		details := data.PaymentDetailsResponse{}
-		pp := data.PaymentProcessed{
-			ResourceURI: "paymentResponse ID",
-		}
-		mockError := errors.New("test-simulated mock error")
+	svc := createMockService(productMap, mockPayment, mockTransformer, mockDao)
+	message := &sarama.ConsumerMessage{Offset: 1, Topic: "test", Value: []byte("message")}
+	pp := data.PaymentProcessed{ResourceURI: paymentResourceID}
+	mockError := errors.New("test-simulated mock error")
+
+	Convey("Given a message is routed", t, func() {
+		handleErrorCalled = false
+		sentMessages = nil
+
+		Convey("When it was reconciled it is neither retried nor sent to the error topic", func() {
+			svc.route(message, pp, reconciledPayment(nil, nil, nil))
+			So(handleErrorCalled, ShouldBeFalse)
+			So(sentMessages, ShouldBeEmpty)
+		})
 
-		Convey("HandleError invoked to handle error creating eshus", func() {
+		Convey("When it was skipped it is neither retried nor sent to the error topic", func() {
+			svc.route(message, pp, skipped("payment is not reconcilable"))
+			So(handleErrorCalled, ShouldBeFalse)
+			So(sentMessages, ShouldBeEmpty)
+		})
 
-			// Given
-			handleErrorCalled = false
-			mockTransformer.EXPECT().
-				GetEshuResources(paymentResponse, details, pp.ResourceURI).
-				Return(nil, mockError).
-				Times(1)
+		Convey("When it failed with a retryable failure it is retried", func() {
+			svc.route(message, pp, retryableFailure(mockError))
+			So(handleErrorCalled, ShouldBeTrue)
+			So(sentMessages, ShouldBeEmpty)
+		})
 
-			// When
-			svc.getEshuResources(&message, paymentResponse, details, pp)
+		Convey("When it failed with a permanent failure it is sent straight to the error topic", func() {
+			svc.route(message, pp, permanentFailure(mockError))
+			So(handleErrorCalled, ShouldBeFalse)
+			So(len(sentMessages), ShouldEqual, 1)
+			So(sentMessages[0].Topic, ShouldEqual, "test-error")
+			So(sentMessages[0].Value, ShouldResemble, sarama.ByteEncoder("message"))
+		})
 
-			// Then
-			So(handleErrorCalled, ShouldEqual, true)
+		Convey("When it failed during a dry run it is neither retried nor sent to the error topic", func() {
+			svc.Handler.DryRun = true
+			defer func() { svc.Handler.DryRun = false }()
 
+			svc.route(message, pp, retryableFailure(mockError))
+			svc.route(message, pp, permanentFailure(mockError))
+			So(handleErrorCalled, ShouldBeFalse)
+			So(sentMessages, ShouldBeEmpty)
 		})
 
-		Convey("HandleError invoked to handle error saving eshus", func() {
-
-			// Given
-			handleErrorCalled = false
-			eshus := []models.EshuResourceDao{{}}
-			mockDao.EXPECT().CreateEshuResource(&eshus[0]).Return(mockError).Times(1)
+		Convey("Then the outcome and offset are recorded in the metrics", func() {
+			outcome := skipped("payment is not reconcilable").describing(data.PaymentResponse{
+				Costs: []data.Cost{{ClassOfPayment: []string{data.Penalty}, ProductType: "penalty-lfp"}},
+			})
+			counter := metrics.Messages.WithLabelValues("skipped", "payment is not reconcilable", data.Penalty, "penalty-lfp")
+			before := promtestutil.ToFloat64(counter)
 
-			// When
-			svc.saveEshuResources(&message, eshus, pp)
+			svc.route(message, pp, outcome)
 
-			// Then
-			So(handleErrorCalled, ShouldEqual, true)
+			So(promtestutil.ToFloat64(counter), ShouldEqual, before+1)
+			So(promtestutil.ToFloat64(metrics.LastProcessedOffset.WithLabelValues("test")), ShouldEqual, 1)
 		})
+	})
+}
 
-		Convey("HandleError invoked to handle error creating transactions", func() {
-
-			// Given
-			handleErrorCalled = false
-			mockTransformer.EXPECT().
-				GetTransactionResources(paymentResponse, details, pp.ResourceURI).
-				Return(nil, mockError).
-				Times(1)
-
-			// When
-			svc.getTransactionResources(&message, paymentResponse, details, pp)
+func TestUnitProcess(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
 
-			// Then
-			So(handleErrorCalled, ShouldEqual, true)
+	productMap, err := createProductMap()
+	if err != nil {
+		log.Error(fmt.Errorf("error initialising productMap: %s", err), nil)
+	}
 
-		})
+	mockPayment := payment.NewMockFetcher(ctrl)
+	mockTransformer := transformer.NewMockTransformer(ctrl)
+	mockDao := dao.NewMockDAO(ctrl)
 
-		Convey("HandleError invoked to handle error saving transactions", func() {
+	svc := createMockService(productMap, mockPayment, mockTransformer, mockDao)
 
-			// Given
-			handleErrorCalled = false
-			txns := []models.PaymentTransactionsResourceDao{{}}
-			mockDao.EXPECT().CreatePaymentTransactionsResource(&txns[0]).Return(mockError).Times(1)
+	Convey("A message that cannot be decoded is a permanent failure and the payment is never fetched", t, func() {
+		mockPayment.EXPECT().GetPayment(gomock.Any(), gomock.Any()).Times(0)
 
-			// When
-			svc.saveTransactionResources(&message, txns, pp)
+		var pp data.PaymentProcessed
+		outcome := svc.process(context.Background(), &sarama.ConsumerMessage{Value: []byte{0xff}}, &pp)
 
-			// Then
-			So(handleErrorCalled, ShouldEqual, true)
-		})
+		So(outcome.Kind, ShouldEqual, PermanentFailure)
+		So(outcome.Err, ShouldNotBeNil)
 	})
-
 }
 
 func TestUnitCheckSkipGoneResource(t *testing.T) {
@@ -467,8 +433,6 @@ func TestUnitCheckSkipGoneResource(t *testing.T) {
 		log.Error(fmt.Errorf("error initialising productMap: %s", err), nil)
 	}
 
-	message := &sarama.ConsumerMessage{Offset: 1, Topic: "test topic"}
-
 	mockPayment := payment.NewMockFetcher(ctrl)
 	mockTransformer := transformer.NewMockTransformer(ctrl)
 	mockDao := dao.NewMockDAO(ctrl)
@@ -480,16 +444,16 @@ func TestUnitCheckSkipGoneResource(t *testing.T) {
 		Convey("When SkipGoneResourceId is empty", func() {
 			svc.SkipGoneResourceId = ""
 			Convey("Then checkSkipGoneResource should return true", func() {
-				So(svc.checkSkipGoneResource(paymentResourceID, message), ShouldEqual, true)
+				So(svc.checkSkipGoneResource(paymentResourceID), ShouldEqual, true)
 			})
 		})
 		Convey("When SkipGoneResourceId is set", func() {
 			svc.SkipGoneResourceId = paymentResourceID
 			Convey("Then checkSkipGoneResource should return true when the payment ids match", func() {
-				So(svc.checkSkipGoneResource(paymentResourceID, message), ShouldEqual, true)
+				So(svc.checkSkipGoneResource(paymentResourceID), ShouldEqual, true)
 			})
 			Convey("Then checkSkipGoneResource should return false when the payment ids do not match", func() {
-				So(svc.checkSkipGoneResource(differentPaymentResourceID, message), ShouldEqual, false)
+				So(svc.checkSkipGoneResource(differentPaymentResourceID), ShouldEqual, false)
 			})
 		})
 	})
@@ -499,16 +463,16 @@ func TestUnitCheckSkipGoneResource(t *testing.T) {
 		Convey("When SkipGoneResourceId is empty", func() {
 			svc.SkipGoneResourceId = ""
 			Convey("Then checkSkipGoneResource should return false", func() {
-				So(svc.checkSkipGoneResource(paymentResourceID, message), ShouldEqual, false)
+				So(svc.checkSkipGoneResource(paymentResourceID), ShouldEqual, false)
 			})
 		})
 		Convey("When SkipGoneResourceId is set", func() {
 			svc.SkipGoneResourceId = paymentResourceID
 			Convey("Then checkSkipGoneResource should return false when the payment ids match", func() {
-				So(svc.checkSkipGoneResource(paymentResourceID, message), ShouldEqual, false)
+				So(svc.checkSkipGoneResource(paymentResourceID), ShouldEqual, false)
 			})
 			Convey("Then checkSkipGoneResource should return false when the payment ids do not match", func() {
-				So(svc.checkSkipGoneResource(differentPaymentResourceID, message), ShouldEqual, false)
+				So(svc.checkSkipGoneResource(differentPaymentResourceID), ShouldEqual, false)
 			})
 		})
 	})
@@ -528,11 +492,10 @@ func TestUnitSkipGoneResource(t *testing.T) {
 	mockDao := dao.NewMockDAO(ctrl)
 
 	svc := createMockService(productMap, mockPayment, mockTransformer, mockDao)
-	message := &sarama.ConsumerMessage{Offset: 1, Topic: "test topic"}
 	notPaymentErrResourceGone := errors.New("different error")
 
 	Convey("Given the error does not equal payment.ErrResourceGone", t, func() {
-		skipGoneResource := svc.skipGoneResource(notPaymentErrResourceGone, paymentResourceID, message)
+		skipGoneResource := svc.skipGoneResource(notPaymentErrResourceGone, paymentResourceID)
 		Convey("The value of skipGoneResource should be false", func() {
 			So(skipGoneResource, ShouldEqual, false)
 		})
@@ -541,14 +504,14 @@ func TestUnitSkipGoneResource(t *testing.T) {
 	Convey("Given the error equals payment.ErrResourceGone", t, func() {
 		Convey("When the svc.checkSkipGoneResource returns true", func() {
 			svc.SkipGoneResource = true
-			skipGoneResource := svc.skipGoneResource(payment.ErrResourceGone, paymentResourceID, message)
+			skipGoneResource := svc.skipGoneResource(payment.ErrResourceGone, paymentResourceID)
 			Convey("The value of skipGoneResource should be true", func() {
 				So(skipGoneResource, ShouldEqual, true)
 			})
 		})
 		Convey("When the svc.checkSkipGoneResource returns false", func() {
 			svc.SkipGoneResource = false
-			skipGoneResource := svc.skipGoneResource(payment.ErrResourceGone, paymentResourceID, message)
+			skipGoneResource := svc.skipGoneResource(payment.ErrResourceGone, paymentResourceID)
 			Convey("The value of skipGoneResource should be false", func() {
 				So(skipGoneResource, ShouldEqual, false)
 			})
@@ -589,7 +552,7 @@ func processingOfPaymentKafkaMessageCreatesReconciliationRecords(
 
 	Convey("Given a message is readily available for the service to consume", func() {
 
-		svc.Consumer = createMockConsumerWithPaymentMessage(paymentResourceID)
+		svc.Source = createSourceWithPaymentMessage(paymentResourceID)
 
 		Convey("When the payment corresponding to the message is fetched successfully", func() {
 
@@ -603,40 +566,40 @@ func processingOfPaymentKafkaMessageCreatesReconciliationRecords(
 				Costs:         []data.Cost{cost},
 			}
 
-			mockPayment.EXPECT().GetPayment(paymentsAPIUrl+"/payments/"+paymentResourceID, svc.Client, apiKey).Return(pr, 200, nil).Times(1)
+			mockPayment.EXPECT().GetPayment(gomock.Any(), paymentResourceID).Return(pr, 200, nil).Times(1)
 
 			Convey("And the payment details corresponding to the message are fetched successfully", func() {
 
 				pdr := data.PaymentDetailsResponse{
-					PaymentStatus: "accepted",
+					PaymentStatus:   "accepted",
+					TransactionDate: "2020-07-27T09:07:12.864Z",
 				}
-				mockPayment.EXPECT().GetPaymentDetails(paymentsAPIUrl+"/private/payments/"+paymentResourceID+"/payment-details", svc.Client, apiKey).Return(pdr, 200, nil).Times(1)
+				mockPayment.EXPECT().GetPaymentDetails(gomock.Any(), paymentResourceID).Return(pdr, 200, nil).Times(1)
 
 				Convey("Then an Eshu resource is constructed", func() {
 
 					ers := []models.EshuResourceDao{{}}
-					mockTransformer.EXPECT().GetEshuResources(pr, pdr, paymentResourceID).Return(ers, nil).Times(1)
-
-					Convey("And committed to the DB successfully", func() {
-						mockDao.EXPECT().CreateEshuResource(&ers[0]).Return(nil).Times(1)
+					mockTransformer.EXPECT().GetEshuResources(gomock.Any(), pr, pdr, paymentResourceID).Return(ers, nil).Times(1)
 
-						Convey("And a payment transactions resource is constructed", func() {
+					Convey("And a payment transactions resource is constructed", func() {
 
-							ptrs := []models.PaymentTransactionsResourceDao{{}}
-							mockTransformer.EXPECT().
-								GetTransactionResources(pr, pdr, paymentResourceID).Return(ptrs, nil).Times(1)
+						ptrs := []models.PaymentTransactionsResourceDao{{}}
+						mockTransformer.EXPECT().
+							GetTransactionResources(gomock.Any(), pr, pdr, paymentResourceID).Return(ptrs, nil).Times(1)
+						scrs := []models.SkippedCostResourceDao{}
+						mockTransformer.EXPECT().
+							GetSkippedCostResources(gomock.Any(), pr, pdr, paymentResourceID).Return(scrs, nil).Times(1)
 
-							Convey("Which is also committed to the DB successfully", func() {
+						Convey("And both are committed to the DB together successfully", func() {
 
-								mockDao.EXPECT().CreatePaymentTransactionsResource(&ptrs[0]).DoAndReturn(func(ptr *models.PaymentTransactionsResourceDao) error {
+							mockDao.EXPECT().CreatePaymentResources(ers, ptrs, scrs).DoAndReturn(func(eshus []models.EshuResourceDao, txns []models.PaymentTransactionsResourceDao, skippedCosts []models.SkippedCostResourceDao) error {
 
-									// Since this is the last thing the service does, we send a signal to kill the consumer process gracefully
-									endConsumerProcess(svc, c)
-									return nil
-								})
-
-								svc.Start(wg, c)
+								// Since this is the last thing the service does, we send a signal to kill the consumer process gracefully
+								endConsumerProcess(svc, c)
+								return nil
 							})
+
+							svc.Start(wg, c)
 						})
 					})
 				})
@@ -664,7 +627,7 @@ func processingOfRefundKafkaMessageCreatesReconciliationRecords(
 
 	Convey("Given a message is readily available for the service to consume", func() {
 
-		svc.Consumer = createMockConsumerWithRefundMessage(paymentResourceID, refundID)
+		svc.Source = createSourceWithRefundMessage(paymentResourceID, refundID)
 
 		Convey("When the payment corresponding to the message is fetched successfully", func() {
 
@@ -685,19 +648,20 @@ func processingOfRefundKafkaMessageCreatesReconciliationRecords(
 				}},
 			}
 
-			mockPayment.EXPECT().GetPayment(paymentsAPIUrl+"/payments/"+paymentResourceID, svc.Client, apiKey).Return(pr, 200, nil).Times(1)
+			mockPayment.EXPECT().GetPayment(gomock.Any(), paymentResourceID).Return(pr, 200, nil).Times(1)
 
 			Convey("And the payment details corresponding to the message are fetched successfully", func() {
 
 				pdr := data.PaymentDetailsResponse{
-					PaymentStatus: "accepted",
+					PaymentStatus:   "accepted",
+					TransactionDate: "2020-07-27T09:07:12.864Z",
 				}
-				mockPayment.EXPECT().GetPaymentDetails(paymentsAPIUrl+"/private/payments/"+paymentResourceID+"/payment-details", svc.Client, apiKey).Return(pdr, 200, nil).Times(1)
+				mockPayment.EXPECT().GetPaymentDetails(gomock.Any(), paymentResourceID).Return(pdr, 200, nil).Times(1)
 
 				Convey("Then a Refund resource is constructed", func() {
 
 					refund := models.RefundResourceDao{}
-					mockTransformer.EXPECT().GetRefundResource(pr, pr.Refunds[0], paymentResourceID).Return(refund, nil).Times(1)
+					mockTransformer.EXPECT().GetRefundResource(gomock.Any(), pr, gomock.Any(), pr.Refunds[0], paymentResourceID).Return(refund, nil).Times(1)
 
 					Convey("And committed to the DB successfully", func() {
 						mockDao.EXPECT().CreateRefundResource(&refund).DoAndReturn(func(ptr *models.RefundResourceDao) error {
@@ -734,7 +698,7 @@ func processingOfRefundKafkaMessageWithSubmittedStatusCreatesReconciliationRecor
 
 	Convey("Given a message is readily available for the service to consume", func() {
 
-		svc.Consumer = createMockConsumerWithRefundMessage(paymentResourceID, refundID)
+		svc.Source = createSourceWithRefundMessage(paymentResourceID, refundID)
 
 		Convey("When the payment corresponding to the message is fetched successfully", func() {
 
@@ -755,14 +719,15 @@ func processingOfRefundKafkaMessageWithSubmittedStatusCreatesReconciliationRecor
 				}},
 			}
 
-			mockPayment.EXPECT().GetPayment(paymentsAPIUrl+"/payments/"+paymentResourceID, svc.Client, apiKey).Return(pr, 200, nil).Times(1)
+			mockPayment.EXPECT().GetPayment(gomock.Any(), paymentResourceID).Return(pr, 200, nil).Times(1)
 
 			Convey("And the payment details corresponding to the message are fetched successfully", func() {
 
 				pdr := data.PaymentDetailsResponse{
-					PaymentStatus: "accepted",
+					PaymentStatus:   "accepted",
+					TransactionDate: "2020-07-27T09:07:12.864Z",
 				}
-				mockPayment.EXPECT().GetPaymentDetails(paymentsAPIUrl+"/private/payments/"+paymentResourceID+"/payment-details", svc.Client, apiKey).Return(pdr, 200, nil).Times(1)
+				mockPayment.EXPECT().GetPaymentDetails(gomock.Any(), paymentResourceID).Return(pdr, 200, nil).Times(1)
 
 				Convey("Then a Refund status is fetched", func() {
 					refundResource := data.RefundResource{
@@ -773,12 +738,12 @@ func processingOfRefundKafkaMessageWithSubmittedStatusCreatesReconciliationRecor
 						ExternalRefundUrl: "",
 					}
 
-					mockPayment.EXPECT().GetLatestRefundStatus(paymentsAPIUrl+"/payments/"+paymentResourceID+"/refunds/"+refundID, svc.Client, apiKey).Return(&refundResource, 200, nil).Times(1)
+					mockPayment.EXPECT().RefreshRefund(gomock.Any(), paymentResourceID, refundID).Return(&refundResource, 200, nil).Times(1)
 
 					Convey("Then a Refund resource is constructed", func() {
 
 						refund := models.RefundResourceDao{}
-						mockTransformer.EXPECT().GetRefundResource(pr, refundResource, paymentResourceID).Return(refund, nil).Times(1)
+						mockTransformer.EXPECT().GetRefundResource(gomock.Any(), pr, gomock.Any(), refundResource, paymentResourceID).Return(refund, nil).Times(1)
 
 						Convey("And committed to the DB successfully", func() {
 							mockDao.EXPECT().CreateRefundResource(&refund).DoAndReturn(func(ptr *models.RefundResourceDao) error {
@@ -816,7 +781,7 @@ func processingOfRefundKafkaMessageWithMultipleRefundsCreatesReconciliationRecor
 
 	Convey("Given a message is readily available for the service to consume", func() {
 
-		svc.Consumer = createMockConsumerWithRefundMessage(paymentResourceID, refundID)
+		svc.Source = createSourceWithRefundMessage(paymentResourceID, refundID)
 
 		Convey("When the payment corresponding to the message is fetched successfully", func() {
 
@@ -846,19 +811,20 @@ func processingOfRefundKafkaMessageWithMultipleRefundsCreatesReconciliationRecor
 				},
 			}
 
-			mockPayment.EXPECT().GetPayment(paymentsAPIUrl+"/payments/"+paymentResourceID, svc.Client, apiKey).Return(pr, 200, nil).Times(1)
+			mockPayment.EXPECT().GetPayment(gomock.Any(), paymentResourceID).Return(pr, 200, nil).Times(1)
 
 			Convey("And the payment details corresponding to the message are fetched successfully", func() {
 
 				pdr := data.PaymentDetailsResponse{
-					PaymentStatus: "accepted",
+					PaymentStatus:   "accepted",
+					TransactionDate: "2020-07-27T09:07:12.864Z",
 				}
-				mockPayment.EXPECT().GetPaymentDetails(paymentsAPIUrl+"/private/payments/"+paymentResourceID+"/payment-details", svc.Client, apiKey).Return(pdr, 200, nil).Times(1)
+				mockPayment.EXPECT().GetPaymentDetails(gomock.Any(), paymentResourceID).Return(pdr, 200, nil).Times(1)
 
 				Convey("Then a Refund resource is constructed", func() {
 
 					refund := models.RefundResourceDao{}
-					mockTransformer.EXPECT().GetRefundResource(pr, pr.Refunds[0], paymentResourceID).Return(refund, nil).Times(1)
+					mockTransformer.EXPECT().GetRefundResource(gomock.Any(), pr, gomock.Any(), pr.Refunds[0], paymentResourceID).Return(refund, nil).Times(1)
 
 					Convey("And committed to the DB successfully", func() {
 						mockDao.EXPECT().CreateRefundResource(&refund).DoAndReturn(func(ptr *models.RefundResourceDao) error {
@@ -896,7 +862,7 @@ func processingOfUnsuccessfulRefundKafkaMessageWithIncorrectRefundIdDoesNotCreat
 
 	Convey("Given a message is readily available for the service to consume", func() {
 
-		svc.Consumer = createMockConsumerWithRefundMessage(paymentResourceID, refundID)
+		svc.Source = createSourceWithRefundMessage(paymentResourceID, refundID)
 
 		Convey("When the payment corresponding to the message is fetched successfully", func() {
 
@@ -917,14 +883,15 @@ func processingOfUnsuccessfulRefundKafkaMessageWithIncorrectRefundIdDoesNotCreat
 				}},
 			}
 
-			mockPayment.EXPECT().GetPayment(paymentsAPIUrl+"/payments/"+paymentResourceID, svc.Client, apiKey).Return(pr, 200, nil).Times(1)
+			mockPayment.EXPECT().GetPayment(gomock.Any(), paymentResourceID).Return(pr, 200, nil).Times(1)
 
 			Convey("And the payment details corresponding to the message are fetched successfully", func() {
 
 				pdr := data.PaymentDetailsResponse{
-					PaymentStatus: "accepted",
+					PaymentStatus:   "accepted",
+					TransactionDate: "2020-07-27T09:07:12.864Z",
 				}
-				mockPayment.EXPECT().GetPaymentDetails(paymentsAPIUrl+"/private/payments/"+paymentResourceID+"/payment-details", svc.Client, apiKey).DoAndReturn(func(paymentAPIURL string, HTTPClient *http.Client, apiKey string) (data.PaymentDetailsResponse, int, error) {
+				mockPayment.EXPECT().GetPaymentDetails(gomock.Any(), paymentResourceID).DoAndReturn(func(ctx context.Context, paymentID string) (data.PaymentDetailsResponse, int, error) {
 					endConsumerProcess(svc, c)
 
 					return pdr, 200, nil
@@ -933,7 +900,7 @@ func processingOfUnsuccessfulRefundKafkaMessageWithIncorrectRefundIdDoesNotCreat
 
 				Convey("Then a Refund resource is not constructed", func() {
 
-					mockTransformer.EXPECT().GetRefundResource(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+					mockTransformer.EXPECT().GetRefundResource(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
 
 					Convey("And not committed to the DB", func() {
 						mockDao.EXPECT().CreateRefundResource(gomock.Any()).Times(0)
@@ -965,7 +932,7 @@ func processingOfUnsuccessfulRefundKafkaMessageDoesNotCreateReconciliationRecord
 
 	Convey("Given a message is readily available for the service to consume", func() {
 
-		svc.Consumer = createMockConsumerWithRefundMessage(paymentResourceID, refundID)
+		svc.Source = createSourceWithRefundMessage(paymentResourceID, refundID)
 
 		Convey("When the payment corresponding to the message is fetched successfully", func() {
 
@@ -986,14 +953,15 @@ func processingOfUnsuccessfulRefundKafkaMessageDoesNotCreateReconciliationRecord
 				}},
 			}
 
-			mockPayment.EXPECT().GetPayment(paymentsAPIUrl+"/payments/"+paymentResourceID, svc.Client, apiKey).Return(pr, 200, nil).Times(1)
+			mockPayment.EXPECT().GetPayment(gomock.Any(), paymentResourceID).Return(pr, 200, nil).Times(1)
 
 			Convey("And the payment details corresponding to the message are fetched successfully", func() {
 
 				pdr := data.PaymentDetailsResponse{
-					PaymentStatus: "accepted",
+					PaymentStatus:   "accepted",
+					TransactionDate: "2020-07-27T09:07:12.864Z",
 				}
-				mockPayment.EXPECT().GetPaymentDetails(paymentsAPIUrl+"/private/payments/"+paymentResourceID+"/payment-details", svc.Client, apiKey).DoAndReturn(func(paymentAPIURL string, HTTPClient *http.Client, apiKey string) (data.PaymentDetailsResponse, int, error) {
+				mockPayment.EXPECT().GetPaymentDetails(gomock.Any(), paymentResourceID).DoAndReturn(func(ctx context.Context, paymentID string) (data.PaymentDetailsResponse, int, error) {
 					endConsumerProcess(svc, c)
 
 					return pdr, 200, nil
@@ -1002,7 +970,7 @@ func processingOfUnsuccessfulRefundKafkaMessageDoesNotCreateReconciliationRecord
 
 				Convey("Then a Refund resource is not constructed", func() {
 
-					mockTransformer.EXPECT().GetRefundResource(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+					mockTransformer.EXPECT().GetRefundResource(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
 
 					Convey("And not committed to the DB", func() {
 						mockDao.EXPECT().CreateRefundResource(gomock.Any()).Times(0)
@@ -1034,7 +1002,7 @@ func processingOfUnsuccessfulRefundKafkaMessageWithSubmittedDoesNotCreateReconci
 
 	Convey("Given a message is readily available for the service to consume", func() {
 
-		svc.Consumer = createMockConsumerWithRefundMessage(paymentResourceID, refundID)
+		svc.Source = createSourceWithRefundMessage(paymentResourceID, refundID)
 
 		Convey("When the payment corresponding to the message is fetched successfully", func() {
 
@@ -1055,14 +1023,15 @@ func processingOfUnsuccessfulRefundKafkaMessageWithSubmittedDoesNotCreateReconci
 				}},
 			}
 
-			mockPayment.EXPECT().GetPayment(paymentsAPIUrl+"/payments/"+paymentResourceID, svc.Client, apiKey).Return(pr, 200, nil).Times(1)
+			mockPayment.EXPECT().GetPayment(gomock.Any(), paymentResourceID).Return(pr, 200, nil).Times(1)
 
 			Convey("And the payment details corresponding to the message are fetched successfully", func() {
 
 				pdr := data.PaymentDetailsResponse{
-					PaymentStatus: "accepted",
+					PaymentStatus:   "accepted",
+					TransactionDate: "2020-07-27T09:07:12.864Z",
 				}
-				mockPayment.EXPECT().GetPaymentDetails(paymentsAPIUrl+"/private/payments/"+paymentResourceID+"/payment-details", svc.Client, apiKey).Return(pdr, 200, nil).Times(1)
+				mockPayment.EXPECT().GetPaymentDetails(gomock.Any(), paymentResourceID).Return(pdr, 200, nil).Times(1)
 
 				Convey("Then a Refund status is fetched", func() {
 					refundResource := data.RefundResource{
@@ -1073,7 +1042,7 @@ func processingOfUnsuccessfulRefundKafkaMessageWithSubmittedDoesNotCreateReconci
 						ExternalRefundUrl: "",
 					}
 
-					mockPayment.EXPECT().GetLatestRefundStatus(paymentsAPIUrl+"/payments/"+paymentResourceID+"/refunds/"+refundID, svc.Client, apiKey).DoAndReturn(func(paymentAPIURL string, HTTPClient *http.Client, apiKey string) (*data.RefundResource, int, error) {
+					mockPayment.EXPECT().RefreshRefund(gomock.Any(), paymentResourceID, refundID).DoAndReturn(func(ctx context.Context, paymentID, refundID string) (*data.RefundResource, int, error) {
 						endConsumerProcess(svc, c)
 
 						return &refundResource, 200, nil
@@ -1081,7 +1050,7 @@ func processingOfUnsuccessfulRefundKafkaMessageWithSubmittedDoesNotCreateReconci
 
 					Convey("Then a Refund resource is not constructed", func() {
 
-						mockTransformer.EXPECT().GetRefundResource(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+						mockTransformer.EXPECT().GetRefundResource(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
 
 						Convey("And not committed to the DB", func() {
 							mockDao.EXPECT().CreateRefundResource(gomock.Any()).Times(0)
@@ -1103,11 +1072,8 @@ func processingOfCertifiedCopiesPaymentKafkaMessageCreatesReconciliationRecords(
 	productMap *config.ProductMap,
 	responseBody string) {
 
-	paymentsAPI := payment.Fetch{}
-	mockHttpClient := testutil.
-		CreateMockClient(true, 200, responseBody)
-	paymentResponse, status, _ := paymentsAPI.GetPayment("http://test-url.com", mockHttpClient, "")
-	expectedNumberOfFilingHistoryDocumentCosts := len(paymentResponse.Costs)
+	var paymentResponse data.PaymentResponse
+	json.Unmarshal([]byte(responseBody), &paymentResponse)
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
@@ -1120,13 +1086,13 @@ func processingOfCertifiedCopiesPaymentKafkaMessageCreatesReconciliationRecords(
 
 	Convey("Given a message is readily available for the service to consume", func() {
 
-		svc.Consumer = createMockConsumerWithPaymentMessage(paymentResourceID)
+		svc.Source = createSourceWithPaymentMessage(paymentResourceID)
 
 		Convey("When the payment corresponding to the message is fetched successfully", func() {
 
 			mockPayment.EXPECT().
-				GetPayment(paymentsAPIUrl+"/payments/"+paymentResourceID, svc.Client, apiKey).
-				Return(paymentResponse, status, nil).
+				GetPayment(gomock.Any(), paymentResourceID).
+				Return(paymentResponse, 200, nil).
 				Times(1)
 
 			Convey("And the payment details corresponding to the message are fetched successfully", func() {
@@ -1136,9 +1102,7 @@ func processingOfCertifiedCopiesPaymentKafkaMessageCreatesReconciliationRecords(
 					TransactionDate: "2020-07-27T09:07:12.864Z",
 				}
 				mockPayment.EXPECT().
-					GetPaymentDetails(paymentsAPIUrl+"/private/payments/"+paymentResourceID+"/payment-details",
-						svc.Client,
-						apiKey).
+					GetPaymentDetails(gomock.Any(), paymentResourceID).
 					Return(paymentDetailsResponse, 200, nil).
 					Times(1)
 
@@ -1146,29 +1110,20 @@ func processingOfCertifiedCopiesPaymentKafkaMessageCreatesReconciliationRecords(
 
 					expectedTransactionDate, _ := time.Parse(time.RFC3339Nano, paymentDetailsResponse.TransactionDate)
 
-					Convey("And committed to the DB successfully", func() {
-
-						expectProductsToBeCreated(
-							mockDao,
-							expectedTransactionDate,
-							paymentResponse.Costs,
-							expectedNumberOfFilingHistoryDocumentCosts)
-
-						Convey("And payment transaction resources are constructed", func() {
+					Convey("Then payment transaction resources are constructed", func() {
 
-							Convey("Which are also committed to the DB successfully", func() {
+						Convey("And both are committed to the DB together successfully", func() {
 
-								expectTransactionsToBeCreated(
-									mockDao,
-									expectedTransactionDate,
-									paymentResponse.Costs,
-									c,
-									svc)
+							expectPaymentResourcesToBeCreated(
+								mockDao,
+								expectedTransactionDate,
+								paymentResponse.Costs,
+								c,
+								svc)
 
-								log.Info("Starting service under test")
-								svc.Start(wg, c)
-								log.Info("Completing test")
-							})
+							log.Info("Starting service under test")
+							svc.Start(wg, c)
+							log.Info("Completing test")
 						})
 					})
 				})
@@ -1177,34 +1132,45 @@ func processingOfCertifiedCopiesPaymentKafkaMessageCreatesReconciliationRecords(
 	})
 }
 
-func expectProductsToBeCreated(
+func expectPaymentResourcesToBeCreated(
 	mockDao *dao.MockDAO,
 	expectedTransactionDate time.Time,
 	expectedCosts []data.Cost,
-	expectedNumberOfFilingHistoryDocumentCosts int) {
-
-	mockDao.EXPECT().
-		CreateEshuResource(expectedProduct(expectedTransactionDate, expectedProductCode(expectedCosts[0]))).
-		Return(nil).
-		Times(1)
+	c chan os.Signal,
+	svc *Service) {
 
-	if len(expectedCosts) > 1 {
-		mockDao.EXPECT().
-			CreateEshuResource(expectedProduct(expectedTransactionDate, expectedProductCode(expectedCosts[1]))).
-			Return(nil).
-			Times(expectedNumberOfFilingHistoryDocumentCosts - 1)
+	var products []models.EshuResourceDao
+	var transactions []models.PaymentTransactionsResourceDao
+	for i, cost := range expectedCosts {
+		amount, err := money.Parse(cost.Amount)
+		So(err, ShouldBeNil)
+		products = append(products, expectedProduct(expectedTransactionDate, expectedProductCode(cost), amount, i))
+		transactions = append(transactions, expectedTransaction(expectedTransactionDate, amount, i))
 	}
 
+	mockDao.EXPECT().
+		CreatePaymentResources(products, transactions, []models.SkippedCostResourceDao{}).
+		DoAndReturn(func(eshus []models.EshuResourceDao, txns []models.PaymentTransactionsResourceDao, skippedCosts []models.SkippedCostResourceDao) error {
+			// Since this is the last thing the service does, we send a signal to kill
+			// the consumer process gracefully.
+			log.Info(fmt.Sprintf("Closing consumer after saving %d costs", len(txns)))
+			endConsumerProcess(svc, c)
+			return nil
+		}).
+		Times(1)
 }
 
-func expectedProduct(expectedTransactionDate time.Time, expectedProductCode int) *models.EshuResourceDao {
-	return &models.EshuResourceDao{
+func expectedProduct(expectedTransactionDate time.Time, expectedProductCode int, expectedAmount money.Pence, costLine int) models.EshuResourceDao {
+	return models.EshuResourceDao{
 		PaymentRef:      "XpaymentResourceID",
 		ProductCode:     expectedProductCode,
 		CompanyNumber:   "00006400",
 		FilingDate:      "",
 		MadeUpdate:      "",
 		TransactionDate: expectedTransactionDate,
+		CostLine:        costLine,
+		Amount:          expectedAmount.String(),
+		AmountPence:     expectedAmount,
 	}
 }
 
@@ -1216,67 +1182,14 @@ func expectedProductCode(cost data.Cost) int {
 	return expectedProductCode
 }
 
-func expectTransactionsToBeCreated(
-	mockDao *dao.MockDAO,
-	expectedTransactionDate time.Time,
-	expectedCosts []data.Cost,
-	c chan os.Signal,
-	svc *Service) {
-
-	numberOfCosts := len(expectedCosts)
-
-	mockDao.EXPECT().
-		CreatePaymentTransactionsResource(
-			expectedTransaction(expectedTransactionDate, expectedCosts[0].Amount)).
-		DoAndReturn(func(ptr *models.PaymentTransactionsResourceDao) error {
-			return endConsumerProcessIfLastTransactionCreated(numberOfCosts, 0, svc, c)
-		}).
-		Times(1)
-
-	if numberOfCosts > 1 {
-		mockDao.EXPECT().
-			CreatePaymentTransactionsResource(
-				expectedTransaction(expectedTransactionDate, expectedCosts[1].Amount)).
-			DoAndReturn(func(ptr *models.PaymentTransactionsResourceDao) error {
-				return endConsumerProcessIfLastTransactionCreated(numberOfCosts, 1, svc, c)
-			}).
-			Times(1)
-	} else {
-		return
-	}
-
-	if numberOfCosts > 2 {
-		mockDao.EXPECT().
-			CreatePaymentTransactionsResource(
-				expectedTransaction(expectedTransactionDate, expectedCosts[2].Amount)).
-			DoAndReturn(func(ptr *models.PaymentTransactionsResourceDao) error {
-				return endConsumerProcessIfLastTransactionCreated(numberOfCosts, 2, svc, c)
-			}).
-			Times(1)
-	} else {
-		return
-	}
-
-	if numberOfCosts > 3 {
-		mockDao.EXPECT().
-			CreatePaymentTransactionsResource(
-				expectedTransaction(expectedTransactionDate, expectedCosts[3].Amount)).
-			DoAndReturn(func(ptr *models.PaymentTransactionsResourceDao) error {
-				return endConsumerProcessIfLastTransactionCreated(numberOfCosts, 3, svc, c)
-			}).
-			Times(1)
-	} else {
-		return
-	}
-}
-
-func expectedTransaction(expectedTransactionDate time.Time, expectedCost string) *models.PaymentTransactionsResourceDao {
-	return &models.PaymentTransactionsResourceDao{
+func expectedTransaction(expectedTransactionDate time.Time, expectedAmount money.Pence, costLine int) models.PaymentTransactionsResourceDao {
+	return models.PaymentTransactionsResourceDao{
 		TransactionID:     "XpaymentResourceID",
 		TransactionDate:   expectedTransactionDate,
 		Email:             "demo@ch.gov.uk",
 		PaymentMethod:     "GovPay",
-		Amount:            expectedCost,
+		Amount:            expectedAmount.String(),
+		AmountPence:       expectedAmount,
 		CompanyNumber:     "00006400",
 		TransactionType:   "Immediate bill",
 		OrderReference:    "Payments reconciliation testing payment session ref GCI-1312",
@@ -1284,18 +1197,6 @@ func expectedTransaction(expectedTransactionDate time.Time, expectedCost string)
 		UserID:            "system",
 		OriginalReference: "",
 		DisputeDetails:    "",
+		CostLine:          costLine,
 	}
 }
-
-// Ends the consumer process if the transaction for the last cost has been created.
-// Returns nil error for test code legibility.
-func endConsumerProcessIfLastTransactionCreated(numberOfCosts int, costIndex int, svc *Service, c chan os.Signal) error {
-	log.Info(fmt.Sprintf("CreatePaymentTransactionsResource() invocation %d", costIndex+1))
-	if costIndex == numberOfCosts-1 {
-		// Since this is the last thing the service does, we send a signal to kill
-		// the consumer process gracefully.
-		log.Info(fmt.Sprintf("Closing consumer after invocation %d", costIndex+1))
-		endConsumerProcess(svc, c)
-	}
-	return nil
-}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	consumer "github.com/companieshouse/chs.go/kafka/consumer/cluster"
+)
+
+// maxLineSize is the longest line a JSONLinesSource will read
+const maxLineSize = 1024 * 1024
+
+// MessageSource is a source of payment-processed messages. Each message delivered is acknowledged once it has been
+// processed, so that it is not delivered again.
+type MessageSource interface {
+	// Messages returns the messages to process. The channel is closed once the source has no more messages.
+	Messages() <-chan *sarama.ConsumerMessage
+	// Errors returns the errors reported by the source
+	Errors() <-chan error
+	// Ack acknowledges that msg has been processed
+	Ack(msg *sarama.ConsumerMessage) error
+	// Close stops the source
+	Close() error
+}
+
+// GroupConsumerSource is a MessageSource which consumes from a consumer group coordinated through ZooKeeper
+type GroupConsumerSource struct {
+	*consumer.GroupConsumer
+}
+
+// Ack marks msg as processed and commits its offset
+func (s *GroupConsumerSource) Ack(msg *sarama.ConsumerMessage) error {
+	s.MarkOffset(msg, "")
+	return s.CommitOffsets()
+}
+
+// ChannelSource is a MessageSource which delivers messages held in memory, and records those acknowledged
+type ChannelSource struct {
+	messages chan *sarama.ConsumerMessage
+
+	mu    sync.Mutex
+	acked []*sarama.ConsumerMessage
+}
+
+// NewChannelSource returns a ChannelSource which delivers messages in order and then has no more messages
+func NewChannelSource(messages ...*sarama.ConsumerMessage) *ChannelSource {
+	s := &ChannelSource{messages: make(chan *sarama.ConsumerMessage, len(messages))}
+	for _, message := range messages {
+		s.messages <- message
+	}
+	close(s.messages)
+	return s
+}
+
+// Messages returns the messages held
+func (s *ChannelSource) Messages() <-chan *sarama.ConsumerMessage {
+	return s.messages
+}
+
+// Errors returns a channel which never receives an error
+func (s *ChannelSource) Errors() <-chan error {
+	return nil
+}
+
+// Ack records that msg has been acknowledged
+func (s *ChannelSource) Ack(msg *sarama.ConsumerMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acked = append(s.acked, msg)
+	return nil
+}
+
+// Acked returns the messages acknowledged, in the order they were acknowledged
+func (s *ChannelSource) Acked() []*sarama.ConsumerMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*sarama.ConsumerMessage(nil), s.acked...)
+}
+
+// Close does nothing
+func (s *ChannelSource) Close() error {
+	return nil
+}
+
+// JSONLinesSource is a MessageSource which reads messages from a file holding a JSON encoded payment-processed event
+// on each line, for replaying captured events. The messages are decoded with DecodeJSON. Each message's topic is the
+// path of the file and its offset is its line number. Blank lines are skipped.
+type JSONLinesSource struct {
+	file     *os.File
+	messages chan *sarama.ConsumerMessage
+	errors   chan error
+	closing  chan struct{}
+	once     sync.Once
+}
+
+// NewJSONLinesSource opens the file at path and starts reading messages from it
+func NewJSONLinesSource(path string) (*JSONLinesSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &JSONLinesSource{
+		file:     file,
+		messages: make(chan *sarama.ConsumerMessage),
+		errors:   make(chan error),
+		closing:  make(chan struct{}),
+	}
+	go s.read(path)
+	return s, nil
+}
+
+func (s *JSONLinesSource) read(path string) {
+	defer close(s.messages)
+
+	scanner := bufio.NewScanner(s.file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+	var line int64
+	for scanner.Scan() {
+		line++
+		value := bytes.TrimSpace(scanner.Bytes())
+		if len(value) == 0 {
+			continue
+		}
+
+		message := &sarama.ConsumerMessage{Topic: path, Offset: line, Value: append([]byte(nil), value...)}
+		select {
+		case s.messages <- message:
+		case <-s.closing:
+			return
+		}
+	}
+
+	// The error is reported before the messages channel is closed, so that it is not missed
+	if err := scanner.Err(); err != nil {
+		select {
+		case s.errors <- err:
+		case <-s.closing:
+		}
+	}
+}
+
+// Messages returns the messages read from the file
+func (s *JSONLinesSource) Messages() <-chan *sarama.ConsumerMessage {
+	return s.messages
+}
+
+// Errors returns the error reading the file, if there is one
+func (s *JSONLinesSource) Errors() <-chan error {
+	return s.errors
+}
+
+// Ack does nothing, as the file is always read from the start
+func (s *JSONLinesSource) Ack(msg *sarama.ConsumerMessage) error {
+	return nil
+}
+
+// Close stops reading the file
+func (s *JSONLinesSource) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.closing)
+		err = s.file.Close()
+	})
+	return err
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/payment-reconciliation-consumer/data"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitChannelSource(t *testing.T) {
+
+	Convey("Given a channel source holding messages", t, func() {
+		first := &sarama.ConsumerMessage{Offset: 1}
+		second := &sarama.ConsumerMessage{Offset: 2}
+		source := NewChannelSource(first, second)
+
+		Convey("Then the messages are delivered in order, and then there are no more", func() {
+			So(<-source.Messages(), ShouldEqual, first)
+			So(<-source.Messages(), ShouldEqual, second)
+			_, ok := <-source.Messages()
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Then the messages acknowledged are recorded", func() {
+			So(source.Ack(second), ShouldBeNil)
+
+			So(source.Acked(), ShouldResemble, []*sarama.ConsumerMessage{second})
+		})
+	})
+}
+
+func TestUnitJSONLinesSource(t *testing.T) {
+
+	Convey("Given a file of captured payment-processed events", t, func() {
+		path := filepath.Join(t.TempDir(), "events.jsonl")
+		events := `{"payment_resource_id":"P1","attempt":1}
+
+{"payment_resource_id":"P2","refund_id":"R1","attempt":2}
+`
+		So(os.WriteFile(path, []byte(events), 0o644), ShouldBeNil)
+
+		source, err := NewJSONLinesSource(path)
+		So(err, ShouldBeNil)
+		defer source.Close()
+
+		Convey("Then each line is delivered as a message, with its line number as the offset", func() {
+			var messages []*sarama.ConsumerMessage
+			for message := range source.Messages() {
+				messages = append(messages, message)
+			}
+
+			So(messages, ShouldHaveLength, 2)
+			So(messages[0].Offset, ShouldEqual, 1)
+			So(messages[1].Offset, ShouldEqual, 3)
+
+			var pp data.PaymentProcessed
+			So(DecodeJSON(messages[1].Value, &pp), ShouldBeNil)
+			So(pp, ShouldResemble, data.PaymentProcessed{ResourceURI: "P2", RefundId: "R1", Attempt: 2})
+		})
+	})
+
+	Convey("Given a file which does not exist", t, func() {
+		_, err := NewJSONLinesSource(filepath.Join(t.TempDir(), "missing.jsonl"))
+
+		Convey("Then the source cannot be created", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestUnitDecodeJSON(t *testing.T) {
+
+	Convey("Given an event without a payment resource ID", t, func() {
+		var pp data.PaymentProcessed
+		err := DecodeJSON([]byte(`{"refund_id":"R1"}`), &pp)
+
+		Convey("Then it cannot be decoded", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given an event which is not JSON", t, func() {
+		var pp data.PaymentProcessed
+		err := DecodeJSON([]byte(`payment_resource_id=P1`), &pp)
+
+		Convey("Then it cannot be decoded", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -37,3 +37,48 @@ func SetupMongoContainer() (testcontainers.Container, string, error) {
 	uri := fmt.Sprintf("mongodb://%s", endpoint)
 	return mongoC, uri, nil
 }
+
+// SetupMongoReplicaSetContainer starts a single node replica set so that multi-document transactions can be tested
+func SetupMongoReplicaSetContainer() (testcontainers.Container, string, error) {
+	ctx := context.Background()
+
+	req := testcontainers.ContainerRequest{
+		Image:        "mongo:6.0",
+		ExposedPorts: []string{"27017/tcp"},
+		Cmd:          []string{"--replSet", "rs0", "--bind_ip_all"},
+		WaitingFor:   wait.ForLog("Waiting for connections").WithStartupTimeout(time.Second * 30),
+	}
+
+	mongoC, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+
+	if err != nil {
+		log.Fatalf("Failed to start Mongo container: %v", err)
+		return nil, "", err
+	}
+
+	if _, _, err := mongoC.Exec(ctx, []string{"mongosh", "--quiet", "--eval",
+		"rs.initiate({_id: 'rs0', members: [{_id: 0, host: 'localhost:27017'}]})"}); err != nil {
+		return nil, "", err
+	}
+
+	// Wait for the node to elect itself primary before handing it out
+	for i := 0; i < 30; i++ {
+		code, _, err := mongoC.Exec(ctx, []string{"mongosh", "--quiet", "--eval",
+			"quit(db.hello().isWritablePrimary ? 0 : 1)"})
+		if err == nil && code == 0 {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+
+	endpoint, err := mongoC.Endpoint(ctx, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	uri := fmt.Sprintf("mongodb://%s/?directConnection=true", endpoint)
+	return mongoC, uri, nil
+}
@@ -5,6 +5,7 @@
 package transformer
 
 import (
+	config "github.com/companieshouse/payment-reconciliation-consumer/config"
 	data "github.com/companieshouse/payment-reconciliation-consumer/data"
 	models "github.com/companieshouse/payment-reconciliation-consumer/models"
 	gomock "github.com/golang/mock/gomock"
@@ -35,46 +36,61 @@ func (m *MockTransformer) EXPECT() *MockTransformerMockRecorder {
 }
 
 // GetEshuResources mocks base method
-func (m *MockTransformer) GetEshuResources(payment data.PaymentResponse, paymentDetails data.PaymentDetailsResponse, paymentId string) ([]models.EshuResourceDao, error) {
+func (m *MockTransformer) GetEshuResources(productMap *config.ProductMap, payment data.PaymentResponse, paymentDetails data.PaymentDetailsResponse, paymentId string) ([]models.EshuResourceDao, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetEshuResources", payment, paymentDetails, paymentId)
+	ret := m.ctrl.Call(m, "GetEshuResources", productMap, payment, paymentDetails, paymentId)
 	ret0, _ := ret[0].([]models.EshuResourceDao)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetEshuResources indicates an expected call of GetEshuResources
-func (mr *MockTransformerMockRecorder) GetEshuResources(payment, paymentDetails, paymentId interface{}) *gomock.Call {
+func (mr *MockTransformerMockRecorder) GetEshuResources(productMap, payment, paymentDetails, paymentId interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEshuResources", reflect.TypeOf((*MockTransformer)(nil).GetEshuResources), payment, paymentDetails, paymentId)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEshuResources", reflect.TypeOf((*MockTransformer)(nil).GetEshuResources), productMap, payment, paymentDetails, paymentId)
 }
 
 // GetTransactionResources mocks base method
-func (m *MockTransformer) GetTransactionResources(payment data.PaymentResponse, paymentDetails data.PaymentDetailsResponse, paymentId string) ([]models.PaymentTransactionsResourceDao, error) {
+func (m *MockTransformer) GetTransactionResources(productMap *config.ProductMap, payment data.PaymentResponse, paymentDetails data.PaymentDetailsResponse, paymentId string) ([]models.PaymentTransactionsResourceDao, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetTransactionResources", payment, paymentDetails, paymentId)
+	ret := m.ctrl.Call(m, "GetTransactionResources", productMap, payment, paymentDetails, paymentId)
 	ret0, _ := ret[0].([]models.PaymentTransactionsResourceDao)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetTransactionResources indicates an expected call of GetTransactionResources
-func (mr *MockTransformerMockRecorder) GetTransactionResources(payment, paymentDetails, paymentId interface{}) *gomock.Call {
+func (mr *MockTransformerMockRecorder) GetTransactionResources(productMap, payment, paymentDetails, paymentId interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTransactionResources", reflect.TypeOf((*MockTransformer)(nil).GetTransactionResources), payment, paymentDetails, paymentId)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTransactionResources", reflect.TypeOf((*MockTransformer)(nil).GetTransactionResources), productMap, payment, paymentDetails, paymentId)
 }
 
 // GetRefundResource mocks base method
-func (m *MockTransformer) GetRefundResource(payment data.PaymentResponse, refund data.RefundResource, paymentId string) (models.RefundResourceDao, error) {
+func (m *MockTransformer) GetRefundResource(productMap *config.ProductMap, payment data.PaymentResponse, paymentDetails data.PaymentDetailsResponse, refund data.RefundResource, paymentId string) (models.RefundResourceDao, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetRefundResource", payment, refund, paymentId)
+	ret := m.ctrl.Call(m, "GetRefundResource", productMap, payment, paymentDetails, refund, paymentId)
 	ret0, _ := ret[0].(models.RefundResourceDao)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetRefundResource indicates an expected call of GetRefundResource
-func (mr *MockTransformerMockRecorder) GetRefundResource(payment, refund, paymentId interface{}) *gomock.Call {
+func (mr *MockTransformerMockRecorder) GetRefundResource(productMap, payment, paymentDetails, refund, paymentId interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRefundResource", reflect.TypeOf((*MockTransformer)(nil).GetRefundResource), payment, refund, paymentId)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRefundResource", reflect.TypeOf((*MockTransformer)(nil).GetRefundResource), productMap, payment, paymentDetails, refund, paymentId)
+}
+
+// GetSkippedCostResources mocks base method
+func (m *MockTransformer) GetSkippedCostResources(productMap *config.ProductMap, payment data.PaymentResponse, paymentDetails data.PaymentDetailsResponse, paymentId string) ([]models.SkippedCostResourceDao, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSkippedCostResources", productMap, payment, paymentDetails, paymentId)
+	ret0, _ := ret[0].([]models.SkippedCostResourceDao)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSkippedCostResources indicates an expected call of GetSkippedCostResources
+func (mr *MockTransformerMockRecorder) GetSkippedCostResources(productMap, payment, paymentDetails, paymentId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSkippedCostResources", reflect.TypeOf((*MockTransformer)(nil).GetSkippedCostResources), productMap, payment, paymentDetails, paymentId)
 }
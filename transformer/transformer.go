@@ -1,63 +1,98 @@
 package transformer
 
 import (
+	"errors"
+	"fmt"
 	"github.com/companieshouse/payment-reconciliation-consumer/config"
 	"github.com/companieshouse/payment-reconciliation-consumer/data"
+	"github.com/companieshouse/payment-reconciliation-consumer/masking"
 	"github.com/companieshouse/payment-reconciliation-consumer/models"
-	"strconv"
+	"github.com/companieshouse/payment-reconciliation-consumer/money"
 	"strings"
 	"time"
 )
 
-// Transformer provides an interface by which to transform payment models to reconciliation entities
+// ErrMissingProductCode is returned when a cost has a product type with no product code in the product map
+var ErrMissingProductCode = errors.New("no product code mapped for product type")
+
+// ErrMissingPaymentDate is returned when neither the payment nor its details say when the payment was made, so the
+// product code in force at the time cannot be found
+var ErrMissingPaymentDate = errors.New("payment has no completion or transaction date")
+
+// Transformer provides an interface by which to transform payment models to reconciliation entities. Product codes
+// are looked up in the product map given, so that every record for a message is built from the same map even if the
+// map is reloaded while the message is being handled.
 type Transformer interface {
-	GetEshuResources(payment data.PaymentResponse, paymentDetails data.PaymentDetailsResponse, paymentId string) ([]models.EshuResourceDao, error)
-	GetTransactionResources(payment data.PaymentResponse, paymentDetails data.PaymentDetailsResponse, paymentId string) ([]models.PaymentTransactionsResourceDao, error)
-	GetRefundResource(payment data.PaymentResponse, refund data.RefundResource, paymentId string) (models.RefundResourceDao, error)
+	GetEshuResources(productMap *config.ProductMap, payment data.PaymentResponse, paymentDetails data.PaymentDetailsResponse, paymentId string) ([]models.EshuResourceDao, error)
+	GetTransactionResources(productMap *config.ProductMap, payment data.PaymentResponse, paymentDetails data.PaymentDetailsResponse, paymentId string) ([]models.PaymentTransactionsResourceDao, error)
+	GetRefundResource(productMap *config.ProductMap, payment data.PaymentResponse, paymentDetails data.PaymentDetailsResponse, refund data.RefundResource, paymentId string) (models.RefundResourceDao, error)
+	GetSkippedCostResources(productMap *config.ProductMap, payment data.PaymentResponse, paymentDetails data.PaymentDetailsResponse, paymentId string) ([]models.SkippedCostResourceDao, error)
 }
 
-// Transform implements the Transformer interface
-type Transform struct{}
+// Transform implements the Transformer interface. Records are masked using the Masking policy, if there is one.
+type Transform struct {
+	Masking *masking.Policy
+}
 
 // New returns a new implementation of the Transformer interface
-func New() *Transform {
+func New(policy *masking.Policy) *Transform {
 
-	return &Transform{}
+	return &Transform{Masking: policy}
 }
 
-// GetEshuResources transforms payment data into Eshu resource entities
-func (t *Transform) GetEshuResources(payment data.PaymentResponse,
+// GetEshuResources transforms the reconcilable costs of a payment into Eshu resource entities, using the product
+// codes effective at the transaction date of the payment
+func (t *Transform) GetEshuResources(productMap *config.ProductMap,
+	payment data.PaymentResponse,
 	paymentDetails data.PaymentDetailsResponse,
 	paymentId string) ([]models.EshuResourceDao, error) {
 
 	eshuResources := []models.EshuResourceDao{}
 
-	productMap, err := config.GetProductMap()
-	if err != nil {
-		return eshuResources, err
-	}
-
 	transactionDate, err := time.Parse(time.RFC3339Nano, paymentDetails.TransactionDate)
 	if err != nil {
 		return eshuResources, err
 	}
 
-	for _, cost := range payment.Costs {
-		eshuResources = append(eshuResources, models.EshuResourceDao{
+	for i, cost := range payment.Costs {
+		if reconcilable, _ := cost.IsReconcilable(productMap, transactionDate); !reconcilable {
+			continue
+		}
+
+		productCode, err := getProductCode(productMap, cost.ProductType, transactionDate)
+		if err != nil {
+			return []models.EshuResourceDao{}, err
+		}
+
+		amount, err := money.Parse(cost.Amount)
+		if err != nil {
+			return []models.EshuResourceDao{}, err
+		}
+
+		eshuResource := models.EshuResourceDao{
 			PaymentRef:      "X" + paymentId,
-			ProductCode:     productMap.Codes[cost.ProductType],
+			ProductCode:     productCode,
 			CompanyNumber:   payment.CompanyNumber,
 			FilingDate:      "",
 			MadeUpdate:      "",
 			TransactionDate: transactionDate,
+			CostLine:        i,
+			Amount:          amount.String(),
+			AmountPence:     amount,
+		}
+		t.Masking.Apply(cost.ProductType, productCode, masking.Fields{
+			masking.CompanyNumber: &eshuResource.CompanyNumber,
 		})
+
+		eshuResources = append(eshuResources, eshuResource)
 	}
 
 	return eshuResources, nil
 }
 
-// GetTransactionResources transforms payment data into payment transaction resource entities
-func (t *Transform) GetTransactionResources(payment data.PaymentResponse,
+// GetTransactionResources transforms the reconcilable costs of a payment into payment transaction resource entities
+func (t *Transform) GetTransactionResources(productMap *config.ProductMap,
+	payment data.PaymentResponse,
 	paymentDetails data.PaymentDetailsResponse,
 	paymentId string) ([]models.PaymentTransactionsResourceDao, error) {
 
@@ -68,13 +103,23 @@ func (t *Transform) GetTransactionResources(payment data.PaymentResponse,
 		return paymentTransactionsResources, err
 	}
 
-	for _, cost := range payment.Costs {
-		paymentTransactionsResources = append(paymentTransactionsResources, models.PaymentTransactionsResourceDao{
+	for i, cost := range payment.Costs {
+		if reconcilable, _ := cost.IsReconcilable(productMap, transactionDate); !reconcilable {
+			continue
+		}
+
+		amount, err := money.Parse(cost.Amount)
+		if err != nil {
+			return []models.PaymentTransactionsResourceDao{}, err
+		}
+
+		paymentTransactionsResource := models.PaymentTransactionsResourceDao{
 			TransactionID:     "X" + paymentId,
 			TransactionDate:   transactionDate,
 			Email:             payment.CreatedBy.Email,
 			PaymentMethod:     payment.PaymentMethod,
-			Amount:            cost.Amount,
+			Amount:            amount.String(),
+			AmountPence:       amount,
 			CompanyNumber:     payment.CompanyNumber,
 			TransactionType:   "Immediate bill",
 			OrderReference:    strings.Replace(payment.Reference, "_", "-", -1),
@@ -82,14 +127,74 @@ func (t *Transform) GetTransactionResources(payment data.PaymentResponse,
 			UserID:            "system",
 			OriginalReference: "",
 			DisputeDetails:    "",
+			CostLine:          i,
+		}
+		t.Masking.Apply(cost.ProductType, productMap.Code(cost.ProductType, transactionDate), masking.Fields{
+			masking.CompanyNumber:  &paymentTransactionsResource.CompanyNumber,
+			masking.Email:          &paymentTransactionsResource.Email,
+			masking.OrderReference: &paymentTransactionsResource.OrderReference,
 		})
+
+		paymentTransactionsResources = append(paymentTransactionsResources, paymentTransactionsResource)
 	}
 
 	return paymentTransactionsResources, nil
 }
 
-// GetTransactionResources transforms payment data into payment transaction resource entities
-func (t *Transform) GetRefundResource(payment data.PaymentResponse,
+// GetSkippedCostResources records the costs of a payment which are not reconcilable, and the reason why
+func (t *Transform) GetSkippedCostResources(productMap *config.ProductMap,
+	payment data.PaymentResponse,
+	paymentDetails data.PaymentDetailsResponse,
+	paymentId string) ([]models.SkippedCostResourceDao, error) {
+
+	skippedCostResources := []models.SkippedCostResourceDao{}
+
+	transactionDate, err := time.Parse(time.RFC3339Nano, paymentDetails.TransactionDate)
+	if err != nil {
+		return skippedCostResources, err
+	}
+
+	for i, cost := range payment.Costs {
+		reconcilable, reason := cost.IsReconcilable(productMap, transactionDate)
+		if reconcilable {
+			continue
+		}
+
+		// The cost is not reconciled, so an amount which cannot be parsed is recorded as it is rather than failing
+		// the reconcilable costs of the payment
+		amountText := cost.Amount
+		amount, err := money.Parse(cost.Amount)
+		if err != nil {
+			reason = fmt.Sprintf("%s; amount cannot be parsed: %s", reason, err)
+		} else {
+			amountText = amount.String()
+		}
+
+		classOfPayment := ""
+		if len(cost.ClassOfPayment) > 0 {
+			classOfPayment = cost.ClassOfPayment[0]
+		}
+
+		skippedCostResources = append(skippedCostResources, models.SkippedCostResourceDao{
+			PaymentRef:      "X" + paymentId,
+			CostLine:        i,
+			ClassOfPayment:  classOfPayment,
+			ProductType:     cost.ProductType,
+			Amount:          amountText,
+			AmountPence:     amount,
+			Reason:          reason,
+			TransactionDate: transactionDate,
+		})
+	}
+
+	return skippedCostResources, nil
+}
+
+// GetRefundResource transforms refund data into a refund resource entity, using the product code of the first
+// reconcilable cost of the payment effective when the payment was made
+func (t *Transform) GetRefundResource(productMap *config.ProductMap,
+	payment data.PaymentResponse,
+	paymentDetails data.PaymentDetailsResponse,
 	refund data.RefundResource,
 	paymentId string) (models.RefundResourceDao, error) {
 
@@ -100,7 +205,18 @@ func (t *Transform) GetRefundResource(payment data.PaymentResponse,
 		return refundResource, err
 	}
 
-	productMap, err := config.GetProductMap()
+	paidAt, err := paymentDate(payment, paymentDetails)
+	if err != nil {
+		return refundResource, err
+	}
+
+	productType := refundProductType(payment, productMap, paidAt)
+	productCode, err := getProductCode(productMap, productType, paidAt)
+	if err != nil {
+		return refundResource, err
+	}
+
+	amount, err := money.FromPence(refund.Amount)
 	if err != nil {
 		return refundResource, err
 	}
@@ -113,7 +229,8 @@ func (t *Transform) GetRefundResource(payment data.PaymentResponse,
 		PaymentID:         paymentId,
 		Email:             payment.CreatedBy.Email,
 		PaymentMethod:     payment.PaymentMethod,
-		Amount:            strconv.Itoa(refund.Amount / 100),
+		Amount:            amount.String(),
+		AmountPence:       amount,
 		CompanyNumber:     payment.CompanyNumber,
 		TransactionType:   "Refund",
 		OrderReference:    strings.Replace(payment.Reference, "_", "-", -1),
@@ -121,8 +238,51 @@ func (t *Transform) GetRefundResource(payment data.PaymentResponse,
 		UserID:            "system",
 		OriginalReference: "X" + paymentId,
 		DisputeDetails:    "",
-		ProductCode:       productMap.Codes[payment.Costs[0].ProductType],
+		ProductCode:       productCode,
 	}
+	t.Masking.Apply(productType, productCode, masking.Fields{
+		masking.CompanyNumber:  &refundResource.CompanyNumber,
+		masking.Email:          &refundResource.Email,
+		masking.OrderReference: &refundResource.OrderReference,
+	})
 
 	return refundResource, nil
 }
+
+// IsPermanent reports whether an error returned by a Transformer will recur however often the payment is transformed
+func IsPermanent(err error) bool {
+	var parseErr *time.ParseError
+	return errors.Is(err, ErrMissingProductCode) || errors.Is(err, ErrMissingPaymentDate) ||
+		errors.Is(err, money.ErrInvalidAmount) || errors.As(err, &parseErr)
+}
+
+// refundProductType returns the product type of the first cost of the payment reconcilable at paidAt, falling back to
+// the first cost when none are reconcilable
+func refundProductType(payment data.PaymentResponse, productMap *config.ProductMap, paidAt time.Time) string {
+	for _, cost := range payment.Costs {
+		if reconcilable, _ := cost.IsReconcilable(productMap, paidAt); reconcilable {
+			return cost.ProductType
+		}
+	}
+	return payment.Costs[0].ProductType
+}
+
+// paymentDate returns when the payment was completed, or the transaction date of its details for a payment without a
+// completion date. The date of a refund is never used, as the product code must be the one in force for the payment.
+func paymentDate(payment data.PaymentResponse, paymentDetails data.PaymentDetailsResponse) (time.Time, error) {
+	if !payment.CompletedAt.IsZero() {
+		return payment.CompletedAt, nil
+	}
+	if paymentDetails.TransactionDate == "" {
+		return time.Time{}, ErrMissingPaymentDate
+	}
+	return time.Parse(time.RFC3339Nano, paymentDetails.TransactionDate)
+}
+
+func getProductCode(productMap *config.ProductMap, productType string, at time.Time) (int, error) {
+	productCode := productMap.Code(productType, at)
+	if productCode == 0 {
+		return 0, fmt.Errorf("%w: [%s] at [%s]", ErrMissingProductCode, productType, at.Format(time.RFC3339))
+	}
+	return productCode, nil
+}
@@ -1,16 +1,28 @@
 package transformer
 
 import (
+	"errors"
+	"github.com/companieshouse/payment-reconciliation-consumer/config"
 	"github.com/companieshouse/payment-reconciliation-consumer/data"
+	"github.com/companieshouse/payment-reconciliation-consumer/masking"
+	"github.com/companieshouse/payment-reconciliation-consumer/money"
 	_ "github.com/companieshouse/payment-reconciliation-consumer/testing"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
+	"time"
 )
 
 const unparsableTransactionDate = "2020-07-27T09:07:12.864" // Should be "2020-07-27T09:07:12.864Z"
 
 func TestUnitErrorHandling(t *testing.T) {
 
+	productMap, err := config.GetProductMap()
+	if err != nil {
+		t.Fatalf("error loading product map: %s", err)
+	}
+	// The details of the payments refunded, which have no completion date
+	paidDetails := data.PaymentDetailsResponse{TransactionDate: "2020-07-27T09:07:12.864Z"}
+
 	Convey("GetEshuResources propagates payment details transaction date parsing error", t, func() {
 
 		// Given
@@ -18,6 +30,7 @@ func TestUnitErrorHandling(t *testing.T) {
 
 		// When
 		_, err := transformerUnderTest.GetEshuResources(
+			productMap,
 			data.PaymentResponse{},
 			data.PaymentDetailsResponse{TransactionDate: unparsableTransactionDate},
 			"paymentId string")
@@ -34,6 +47,7 @@ func TestUnitErrorHandling(t *testing.T) {
 
 		// When
 		_, err := transformerUnderTest.GetTransactionResources(
+			productMap,
 			data.PaymentResponse{},
 			data.PaymentDetailsResponse{TransactionDate: unparsableTransactionDate},
 			"paymentId string")
@@ -49,7 +63,9 @@ func TestUnitErrorHandling(t *testing.T) {
 
 		// When
 		_, err := transformerUnderTest.GetRefundResource(
+			productMap,
 			data.PaymentResponse{},
+			paidDetails,
 			data.RefundResource{CreatedAt: unparsableTransactionDate},
 			"paymentId string")
 
@@ -84,7 +100,9 @@ func TestUnitErrorHandling(t *testing.T) {
 
 		// When
 		resourceDao, err := transformerUnderTest.GetRefundResource(
+			productMap,
 			paymentResponse,
+			paidDetails,
 			refundResource,
 			paymentId)
 
@@ -95,7 +113,8 @@ func TestUnitErrorHandling(t *testing.T) {
 		So(resourceDao.TransactionID, ShouldEqual, "X"+refundResource.RefundId)
 		So(resourceDao.TransactionType, ShouldEqual, "Refund")
 		So(resourceDao.TransactionDate, ShouldNotBeNil)
-		So(resourceDao.Amount, ShouldEqual, "8")
+		So(resourceDao.Amount, ShouldEqual, "8.00")
+		So(resourceDao.AmountPence, ShouldEqual, 800)
 		So(resourceDao.Email, ShouldEqual, paymentResponse.CreatedBy.Email)
 		So(resourceDao.CompanyNumber, ShouldEqual, paymentResponse.CompanyNumber)
 		So(resourceDao.PaymentMethod, ShouldEqual, paymentResponse.PaymentMethod)
@@ -105,4 +124,299 @@ func TestUnitErrorHandling(t *testing.T) {
 		So(resourceDao.ProductCode, ShouldEqual, 16032)
 		So(resourceDao.DisputeDetails, ShouldEqual, "")
 	})
+
+	Convey("GetEshuResources and GetTransactionResources number each cost line", t, func() {
+
+		// Given
+		transformerUnderTest := Transform{}
+		paymentResponse := data.PaymentResponse{
+			Costs: []data.Cost{
+				{ClassOfPayment: []string{data.OrderableItem}, ProductType: "certified-copy-same-day", Amount: "15"},
+				{ClassOfPayment: []string{data.OrderableItem}, ProductType: "certified-copy-same-day", Amount: "15"},
+			},
+		}
+		paymentDetails := data.PaymentDetailsResponse{TransactionDate: "2020-07-27T09:07:12.864Z"}
+
+		// When
+		eshus, eshuErr := transformerUnderTest.GetEshuResources(productMap, paymentResponse, paymentDetails, "paymentId")
+		txns, txnErr := transformerUnderTest.GetTransactionResources(productMap, paymentResponse, paymentDetails, "paymentId")
+
+		// Then
+		So(eshuErr, ShouldBeNil)
+		So(txnErr, ShouldBeNil)
+		So(eshus[0].CostLine, ShouldEqual, 0)
+		So(eshus[1].CostLine, ShouldEqual, 1)
+		So(txns[0].CostLine, ShouldEqual, 0)
+		So(txns[1].CostLine, ShouldEqual, 1)
+	})
+
+	Convey("GetEshuResources and GetTransactionResources skip costs which are not reconcilable", t, func() {
+
+		// Given
+		transformerUnderTest := Transform{}
+		paymentResponse := data.PaymentResponse{
+			Costs: []data.Cost{
+				{ClassOfPayment: []string{data.Penalty}, ProductType: "penalty-lfp", Amount: "150"},
+				{ClassOfPayment: []string{data.OrderableItem}, ProductType: "certified-copy-same-day", Amount: "15"},
+				{ClassOfPayment: []string{data.OrderableItem}, ProductType: "unmapped-product", Amount: "15"},
+			},
+		}
+		paymentDetails := data.PaymentDetailsResponse{TransactionDate: "2020-07-27T09:07:12.864Z"}
+
+		// When
+		eshus, eshuErr := transformerUnderTest.GetEshuResources(productMap, paymentResponse, paymentDetails, "paymentId")
+		txns, txnErr := transformerUnderTest.GetTransactionResources(productMap, paymentResponse, paymentDetails, "paymentId")
+		skippedCosts, skippedErr := transformerUnderTest.GetSkippedCostResources(productMap, paymentResponse, paymentDetails, "paymentId")
+
+		// Then
+		So(eshuErr, ShouldBeNil)
+		So(txnErr, ShouldBeNil)
+		So(skippedErr, ShouldBeNil)
+		So(eshus, ShouldHaveLength, 1)
+		So(eshus[0].CostLine, ShouldEqual, 1)
+		So(txns, ShouldHaveLength, 1)
+		So(txns[0].CostLine, ShouldEqual, 1)
+		So(skippedCosts, ShouldHaveLength, 2)
+		So(skippedCosts[0].PaymentRef, ShouldEqual, "XpaymentId")
+		So(skippedCosts[0].CostLine, ShouldEqual, 0)
+		So(skippedCosts[0].ClassOfPayment, ShouldEqual, data.Penalty)
+		So(skippedCosts[0].Amount, ShouldEqual, "150.00")
+		So(skippedCosts[0].AmountPence, ShouldEqual, 15000)
+		So(skippedCosts[0].Reason, ShouldEqual, data.ReasonReconciledElsewhere)
+		So(skippedCosts[1].CostLine, ShouldEqual, 2)
+		So(skippedCosts[1].ProductType, ShouldEqual, "unmapped-product")
+		So(skippedCosts[1].Reason, ShouldEqual, data.ReasonNoProductCode)
+	})
+
+	Convey("GetSkippedCostResources records a skipped cost whose amount cannot be parsed with the parse error", t, func() {
+
+		// Given
+		transformerUnderTest := Transform{}
+		paymentResponse := data.PaymentResponse{
+			Costs: []data.Cost{
+				{ClassOfPayment: []string{data.Penalty}, ProductType: "penalty-lfp", Amount: "not an amount"},
+			},
+		}
+		paymentDetails := data.PaymentDetailsResponse{TransactionDate: "2020-07-27T09:07:12.864Z"}
+
+		// When
+		skippedCosts, err := transformerUnderTest.GetSkippedCostResources(productMap, paymentResponse, paymentDetails, "paymentId")
+
+		// Then
+		So(err, ShouldBeNil)
+		So(skippedCosts, ShouldHaveLength, 1)
+		So(skippedCosts[0].Amount, ShouldEqual, "not an amount")
+		So(skippedCosts[0].Reason, ShouldStartWith, data.ReasonReconciledElsewhere+"; amount cannot be parsed")
+	})
+
+	Convey("GetRefundResource uses the product code of the first reconcilable cost", t, func() {
+
+		// Given
+		transformerUnderTest := Transform{}
+		paymentResponse := data.PaymentResponse{
+			Costs: []data.Cost{
+				{ClassOfPayment: []string{data.Penalty}, ProductType: "penalty-lfp"},
+				{ClassOfPayment: []string{data.DataMaintenance}, ProductType: "ds01"},
+			},
+		}
+		refundResource := data.RefundResource{CreatedAt: "2020-10-21T15:48:30.551Z", Amount: 800}
+
+		// When
+		resourceDao, err := transformerUnderTest.GetRefundResource(productMap, paymentResponse, paidDetails, refundResource, "paymentId")
+
+		// Then
+		So(err, ShouldBeNil)
+		So(resourceDao.ProductCode, ShouldEqual, 16032)
+	})
+
+	Convey("A transaction date parsing error is permanent", t, func() {
+
+		// Given
+		transformerUnderTest := Transform{}
+
+		// When
+		_, err := transformerUnderTest.GetTransactionResources(
+			productMap,
+			data.PaymentResponse{},
+			data.PaymentDetailsResponse{TransactionDate: unparsableTransactionDate},
+			"paymentId string")
+
+		// Then
+		So(IsPermanent(err), ShouldBeTrue)
+	})
+
+	Convey("GetRefundResource keeps the pence of a refund", t, func() {
+
+		// Given
+		transformerUnderTest := Transform{}
+		paymentResponse := data.PaymentResponse{Costs: []data.Cost{{ProductType: "ds01"}}}
+		refundResource := data.RefundResource{CreatedAt: "2020-10-21T15:48:30.551Z", Amount: 1250}
+
+		// When
+		resourceDao, err := transformerUnderTest.GetRefundResource(productMap, paymentResponse, paidDetails, refundResource, "paymentId")
+
+		// Then
+		So(err, ShouldBeNil)
+		So(resourceDao.Amount, ShouldEqual, "12.50")
+		So(resourceDao.AmountPence, ShouldEqual, 1250)
+	})
+
+	Convey("GetEshuResources and GetTransactionResources record exact amounts for each cost", t, func() {
+
+		// Given
+		transformerUnderTest := Transform{}
+		paymentResponse := data.PaymentResponse{
+			Costs: []data.Cost{{ClassOfPayment: []string{data.OrderableItem}, ProductType: "certified-copy-same-day", Amount: "12.5"}},
+		}
+		paymentDetails := data.PaymentDetailsResponse{TransactionDate: "2020-07-27T09:07:12.864Z"}
+
+		// When
+		eshus, eshuErr := transformerUnderTest.GetEshuResources(productMap, paymentResponse, paymentDetails, "paymentId")
+		txns, txnErr := transformerUnderTest.GetTransactionResources(productMap, paymentResponse, paymentDetails, "paymentId")
+
+		// Then
+		So(eshuErr, ShouldBeNil)
+		So(txnErr, ShouldBeNil)
+		So(eshus[0].Amount, ShouldEqual, "12.50")
+		So(eshus[0].AmountPence, ShouldEqual, 1250)
+		So(txns[0].Amount, ShouldEqual, "12.50")
+		So(txns[0].AmountPence, ShouldEqual, 1250)
+	})
+
+	Convey("GetEshuResources and GetTransactionResources reject an unparsable amount", t, func() {
+
+		// Given
+		transformerUnderTest := Transform{}
+		paymentResponse := data.PaymentResponse{
+			Costs: []data.Cost{
+				{ClassOfPayment: []string{data.OrderableItem}, ProductType: "certified-copy-same-day", Amount: "fifteen"},
+				{ClassOfPayment: []string{data.Penalty}, ProductType: "penalty-lfp", Amount: "one fifty"},
+			},
+		}
+		paymentDetails := data.PaymentDetailsResponse{TransactionDate: "2020-07-27T09:07:12.864Z"}
+
+		// When
+		_, eshuErr := transformerUnderTest.GetEshuResources(productMap, paymentResponse, paymentDetails, "paymentId")
+		_, txnErr := transformerUnderTest.GetTransactionResources(productMap, paymentResponse, paymentDetails, "paymentId")
+
+		// Then
+		So(errors.Is(eshuErr, money.ErrInvalidAmount), ShouldBeTrue)
+		So(errors.Is(txnErr, money.ErrInvalidAmount), ShouldBeTrue)
+		So(IsPermanent(txnErr), ShouldBeTrue)
+	})
+
+	Convey("GetEshuResources and GetRefundResource use the product code effective at the payment date", t, func() {
+
+		// Given
+		dated := &config.ProductMap{Codes: map[string]config.ProductCodes{}}
+		for productType, codes := range productMap.Codes {
+			dated.Codes[productType] = codes
+		}
+		changeDate := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+		dated.Codes["re-priced-product"] = config.ProductCodes{
+			{Code: 27100, EffectiveTo: changeDate},
+			{Code: 27101, EffectiveFrom: changeDate},
+		}
+		So(dated.Validate(), ShouldBeNil)
+
+		transformerUnderTest := Transform{}
+		paymentResponse := data.PaymentResponse{
+			CompletedAt: time.Date(2025, 3, 31, 12, 0, 0, 0, time.UTC),
+			Costs:       []data.Cost{{ClassOfPayment: []string{data.OrderableItem}, ProductType: "re-priced-product", Amount: "15"}},
+		}
+
+		// When
+		before, beforeErr := transformerUnderTest.GetEshuResources(dated, paymentResponse, data.PaymentDetailsResponse{TransactionDate: "2025-03-31T12:00:00Z"}, "paymentId")
+		after, afterErr := transformerUnderTest.GetEshuResources(dated, paymentResponse, data.PaymentDetailsResponse{TransactionDate: "2025-04-01T12:00:00Z"}, "paymentId")
+		refund, refundErr := transformerUnderTest.GetRefundResource(dated, paymentResponse, data.PaymentDetailsResponse{}, data.RefundResource{CreatedAt: "2025-04-02T12:00:00Z"}, "paymentId")
+
+		// Then
+		So(beforeErr, ShouldBeNil)
+		So(afterErr, ShouldBeNil)
+		So(refundErr, ShouldBeNil)
+		So(before[0].ProductCode, ShouldEqual, 27100)
+		So(after[0].ProductCode, ShouldEqual, 27101)
+		So(refund.ProductCode, ShouldEqual, 27100)
+
+		// Without a completion date the transaction date of the payment is used, never the date of the refund
+		paymentResponse.CompletedAt = time.Time{}
+		refund, refundErr = transformerUnderTest.GetRefundResource(dated, paymentResponse,
+			data.PaymentDetailsResponse{TransactionDate: "2025-03-31T12:00:00Z"}, data.RefundResource{CreatedAt: "2025-04-02T12:00:00Z"}, "paymentId")
+		So(refundErr, ShouldBeNil)
+		So(refund.ProductCode, ShouldEqual, 27100)
+
+		_, refundErr = transformerUnderTest.GetRefundResource(dated, paymentResponse, data.PaymentDetailsResponse{},
+			data.RefundResource{CreatedAt: "2025-04-02T12:00:00Z"}, "paymentId")
+		So(errors.Is(refundErr, ErrMissingPaymentDate), ShouldBeTrue)
+		So(IsPermanent(refundErr), ShouldBeTrue)
+	})
+
+	Convey("A cost whose product type only has a code at other dates is skipped rather than failing the payment", t, func() {
+
+		// Given
+		dated := &config.ProductMap{Codes: map[string]config.ProductCodes{}}
+		for productType, codes := range productMap.Codes {
+			dated.Codes[productType] = codes
+		}
+		dated.Codes["new-product"] = config.ProductCodes{{Code: 27102, EffectiveFrom: time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)}}
+		So(dated.Validate(), ShouldBeNil)
+
+		transformerUnderTest := Transform{}
+		paymentResponse := data.PaymentResponse{
+			Costs: []data.Cost{
+				{ClassOfPayment: []string{data.OrderableItem}, ProductType: "certified-copy-same-day", Amount: "15"},
+				{ClassOfPayment: []string{data.OrderableItem}, ProductType: "new-product", Amount: "10"},
+			},
+		}
+		paymentDetails := data.PaymentDetailsResponse{TransactionDate: "2025-03-31T12:00:00Z"}
+
+		// When
+		eshus, eshuErr := transformerUnderTest.GetEshuResources(dated, paymentResponse, paymentDetails, "paymentId")
+		skippedCosts, skippedErr := transformerUnderTest.GetSkippedCostResources(dated, paymentResponse, paymentDetails, "paymentId")
+
+		// Then
+		So(eshuErr, ShouldBeNil)
+		So(skippedErr, ShouldBeNil)
+		So(eshus, ShouldHaveLength, 1)
+		So(skippedCosts, ShouldHaveLength, 1)
+		So(skippedCosts[0].CostLine, ShouldEqual, 1)
+		So(skippedCosts[0].Reason, ShouldEqual, data.ReasonNoProductCode)
+	})
+
+	Convey("Records for costs of secure applications are masked by the masking policy", t, func() {
+
+		// Given
+		policy, err := masking.Load(masking.PolicyFile)
+		So(err, ShouldBeNil)
+		transformerUnderTest := New(policy)
+		paymentResponse := data.PaymentResponse{
+			CompanyNumber: "123456",
+			CreatedBy:     data.Created{Email: "test@ch.gov.uk"},
+			Costs: []data.Cost{
+				{ClassOfPayment: []string{data.DataMaintenance}, ProductType: "pro-app-1", Amount: "10"},
+				{ClassOfPayment: []string{data.DataMaintenance}, ProductType: "cic-report", Amount: "15"},
+			},
+		}
+		paymentDetails := data.PaymentDetailsResponse{TransactionDate: "2020-07-27T09:07:12.864Z"}
+
+		// When
+		eshus, eshuErr := transformerUnderTest.GetEshuResources(productMap, paymentResponse, paymentDetails, "paymentId")
+		txns, txnErr := transformerUnderTest.GetTransactionResources(productMap, paymentResponse, paymentDetails, "paymentId")
+		refund, refundErr := transformerUnderTest.GetRefundResource(productMap, paymentResponse, paidDetails, data.RefundResource{CreatedAt: "2020-10-21T15:48:30.551Z"}, "paymentId")
+
+		// Then
+		So(eshuErr, ShouldBeNil)
+		So(txnErr, ShouldBeNil)
+		So(refundErr, ShouldBeNil)
+		So(eshus[0].CompanyNumber, ShouldEqual, "")
+		So(txns[0].CompanyNumber, ShouldEqual, "")
+		So(txns[0].Email, ShouldEqual, "")
+		So(refund.CompanyNumber, ShouldEqual, "")
+		So(refund.Email, ShouldEqual, "")
+
+		// Costs of other products are not masked
+		So(eshus[1].CompanyNumber, ShouldEqual, "123456")
+		So(txns[1].CompanyNumber, ShouldEqual, "123456")
+		So(txns[1].Email, ShouldEqual, "test@ch.gov.uk")
+	})
 }